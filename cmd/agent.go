@@ -54,6 +54,9 @@ func init() {
 	rootCmd.AddCommand(agentCmd)
 
 	agentCmd.PersistentFlags().StringP("agent.address", "a", "", "example-rmm.com:1234")
+	agentCmd.PersistentFlags().StringP("token", "t", "", "enrollment token to enroll without interactive approval")
+	agentCmd.PersistentFlags().Bool("agent.rotate-key", false, "generate a new key when renewing the agent certificate")
+	agentCmd.PersistentFlags().Bool("agent.record-shell-input", false, "record the keystrokes of remote shell sessions, they may contain passwords")
 
 	// Here you will define your flags and configuration settings.
 
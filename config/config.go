@@ -98,3 +98,11 @@ func (c *Config) String(key string) string {
 
 	return ""
 }
+
+func (c *Config) Bool(key string) bool {
+	return cast.ToBool(c.String(key))
+}
+
+func (c *Config) Int(key string) int {
+	return cast.ToInt(c.String(key))
+}
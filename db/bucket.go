@@ -9,8 +9,11 @@ import (
 type Bucket interface {
 	Get(key []byte) []byte
 	Put(key, value []byte) error
+	Delete(key []byte) error
 	ForEach(func(k, v []byte) error) error
 	ForPrefix(prefix []byte, fn func(k, v []byte) error) error
+	// ForPrefixFrom is like ForPrefix, but starts at the first key that is not ordered before from.
+	ForPrefixFrom(prefix []byte, from []byte, fn func(k, v []byte) error) error
 }
 
 type bucket struct {
@@ -34,3 +37,19 @@ func (b *bucket) ForPrefix(prefix []byte, fn func(k, v []byte) error) error {
 
 	return nil
 }
+
+func (b *bucket) ForPrefixFrom(prefix []byte, from []byte, fn func(k, v []byte) error) error {
+	if bytes.Compare(from, prefix) < 0 {
+		from = prefix
+	}
+
+	cursor := b.Cursor()
+	for k, v := cursor.Seek(from); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
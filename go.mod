@@ -15,6 +15,7 @@ require (
 )
 
 require (
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/fyne-io/terminal v0.0.0-20231218140004-dace67838bef
 	github.com/spf13/cast v1.6.0
 	github.com/spf13/pflag v1.0.5
@@ -56,6 +57,7 @@ require (
 	github.com/tevino/abool v1.2.0 // indirect
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	github.com/yuin/goldmark v1.5.5 // indirect
 	github.com/yusufpapurcu/wmi v1.2.3 // indirect
 	go.uber.org/mock v0.3.0 // indirect
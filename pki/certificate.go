@@ -8,6 +8,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"log"
+	"time"
 )
 
 var _ encoding.TextUnmarshaler = (*Certificate)(nil)
@@ -30,6 +31,20 @@ func (cert *Certificate) UnmarshalText(data []byte) error {
 	return nil
 }
 
+func (cert *Certificate) MarshalBinary() ([]byte, error) {
+	return cert.BinaryEncode(), nil
+}
+
+func (cert *Certificate) UnmarshalBinary(data []byte) error {
+	newCert, err := CertificateFromBinary(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode certificate: %w", err)
+	}
+
+	*cert = *newCert
+	return nil
+}
+
 func (c *Certificate) BinaryEncode() []byte {
 	return c.cert.Raw
 }
@@ -96,7 +111,7 @@ func (c *Certificate) Type() CertType {
 
 	t := CertType(c.cert.Subject.OrganizationalUnit[0])
 
-	if t == CertTypeUser || t == CertTypeRoot {
+	if t == CertTypeUser || t == CertTypeRoot || t == CertTypeSigner {
 		if !c.cert.IsCA {
 			log.Printf("WARNING: certificate of type %s is not a CA", t)
 			return CertTypeError
@@ -121,3 +136,12 @@ func (c *Certificate) Type() CertType {
 func (c *Certificate) IsCA() bool {
 	return c.cert.IsCA
 }
+
+func (c *Certificate) NotAfter() time.Time {
+	return c.cert.NotAfter
+}
+
+// IsIssuedBy checks if the certificate was signed by the given issuer.
+func (c *Certificate) IsIssuedBy(issuer *Certificate) bool {
+	return c.cert.CheckSignatureFrom(issuer.cert) == nil
+}
@@ -18,6 +18,9 @@ const userValidFor = 10 * 365 * 24 * time.Hour
 const serverValidFor = 10 * 365 * 24 * time.Hour
 const agentValidFor = 2 * 365 * 24 * time.Hour
 
+// AgentRenewBefore is how long before expiry an agent certificate may be renewed.
+const AgentRenewBefore = 30 * 24 * time.Hour
+
 func generateKeypair() (*PrivateKey, error) {
 	rawKey, err := ecdsa.GenerateKey(CurveToUse, rand.Reader)
 	if err != nil {
@@ -106,6 +109,8 @@ const (
 	CertTypeUser   CertType = "users"
 	CertTypeServer CertType = "servers"
 	CertTypeAgent  CertType = "agents"
+	// CertTypeSigner is a CA a user delegates enrolling agents to, it can't sign further CAs.
+	CertTypeSigner CertType = "signers"
 )
 
 func generateUserCert(username string, caKey *PrivateKey, caCert *Certificate) (*Certificate, *PrivateKey, error) {
@@ -137,6 +142,37 @@ func generateUserCert(username string, caKey *PrivateKey, caCert *Certificate) (
 	return cert, userPrivateKey, nil
 }
 
+// CreateUserCert issues a user certificate for a key generated elsewhere.
+// Users are CAs themselves, so they can enroll agents.
+func CreateUserCert(name string, pub *PublicKey, caCredentials *PermanentCredentials) (*Certificate, error) {
+	userTemplate, err := getTemplate(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate user template: %w", err)
+	}
+
+	userTemplate.Subject = pkix.Name{
+		OrganizationalUnit: []string{string(CertTypeUser)},
+		CommonName:         name,
+	}
+
+	userTemplate.NotAfter = time.Now().Add(userValidFor)
+	userTemplate.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	userTemplate.IsCA = true
+
+	caCert, caKey := caCredentials.Get()
+
+	if !caCert.IsCA() {
+		return nil, fmt.Errorf("credentials are not a CA")
+	}
+
+	cert, err := signCert(userTemplate, caKey, caCert.ToX509())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign user certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
 func CreateServerCert(name string, pub *PublicKey, caCredentials *PermanentCredentials) (*Certificate, error) {
 	serverTemplate, err := getTemplate(pub)
 	if err != nil {
@@ -192,3 +228,84 @@ func CreateAgentCert(name string, pub *PublicKey, caCredentials *PermanentCreden
 
 	return cert, nil
 }
+
+// CreateSignerCert issues a certificate that can only sign agent certificates.
+// It has to outlive the agent certificates it issues, so it stays valid for validFor plus their lifetime.
+func CreateSignerCert(name string, pub *PublicKey, caCredentials *PermanentCredentials, validFor time.Duration) (*Certificate, error) {
+	signerTemplate, err := getTemplate(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signer template: %w", err)
+	}
+
+	signerTemplate.Subject = pkix.Name{
+		OrganizationalUnit: []string{string(CertTypeSigner)},
+		CommonName:         name,
+	}
+
+	signerTemplate.NotAfter = time.Now().Add(validFor + agentValidFor)
+	signerTemplate.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign
+	signerTemplate.IsCA = true
+	signerTemplate.MaxPathLenZero = true
+
+	caCert, caKey := caCredentials.Get()
+
+	if !caCert.IsCA() {
+		return nil, fmt.Errorf("credentials are not a CA")
+	}
+
+	cert, err := signCert(signerTemplate, caKey, caCert.ToX509())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign signer certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// ReissueCertificate signs a copy of the given certificate with another CA.
+// Subject, key, usage and expiry are kept, so certificates issued by the original stay valid below the copy.
+func ReissueCertificate(cert *Certificate, caCredentials *PermanentCredentials) (*Certificate, error) {
+	original := cert.ToX509()
+
+	template, err := getTemplate(cert.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate template: %w", err)
+	}
+
+	template.Subject = original.Subject
+	template.NotAfter = original.NotAfter
+	template.KeyUsage = original.KeyUsage
+	template.IsCA = original.IsCA
+	template.SubjectKeyId = original.SubjectKeyId
+
+	caCert, caKey := caCredentials.Get()
+
+	if !caCert.IsCA() {
+		return nil, fmt.Errorf("credentials are not a CA")
+	}
+
+	reissued, err := signCert(template, caKey, caCert.ToX509())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	return reissued, nil
+}
+
+// CrossSignRoot issues a certificate for the key of the next root, signed by the current one.
+// Endpoints that only trust the current root can verify chains of the next one through it.
+func CrossSignRoot(next *Certificate, current *PermanentCredentials) (*Certificate, error) {
+	if next.Type() != CertTypeRoot {
+		return nil, fmt.Errorf("invalid certificate type: %s", next.Type())
+	}
+
+	if current.Certificate().Type() != CertTypeRoot {
+		return nil, fmt.Errorf("credentials are not a root")
+	}
+
+	cert, err := ReissueCertificate(next, current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cross sign root: %w", err)
+	}
+
+	return cert, nil
+}
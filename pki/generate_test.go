@@ -0,0 +1,218 @@
+package pki_test
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+)
+
+func TestCrossSignRoot(t *testing.T) {
+	current, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crossSigned, err := pki.CrossSignRoot(next.Certificate(), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !crossSigned.PublicKey().Equal(next.PublicKey()) {
+		t.Errorf("cross signed certificate has a different key")
+	}
+
+	if !crossSigned.IsIssuedBy(current.Certificate()) {
+		t.Errorf("cross signed certificate was not issued by the current root")
+	}
+
+	host, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := pki.CreateServerCert("server", host.PublicKey(), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentPool := x509.NewCertPool()
+	currentPool.AddCert(current.Certificate().ToX509())
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(crossSigned.ToX509())
+
+	chain, err := cert.VerifyChain(currentPool, intermediates)
+	if err != nil {
+		t.Fatalf("certificate of next root not trusted through cross signature: %v", err)
+	}
+
+	if !chain[len(chain)-1].Equal(current.Certificate()) {
+		t.Errorf("expected chain to end in the current root")
+	}
+
+	_, err = cert.VerifyChain(currentPool, x509.NewCertPool())
+	if err == nil {
+		t.Errorf("expected verification without cross signature to fail")
+	}
+}
+
+func TestReissueCertificate(t *testing.T) {
+	current, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := pki.CreateServerCert("server", host.PublicKey(), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reissued, err := pki.ReissueCertificate(cert, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reissued.PublicKey().Equal(cert.PublicKey()) {
+		t.Errorf("reissued certificate has a different key")
+	}
+
+	if reissued.GetName() != cert.GetName() || reissued.Type() != cert.Type() {
+		t.Errorf("reissued certificate has a different subject")
+	}
+
+	if !reissued.NotAfter().Equal(cert.NotAfter()) {
+		t.Errorf("expected expiry to be kept, got %s instead of %s", reissued.NotAfter(), cert.NotAfter())
+	}
+
+	nextPool := x509.NewCertPool()
+	nextPool.AddCert(next.Certificate().ToX509())
+
+	_, err = reissued.VerifyChain(nextPool, x509.NewCertPool())
+	if err != nil {
+		t.Errorf("reissued certificate not trusted by next root: %v", err)
+	}
+}
+
+func TestCreateUserCert(t *testing.T) {
+	root, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := pki.CreateUserCert("alice", user.PublicKey(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Type() != pki.CertTypeUser || cert.GetName() != "alice" || !cert.IsCA() {
+		t.Errorf("unexpected user certificate: %s %s", cert.Type(), cert.GetName())
+	}
+
+	credentials, err := user.ToPermanentCredentials(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent, err := pki.CreateAgentCert("agent", host.PublicKey(), credentials)
+	if err != nil {
+		t.Fatalf("user can't issue agent certificates: %v", err)
+	}
+
+	if !agent.IsIssuedBy(cert) {
+		t.Errorf("agent certificate was not issued by the user")
+	}
+}
+
+func TestSignerCert(t *testing.T) {
+	root, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signerKey, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signerCert, err := pki.CreateSignerCert("signer", signerKey.PublicKey(), root, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signerCert.Type() != pki.CertTypeSigner {
+		t.Fatalf("expected a signer certificate, got %q", signerCert.Type())
+	}
+
+	signer := pki.CredentialsFromCertAndKey(signerCert, signerKey.PrivateKey())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Certificate().ToX509())
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(signerCert.ToX509())
+
+	agent, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agentCert, err := pki.CreateAgentCert("agent", agent.PublicKey(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = agentCert.VerifyChain(roots, intermediates)
+	if err != nil {
+		t.Errorf("agent certificate issued by signer not trusted: %v", err)
+	}
+
+	// a signer may not create further CAs
+	user, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userCert, err := pki.CreateUserCert("user", user.PublicKey(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intermediates.AddCert(userCert.ToX509())
+
+	nested, err := pki.CreateAgentCert("nested", agent.PublicKey(), pki.CredentialsFromCertAndKey(userCert, user.PrivateKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nested.VerifyChain(roots, intermediates)
+	if err == nil {
+		t.Errorf("certificate below a CA issued by a signer was trusted")
+	}
+}
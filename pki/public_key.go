@@ -33,6 +33,21 @@ func (pub *PublicKey) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (pub *PublicKey) MarshalBinary() ([]byte, error) {
+	return pub.BinaryEncode(), nil
+}
+
+func (pub *PublicKey) UnmarshalBinary(data []byte) error {
+	newPub, err := PublicKeyFromBinary(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	*pub = *newPub
+
+	return nil
+}
+
 func (pub *PublicKey) BinaryEncode() []byte {
 	bytes, err := x509.MarshalPKIXPublicKey(pub.ToEcdsa())
 	if err != nil {
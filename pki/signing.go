@@ -46,12 +46,17 @@ func (e NotSignedError) Is(target error) bool {
 }
 
 func MarshalAndSign(v any, c Credentials) ([]byte, error) {
-	json, err := json.Marshal(v)
+	return MarshalAndSignWith(v, c, json.Marshal)
+}
+
+// MarshalAndSignWith is MarshalAndSign with a different encoding than JSON.
+func MarshalAndSignWith(v any, c Credentials, marshal func(any) ([]byte, error)) ([]byte, error) {
+	data, err := marshal(v)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	msg, err := packAndSign(json, c)
+	msg, err := packAndSign(data, c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to package data: %w", err)
 	}
@@ -60,6 +65,11 @@ func MarshalAndSign(v any, c Credentials) ([]byte, error) {
 }
 
 func UnmarshalAndVerify(signedData []byte, v any, publicKey *PublicKey) error {
+	return UnmarshalAndVerifyWith(signedData, v, publicKey, json.Unmarshal)
+}
+
+// UnmarshalAndVerifyWith is UnmarshalAndVerify with a different encoding than JSON.
+func UnmarshalAndVerifyWith(signedData []byte, v any, publicKey *PublicKey, unmarshal func([]byte, any) error) error {
 	if len(signedData) == 0 {
 		return fmt.Errorf("empty signed data")
 	}
@@ -69,7 +79,7 @@ func UnmarshalAndVerify(signedData []byte, v any, publicKey *PublicKey) error {
 		return fmt.Errorf("failed to verify signature: %w", err)
 	}
 
-	err = json.Unmarshal(msg, v)
+	err = unmarshal(msg, v)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal data: %w", err)
 	}
@@ -78,12 +88,17 @@ func UnmarshalAndVerify(signedData []byte, v any, publicKey *PublicKey) error {
 }
 
 func ReadAndUnmarshalAndVerify(reader io.Reader, v any, publicKey *PublicKey) error {
+	return ReadAndUnmarshalAndVerifyWith(reader, v, publicKey, json.Unmarshal)
+}
+
+// ReadAndUnmarshalAndVerifyWith is ReadAndUnmarshalAndVerify with a different encoding than JSON.
+func ReadAndUnmarshalAndVerifyWith(reader io.Reader, v any, publicKey *PublicKey, unmarshal func([]byte, any) error) error {
 	der, err := util.ReadSingleDer(reader)
 	if err != nil {
 		return fmt.Errorf("failed to read asn1 block: %w", err)
 	}
 
-	return UnmarshalAndVerify(der, v, publicKey)
+	return UnmarshalAndVerifyWith(der, v, publicKey, unmarshal)
 }
 
 type PackedData struct {
@@ -26,6 +26,16 @@ func (d *Device) Name() string {
 	return d.Certificate.GetName()
 }
 
+// Supports returns whether the agent announced the command when it connected.
+// Agents that are offline or did not announce anything are assumed to support it.
+func (d *Device) Supports(key string) bool {
+	return d.LiveInfo.Peer.Supports(key)
+}
+
+func (d *Device) CanOpenShell() bool {
+	return d.Supports(remoteShellKey)
+}
+
 func (d *Device) Processes() util.UpdateableMap[int32, *ProcessInfo] {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
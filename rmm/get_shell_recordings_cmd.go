@@ -0,0 +1,97 @@
+package rmm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/system"
+)
+
+var _ rpc.RpcCommand = (*getShellRecordingsCommand)(nil)
+
+// CreateGetShellRecordingsCommandHandler hands out the shell recordings of a device.
+// Recordings are listed without their data, unless a single one is requested by its ID.
+func CreateGetShellRecordingsCommandHandler(getRecordings func(partner *pki.Certificate, device *pki.PublicKey, id string) ([]*ShellRecording, error)) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &getShellRecordingsCommand{
+			getRecordings: getRecordings,
+		}
+	}
+}
+
+type getShellRecordingsCommand struct {
+	Device        *pki.PublicKey
+	ID            string
+	getRecordings func(partner *pki.Certificate, device *pki.PublicKey, id string) ([]*ShellRecording, error)
+	recordings    []*ShellRecording
+}
+
+// NewGetShellRecordingsCommand lists the recordings of the device, or returns the one with the given ID including its data.
+func NewGetShellRecordingsCommand(device *pki.PublicKey, id string) *getShellRecordingsCommand {
+	return &getShellRecordingsCommand{
+		Device: device,
+		ID:     id,
+	}
+}
+
+func (c *getShellRecordingsCommand) GetKey() string {
+	return "get-shell-recordings"
+}
+
+func (c *getShellRecordingsCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.Device == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Missing device",
+		})
+		return fmt.Errorf("missing device")
+	}
+
+	recordings, err := c.getRecordings(session.Partner(), c.Device, c.ID)
+	if errors.Is(err, system.ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error getting shell recordings: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error getting shell recordings: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	err = rpc.WriteMessage[[]*ShellRecording](session, recordings)
+	if err != nil {
+		return fmt.Errorf("error writing shell recordings: %w", err)
+	}
+
+	return nil
+}
+
+func (c *getShellRecordingsCommand) ExecuteClient(session *rpc.RpcSession) error {
+	c.recordings = make([]*ShellRecording, 0)
+	err := rpc.ReadMessage[*[]*ShellRecording](session, &c.recordings)
+	if err != nil {
+		return fmt.Errorf("error reading shell recordings: %w", err)
+	}
+
+	return nil
+}
+
+// Recordings returns the received recordings.
+func (c *getShellRecordingsCommand) Recordings() []*ShellRecording {
+	return c.recordings
+}
@@ -1,38 +1,35 @@
 package rmm
 
 import (
+	"errors"
 	"fmt"
+
 	"github.com/rahn-it/svalin/rpc"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
-func KillProcessCommandHandler() rpc.RpcCommand {
-	return &killProcessCommand{}
-}
+const killProcessKey = "kill-process"
 
-type killProcessCommand struct {
+type KillProcessRequest struct {
 	Pid int32
 }
 
-func NewKillProcessCommand(pid int32) *killProcessCommand {
-	return &killProcessCommand{
-		Pid: pid,
-	}
-}
+var KillProcessCommandHandler = rpc.UnaryCommandHandler(killProcessKey, killProcess)
 
-func (c *killProcessCommand) GetKey() string {
-	return "kill-process"
+func NewKillProcessCommand(pid int32) *rpc.UnaryCommand[KillProcessRequest, rpc.Empty] {
+	return rpc.NewUnaryCommand[KillProcessRequest, rpc.Empty](killProcessKey, &KillProcessRequest{
+		Pid: pid,
+	})
 }
 
-func (c *killProcessCommand) ExecuteServer(session *rpc.RpcSession) error {
-
-	err := KillProcess(c.Pid)
+func killProcess(session *rpc.RpcSession, request *KillProcessRequest) (*rpc.Empty, error) {
+	err := KillProcess(request.Pid)
+	if errors.Is(err, process.ErrorProcessNotRunning) {
+		return nil, fmt.Errorf("%w: process %d is not running", rpc.ErrNotFound, request.Pid)
+	}
 	if err != nil {
-		return fmt.Errorf("error killing process: %w", err)
+		return nil, fmt.Errorf("error killing process: %w", err)
 	}
 
-	return nil
-}
-
-func (c *killProcessCommand) ExecuteClient(session *rpc.RpcSession) error {
-	return nil
+	return &rpc.Empty{}, nil
 }
@@ -2,69 +2,46 @@ package rmm
 
 import (
 	"fmt"
+	"time"
+
 	"github.com/rahn-it/svalin/rpc"
 	"github.com/rahn-it/svalin/util"
 )
 
-func MonitorServicesCommandHandler() rpc.RpcCommand {
-	return &monitorServicesCommand{}
-}
+const monitorServicesKey = "manage-services"
 
-type monitorServicesCommand struct {
-	services util.UpdateableObservable[*ServiceStats]
-}
-
-func NewMonitorServicesCommand(services util.UpdateableObservable[*ServiceStats]) *monitorServicesCommand {
-	return &monitorServicesCommand{
-		services: services,
-	}
-}
+var MonitorServicesCommandHandler = rpc.StreamCommandHandler(monitorServicesKey, monitorServices)
 
-func (cmd *monitorServicesCommand) GetKey() string {
-	return "manage-services"
+func NewMonitorServicesCommand(services util.UpdateableObservable[*ServiceStats]) *rpc.StreamCommand[rpc.Empty, ServiceStats] {
+	return rpc.NewStreamCommand[rpc.Empty, ServiceStats](monitorServicesKey, &rpc.Empty{}, func(stats *ServiceStats) error {
+		services.Update(func(_ *ServiceStats) *ServiceStats {
+			return stats
+		})
+		return nil
+	})
 }
 
-func (cmd *monitorServicesCommand) ExecuteServer(session *rpc.RpcSession) error {
+func monitorServices(session *rpc.RpcSession, _ *rpc.Empty, send func(*ServiceStats) error) error {
 	system, err := GetServiceSystem()
 	if err != nil {
-		session.WriteResponseHeader(rpc.SessionResponseHeader{
-			Code: 500,
-			Msg:  "Unable to get service system",
-		})
-		return fmt.Errorf("error getting service system: %w", err)
+		return fmt.Errorf("%w: unable to get service system: %v", rpc.ErrUnavailable, err)
 	}
 
-	session.WriteResponseHeader(rpc.SessionResponseHeader{
-		Code: 200,
-		Msg:  "OK",
-	})
-
 	for {
 		services, err := system.GetStats()
 		if err != nil {
 			return fmt.Errorf("error listing services: %w", err)
 		}
 
-		err = rpc.WriteMessage[*ServiceStats](session, services)
+		err = send(services)
 		if err != nil {
 			return fmt.Errorf("error writing services: %w", err)
 		}
 
-	}
-}
-
-func (cmd *monitorServicesCommand) ExecuteClient(session *rpc.RpcSession) error {
-
-	services := &ServiceStats{}
-
-	for {
-		err := rpc.ReadMessage[*ServiceStats](session, services)
-		if err != nil {
-			return fmt.Errorf("error reading services: %w", err)
+		select {
+		case <-session.Context().Done():
+			return nil
+		case <-time.After(reportingInterval):
 		}
-
-		cmd.services.Update(func(_ *ServiceStats) *ServiceStats {
-			return services
-		})
 	}
 }
@@ -66,7 +66,11 @@ func (cmd *monitorSystemCommand) ExecuteServer(session *rpc.RpcSession) error {
 			return fmt.Errorf("error writing active stats: %w", err)
 		}
 
-		time.Sleep(reportingInterval)
+		select {
+		case <-session.Context().Done():
+			return nil
+		case <-time.After(reportingInterval):
+		}
 	}
 }
 
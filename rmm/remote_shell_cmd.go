@@ -2,17 +2,37 @@ package rmm
 
 import (
 	"fmt"
-	"github.com/rahn-it/svalin/rpc"
 	"io"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.AuditableCommand = (*remoteShellCommand)(nil)
+
+const (
+	recordingWidth  = 80
+	recordingHeight = 24
 )
 
-func RemoteShellCommandHandler() rpc.RpcCommand {
-	return &remoteShellCommand{}
+// CreateRemoteShellCommandHandler records every shell session and passes the recording to onRecording once the shell is closed.
+// Keystrokes are only recorded if recordInput is set, they may contain passwords.
+func CreateRemoteShellCommandHandler(recordInput bool, onRecording func(recording *ShellRecording)) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &remoteShellCommand{
+			recordInput: recordInput,
+			onRecording: onRecording,
+		}
+	}
 }
 
 type remoteShellCommand struct {
-	input  io.ReadCloser
-	output io.WriteCloser
+	input       io.ReadCloser
+	output      io.WriteCloser
+	recordInput bool
+	onRecording func(recording *ShellRecording)
+	recording   *ShellRecording
 }
 
 func NewRemoteShellCommand(input io.ReadCloser, output io.WriteCloser) *remoteShellCommand {
@@ -22,8 +42,10 @@ func NewRemoteShellCommand(input io.ReadCloser, output io.WriteCloser) *remoteSh
 	}
 }
 
+const remoteShellKey = "remote-shell"
+
 func (cmd *remoteShellCommand) GetKey() string {
-	return "remote-shell"
+	return remoteShellKey
 }
 
 func (cmd *remoteShellCommand) ExecuteServer(session *rpc.RpcSession) error {
@@ -36,6 +58,17 @@ func (cmd *remoteShellCommand) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("error starting shell: %w", err)
 	}
 
+	recorder, err := newShellRecorder(recordingWidth, recordingHeight)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Unable to record shell",
+		})
+		return fmt.Errorf("error recording shell: %w", err)
+	}
+
+	defer cmd.finishRecording(session, recorder)
+
 	session.WriteResponseHeader(rpc.SessionResponseHeader{
 		Code: 200,
 		Msg:  "OK",
@@ -43,13 +76,18 @@ func (cmd *remoteShellCommand) ExecuteServer(session *rpc.RpcSession) error {
 
 	errChan := make(chan error)
 
+	var input io.Reader = session
+	if cmd.recordInput {
+		input = io.TeeReader(session, recorder.writer("i"))
+	}
+
 	go func() {
-		_, err = io.Copy(shell, session)
+		_, err = io.Copy(shell, input)
 		errChan <- err
 	}()
 
 	go func() {
-		_, err = io.Copy(session, shell)
+		_, err = io.Copy(io.MultiWriter(session, recorder.writer("o")), shell)
 		errChan <- err
 	}()
 
@@ -61,6 +99,23 @@ func (cmd *remoteShellCommand) ExecuteServer(session *rpc.RpcSession) error {
 	return nil
 }
 
+func (cmd *remoteShellCommand) finishRecording(session *rpc.RpcSession, recorder *shellRecorder) {
+	recording := recorder.finish()
+	recording.ID = uuid.NewString()
+	recording.User = session.Partner()
+	recording.Input = cmd.recordInput
+
+	cmd.recording = recording
+
+	if cmd.onRecording == nil {
+		return
+	}
+
+	log.Printf("shell session %s of %s recorded", recording.ID, recording.User.GetName())
+
+	cmd.onRecording(recording)
+}
+
 func (cmd *remoteShellCommand) ExecuteClient(session *rpc.RpcSession) error {
 	errChan := make(chan error)
 	go func() {
@@ -74,3 +129,16 @@ func (cmd *remoteShellCommand) ExecuteClient(session *rpc.RpcSession) error {
 
 	return <-errChan
 }
+
+func (cmd *remoteShellCommand) AuditTarget() string {
+	return ""
+}
+
+// AuditSummary links the audit entry to the recording of the session.
+func (cmd *remoteShellCommand) AuditSummary() string {
+	if cmd.recording == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("recording=%s sha256=%x", cmd.recording.ID, cmd.recording.Hash)
+}
@@ -0,0 +1,238 @@
+package rmm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rahn-it/svalin/pki"
+)
+
+// MaxShellRecordingSize limits the size of a recording, output beyond it is not recorded.
+const MaxShellRecordingSize = 8 * 1024 * 1024
+
+// maxPlaybackIdle caps pauses during playback, like asciinema's idle time limit.
+const maxPlaybackIdle = 2 * time.Second
+
+// ShellRecording is a remote shell session in the asciinema v2 format.
+// Its hash is part of the audit entry the agent records for the session.
+type ShellRecording struct {
+	ID       string
+	Device   *pki.Certificate
+	User     *pki.Certificate
+	Start    time.Time
+	Duration time.Duration
+	// Input is set if the keystrokes of the user were recorded as well.
+	Input     bool
+	Truncated bool `json:",omitempty"`
+	Hash      []byte
+	// Data is left out when listing recordings.
+	Data []byte `json:",omitempty"`
+}
+
+// Verify checks that the data matches the hash.
+func (r *ShellRecording) Verify() error {
+	hash := sha256.Sum256(r.Data)
+	if !bytes.Equal(hash[:], r.Hash) {
+		return fmt.Errorf("shell recording %s does not match its hash", r.ID)
+	}
+
+	return nil
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// shellRecorder writes the events of a shell session in the asciinema v2 format.
+type shellRecorder struct {
+	mutex     sync.Mutex
+	start     time.Time
+	buffer    bytes.Buffer
+	truncated bool
+	// pending holds incomplete UTF-8 sequences per event type, they are completed by the next write.
+	pending map[string][]byte
+}
+
+func newShellRecorder(width int, height int) (*shellRecorder, error) {
+	r := &shellRecorder{
+		start:   time.Now(),
+		pending: make(map[string][]byte),
+	}
+
+	header, err := json.Marshal(asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Env: map[string]string{
+			"TERM": "xterm-256color",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling recording header: %w", err)
+	}
+
+	r.buffer.Write(header)
+	r.buffer.WriteByte('\n')
+
+	return r, nil
+}
+
+func (r *shellRecorder) record(eventType string, p []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.truncated {
+		return
+	}
+
+	data := append(r.pending[eventType], p...)
+
+	// keep an incomplete rune at the end for the next write
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+
+	r.pending[eventType] = append([]byte(nil), data[complete:]...)
+
+	if complete == 0 {
+		return
+	}
+
+	event, err := json.Marshal([]any{
+		time.Since(r.start).Seconds(),
+		eventType,
+		string(data[:complete]),
+	})
+	if err != nil {
+		return
+	}
+
+	if r.buffer.Len()+len(event)+1 > MaxShellRecordingSize {
+		r.truncated = true
+		return
+	}
+
+	r.buffer.Write(event)
+	r.buffer.WriteByte('\n')
+}
+
+// writer returns a writer recording everything written to it as events of the given type.
+func (r *shellRecorder) writer(eventType string) io.Writer {
+	return &recordingWriter{
+		recorder:  r,
+		eventType: eventType,
+	}
+}
+
+func (r *shellRecorder) finish() *ShellRecording {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data := append([]byte(nil), r.buffer.Bytes()...)
+	hash := sha256.Sum256(data)
+
+	return &ShellRecording{
+		Start:     r.start,
+		Duration:  time.Since(r.start),
+		Truncated: r.truncated,
+		Hash:      hash[:],
+		Data:      data,
+	}
+}
+
+type recordingWriter struct {
+	recorder  *shellRecorder
+	eventType string
+}
+
+// Write never fails, recording must not interrupt the shell.
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.recorder.record(w.eventType, p)
+	return len(p), nil
+}
+
+// PlayShellRecording writes the output of the recording to out, keeping its timing.
+// Pauses are shortened to a few seconds.
+func PlayShellRecording(ctx context.Context, data []byte, out io.Writer) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxShellRecordingSize)
+
+	if !scanner.Scan() {
+		return fmt.Errorf("recording is empty")
+	}
+
+	header := asciicastHeader{}
+	err := json.Unmarshal(scanner.Bytes(), &header)
+	if err != nil {
+		return fmt.Errorf("error reading recording header: %w", err)
+	}
+
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported recording version %d", header.Version)
+	}
+
+	last := 0.0
+
+	for scanner.Scan() {
+		var event []json.RawMessage
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid recording event: %s", scanner.Text())
+		}
+
+		var at float64
+		var eventType, text string
+		err = json.Unmarshal(event[0], &at)
+		if err == nil {
+			err = json.Unmarshal(event[1], &eventType)
+		}
+		if err == nil {
+			err = json.Unmarshal(event[2], &text)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid recording event: %w", err)
+		}
+
+		if eventType != "o" {
+			continue
+		}
+
+		delay := time.Duration((at - last) * float64(time.Second))
+		if delay > maxPlaybackIdle {
+			delay = maxPlaybackIdle
+		}
+		last = at
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		_, err = io.WriteString(out, text)
+		if err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+	}
+
+	return scanner.Err()
+}
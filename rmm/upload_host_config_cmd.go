@@ -7,46 +7,33 @@ import (
 	"github.com/rahn-it/svalin/rpc"
 )
 
-func UploadHostConfigCommandHandler[T HostConfig]() rpc.RpcCommand {
-	return &uploadHostConfigCommand[T]{}
-}
-
-type uploadHostConfigCommand[T HostConfig] struct {
+type UploadHostConfigRequest struct {
 	Config []byte
 }
 
-func NewUploadHostCommand[T HostConfig](config *pki.SignedArtifact[T]) *uploadHostConfigCommand[T] {
-	return &uploadHostConfigCommand[T]{
-		Config: config.Raw(),
-	}
-}
-
-func (c *uploadHostConfigCommand[T]) GetKey() string {
+func uploadHostConfigKey[T HostConfig]() string {
 	var conf T
 	return "upload-host-config-" + conf.GetConfigKey()
 }
 
-func (c *uploadHostConfigCommand[T]) ExecuteClient(session *rpc.RpcSession) error {
-	return nil
+func UploadHostConfigCommandHandler[T HostConfig]() rpc.RpcCommand {
+	return rpc.UnaryCommandHandler(uploadHostConfigKey[T](), uploadHostConfig[T])()
 }
 
-func (c *uploadHostConfigCommand[T]) ExecuteServer(session *rpc.RpcSession) error {
-	conf, err := pki.LoadSignedArtifact[T](c.Config, session.Verifier())
+func NewUploadHostCommand[T HostConfig](config *pki.SignedArtifact[T]) *rpc.UnaryCommand[UploadHostConfigRequest, rpc.Empty] {
+	return rpc.NewUnaryCommand[UploadHostConfigRequest, rpc.Empty](uploadHostConfigKey[T](), &UploadHostConfigRequest{
+		Config: config.Raw(),
+	})
+}
+
+func uploadHostConfig[T HostConfig](session *rpc.RpcSession, request *UploadHostConfigRequest) (*rpc.Empty, error) {
+	conf, err := pki.LoadSignedArtifact[T](request.Config, session.Verifier())
 	if err != nil {
-		session.WriteResponseHeader(rpc.SessionResponseHeader{
-			Code: 500,
-			Msg:  "Error unmarshaling config",
-		})
-		return fmt.Errorf("error unmarshaling config: %w", err)
+		return nil, fmt.Errorf("%w: error unmarshaling config: %v", rpc.ErrInvalidArgument, err)
 	}
 
 	//TODO
 	conf.Raw()
 
-	session.WriteResponseHeader(rpc.SessionResponseHeader{
-		Code: 200,
-		Msg:  "Host config saved",
-	})
-
-	return nil
+	return &rpc.Empty{}, nil
 }
@@ -0,0 +1,73 @@
+package rmm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/system"
+)
+
+var _ rpc.RpcCommand = (*uploadShellRecordingCommand)(nil)
+
+func CreateUploadShellRecordingCommandHandler(storeRecording func(partner *pki.Certificate, recording *ShellRecording) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &uploadShellRecordingCommand{
+			storeRecording: storeRecording,
+		}
+	}
+}
+
+// uploadShellRecordingCommand sends a finished shell recording from the agent to its upstream.
+type uploadShellRecordingCommand struct {
+	Recording      *ShellRecording
+	storeRecording func(partner *pki.Certificate, recording *ShellRecording) error
+}
+
+func NewUploadShellRecordingCommand(recording *ShellRecording) *uploadShellRecordingCommand {
+	return &uploadShellRecordingCommand{
+		Recording: recording,
+	}
+}
+
+func (c *uploadShellRecordingCommand) GetKey() string {
+	return "upload-shell-recording"
+}
+
+func (c *uploadShellRecordingCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.Recording == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Missing recording",
+		})
+		return fmt.Errorf("missing recording")
+	}
+
+	err := c.storeRecording(session.Partner(), c.Recording)
+	if errors.Is(err, system.ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error storing shell recording: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid recording",
+		})
+		return fmt.Errorf("error storing shell recording: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *uploadShellRecordingCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+)
+
+// SessionRecord describes an incoming session after its command was executed.
+type SessionRecord struct {
+	Partner  *pki.Certificate
+	Command  string
+	Target   string
+	Summary  string
+	Code     int
+	Duration time.Duration
+}
+
+// AuditableCommand describes a command for the audit log.
+// Commands that don't implement it are recorded without their arguments, as these may contain secrets.
+type AuditableCommand interface {
+	// AuditTarget names the device or user the command acts on.
+	AuditTarget() string
+	// AuditSummary describes the arguments, it must not contain any secrets.
+	AuditSummary() string
+}
+
+func newSessionRecord(session *RpcSession, key string, cmd RpcCommand, start time.Time) SessionRecord {
+	record := SessionRecord{
+		Partner:  session.partner,
+		Command:  key,
+		Code:     session.responseCode,
+		Duration: time.Since(start),
+	}
+
+	auditable, ok := cmd.(AuditableCommand)
+	if ok {
+		record.Target = auditable.AuditTarget()
+		record.Summary = auditable.AuditSummary()
+	}
+
+	return record
+}
+
+var _ AuditableCommand = (*forwardCommand)(nil)
+
+func (f *forwardCommand) AuditTarget() string {
+	if f.Target == nil {
+		return ""
+	}
+
+	return f.Target.GetName()
+}
+
+func (f *forwardCommand) AuditSummary() string {
+	if f.Target == nil {
+		return ""
+	}
+
+	return "forwarded to " + f.Target.PublicKey().Base64Encode()
+}
@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// clockSkewTolerance is how far a message may be ahead of the compensated clock.
+	clockSkewTolerance = 5 * time.Second
+	// maxClockOffset bounds the compensation, and the skew accepted before the offset was measured.
+	maxClockOffset = 2 * time.Minute
+	// ClockDriftWarning is the offset from which a peer's clock is reported as drifting.
+	ClockDriftWarning = 10 * time.Second
+)
+
+// measureClockOffset estimates how far the clock of the peer is ahead of ours.
+// sent is when our part of the handshake was sent and received when the answer arrived,
+// the peer is assumed to have taken its time in the middle of the round trip.
+func measureClockOffset(peerTime time.Time, sent time.Time, received time.Time) time.Duration {
+	return peerTime.Sub(sent.Add(received.Sub(sent) / 2))
+}
+
+func (conn *RpcConnection) setClockOffset(offset time.Duration) {
+	if offset > maxClockOffset || offset < -maxClockOffset {
+		log.Printf("clock of %s is off by %s, only %s can be compensated", conn.partnerName(), offset, maxClockOffset)
+	} else if offset > ClockDriftWarning || offset < -ClockDriftWarning {
+		log.Printf("clock of %s is off by %s", conn.partnerName(), offset)
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.clockOffset = offset
+	conn.clockMeasured = true
+}
+
+// ClockOffset returns how far the clock of the partner was ahead of ours in the handshake.
+func (conn *RpcConnection) ClockOffset() time.Duration {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.clockOffset
+}
+
+// measuredClockOffset returns the offset of the partner and whether it was measured yet.
+func (conn *RpcConnection) measuredClockOffset() (time.Duration, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.clockOffset, conn.clockMeasured
+}
+
+// timestampWindow returns the compensation for message timestamps of the partner and the extra skew to accept.
+// Until the offset was measured, any skew within maxClockOffset is accepted.
+func (conn *RpcConnection) timestampWindow() (offset time.Duration, tolerance time.Duration) {
+	offset, measured := conn.measuredClockOffset()
+	if !measured {
+		return 0, maxClockOffset
+	}
+
+	return boundClockOffset(offset), 0
+}
+
+func boundClockOffset(offset time.Duration) time.Duration {
+	if offset > maxClockOffset {
+		return maxClockOffset
+	} else if offset < -maxClockOffset {
+		return -maxClockOffset
+	}
+	return offset
+}
+
+// timestampWindow returns the window for message timestamps of the session partner.
+// Forwarded sessions are signed by the far peer, the offset of the connection only applies to the peer next to us.
+// The offset of the far peer is known if the forwarding server told it, otherwise any skew within maxClockOffset is accepted.
+func (s *RpcSession) timestampWindow() (offset time.Duration, tolerance time.Duration) {
+	partner := s.connection.partner
+	if partner == nil || s.partnerKey == nil || s.partnerKey.Equal(partner.PublicKey()) {
+		return s.connection.timestampWindow()
+	}
+
+	if !s.farClockMeasured {
+		return 0, maxClockOffset
+	}
+
+	return boundClockOffset(s.farClockOffset), 0
+}
+
+// setForwardedClock adds the offset the forwarding server measured to the target to our offset to the server.
+func (s *RpcSession) setForwardedClock(targetOffset *time.Duration) {
+	if targetOffset == nil {
+		return
+	}
+
+	offset, measured := s.connection.measuredClockOffset()
+	if !measured {
+		return
+	}
+
+	s.farClockOffset = offset + *targetOffset
+	s.farClockMeasured = true
+}
+
+func (conn *RpcConnection) partnerName() string {
+	if conn.partner == nil {
+		return "partner"
+	}
+	return conn.partner.GetName()
+}
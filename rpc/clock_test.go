@@ -0,0 +1,96 @@
+package rpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+// TestForwardedSessionClock reads a header from a far peer whose clock is a minute ahead.
+func TestForwardedSessionClock(t *testing.T) {
+	const skew = time.Minute
+
+	far, err := pki.GenerateRootCredentials("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	near, err := pki.GenerateRootCredentials("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := pki.GenerateRootCredentials("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec, err := rpc.GetCodec(rpc.CodecJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skewed := func(msg []byte) [][]byte {
+		nonce, err := util.NewNonce()
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+
+		message := &rpc.RpcMessage[map[string]any]{
+			Timestamp: time.Now().Add(skew).Unix(),
+			Receiver:  near.PublicKey(),
+			Nonce:     nonce,
+			Payload:   map[string]any{"cmd": "test"},
+		}
+
+		signed, err := pki.MarshalAndSignWith(message, far, codec.Marshal)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+
+		return [][]byte{signed}
+	}
+
+	offset := func(d time.Duration) *time.Duration {
+		return &d
+	}
+
+	cases := []struct {
+		name         string
+		forwarded    bool
+		serverOffset time.Duration
+		targetOffset *time.Duration
+		valid        bool
+	}{
+		{"direct", false, 0, nil, false},
+		{"forwarded without offset", true, 0, nil, true},
+		{"forwarded with offset", true, 20 * time.Second, offset(40 * time.Second), true},
+		{"forwarded with wrong offset", true, 0, offset(0), false},
+	}
+
+	for _, c := range cases {
+		requester, responder := rpc.NewTestSessionPair(far, near, skewed)
+
+		if c.forwarded {
+			rpc.ForwardTestSession(responder, server.Certificate(), c.serverOffset, c.targetOffset)
+		}
+
+		go rpc.WriteTestRequest(requester, "test")
+
+		_, err := rpc.ReadTestRequest(responder)
+		if c.valid && err != nil {
+			t.Errorf("%s: header of skewed peer was rejected: %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: header of skewed peer was accepted", c.name)
+		}
+
+		requester.Close()
+		responder.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec encodes the messages of a session.
+// The codec is announced with the public key at the start of every session,
+// connections default to the best codec both peers named in the handshake.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+const (
+	CodecJson = "json"
+	CodecCbor = "cbor"
+)
+
+// codecs are sorted by preference, JSON is understood by every peer.
+var codecs = []Codec{
+	newCborCodec(),
+	jsonCodec{},
+}
+
+func codecNames() []string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name()
+	}
+	return names
+}
+
+// GetCodec returns the codec with the given name, an empty name is JSON for peers that don't announce one.
+func GetCodec(name string) (Codec, error) {
+	if name == "" {
+		name = CodecJson
+	}
+
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown codec: %s", name)
+}
+
+// negotiateCodec picks the most preferred local codec the peer also supports.
+// The order of the local list decides, so both peers need the same preference to agree without another round trip.
+func negotiateCodec(supported []string) Codec {
+	for _, codec := range codecs {
+		for _, name := range supported {
+			if codec.Name() == name {
+				return codec
+			}
+		}
+	}
+
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecJson
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCborCodec() *cborCodec {
+	enc, err := cbor.EncOptions{
+		Time: cbor.TimeRFC3339Nano,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	dec, err := cbor.DecOptions{
+		// match the maps JSON decodes into untyped fields
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	return &cborCodec{
+		enc: enc,
+		dec: dec,
+	}
+}
+
+func (c *cborCodec) Name() string {
+	return CodecCbor
+}
+
+func (c *cborCodec) Marshal(v any) ([]byte, error) {
+	return c.enc.Marshal(v)
+}
+
+func (c *cborCodec) Unmarshal(data []byte, v any) error {
+	return c.dec.Unmarshal(data, v)
+}
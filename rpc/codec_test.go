@@ -0,0 +1,158 @@
+package rpc_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rmm"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+type codecTestPayload struct {
+	Cert  *pki.Certificate
+	Big   uint64
+	Time  time.Time
+	Stats *rmm.ActiveStats
+}
+
+func testMessage(tb testing.TB) *rpc.RpcMessage[*codecTestPayload] {
+	root, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	nonce, err := util.NewNonce()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	usage := make([]float64, 16)
+	for i := range usage {
+		usage[i] = float64(i) * 3.7
+	}
+
+	return &rpc.RpcMessage[*codecTestPayload]{
+		Timestamp: time.Now().Unix(),
+		Receiver:  root.PublicKey(),
+		Nonce:     nonce,
+		Payload: &codecTestPayload{
+			Cert: root.Certificate(),
+			Big:  math.MaxUint64 - 1,
+			Time: time.Now(),
+			Stats: &rmm.ActiveStats{
+				Cpu: &rmm.CpuStats{
+					Usage: usage,
+				},
+				Memory: &rmm.MemoryStats{
+					Total:       1 << 36,
+					Available:   1 << 34,
+					Used:        1<<36 - 1<<34,
+					UsedPercent: 75,
+				},
+			},
+		},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	message := testMessage(t)
+
+	for _, name := range []string{rpc.CodecJson, rpc.CodecCbor} {
+		codec, err := rpc.GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := codec.Marshal(message)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		decoded := &rpc.RpcMessage[*codecTestPayload]{}
+		err = codec.Unmarshal(data, decoded)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !decoded.Receiver.Equal(message.Receiver) {
+			t.Errorf("%s: receiver changed", name)
+		}
+
+		if !decoded.Payload.Cert.Equal(message.Payload.Cert) {
+			t.Errorf("%s: certificate changed", name)
+		}
+
+		if decoded.Payload.Big != message.Payload.Big {
+			t.Errorf("%s: integer changed from %d to %d", name, message.Payload.Big, decoded.Payload.Big)
+		}
+
+		if !decoded.Payload.Time.Equal(message.Payload.Time) {
+			t.Errorf("%s: time changed from %s to %s", name, message.Payload.Time, decoded.Payload.Time)
+		}
+
+		if decoded.Payload.Stats.Cpu.Usage[3] != message.Payload.Stats.Cpu.Usage[3] {
+			t.Errorf("%s: float changed", name)
+		}
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	message := testMessage(b)
+
+	credentials, err := pki.GenerateCredentials()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range []string{rpc.CodecJson, rpc.CodecCbor} {
+		codec, err := rpc.GetCodec(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(name+"/marshal", func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				data, err := codec.Marshal(message)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+		})
+
+		data, err := codec.Marshal(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(name+"/unmarshal", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				decoded := &rpc.RpcMessage[*codecTestPayload]{}
+				err := codec.Unmarshal(data, decoded)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(name+"/signed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				signed, err := pki.MarshalAndSignWith(message, credentials, codec.Marshal)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				decoded := &rpc.RpcMessage[*codecTestPayload]{}
+				err = pki.UnmarshalAndVerifyWith(signed, decoded, credentials.PublicKey(), codec.Unmarshal)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
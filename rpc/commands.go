@@ -1,20 +1,39 @@
 package rpc
 
+import (
+	"sort"
+	"time"
+
+	"github.com/rahn-it/svalin/util"
+)
+
 type RpcCommandHandler func() RpcCommand
 
 type RpcCommand interface {
 	GetKey() string
+	// ExecuteServer handles the command, long running handlers should stop once session.Context() ends.
 	ExecuteServer(session *RpcSession) error
 	ExecuteClient(session *RpcSession) error
 }
 
+// Interceptor runs around commands, e.g. to check permissions or collect metrics.
+// It calls next to continue with the next interceptor and finally the command,
+// returning an error without calling next rejects the command.
+type Interceptor func(session *RpcSession, cmd RpcCommand, next func() error) error
+
 type CommandCollection struct {
 	Commands map[string]RpcCommandHandler
+	record   func(SessionRecord)
+	// incoming runs around ExecuteServer, outgoing around sending commands to partners.
+	incoming []Interceptor
+	outgoing []Interceptor
+	metrics  *CommandMetrics
 }
 
 func NewCommandCollection(commands ...RpcCommandHandler) *CommandCollection {
 	collection := &CommandCollection{
 		Commands: make(map[string]RpcCommandHandler),
+		metrics:  newCommandMetrics(),
 	}
 	for _, cmd := range commands {
 		collection.Add(cmd)
@@ -31,3 +50,100 @@ func (c *CommandCollection) Get(cmd string) (RpcCommandHandler, bool) {
 	commandHandler, ok := c.Commands[cmd]
 	return commandHandler, ok
 }
+
+// RecordSessions calls record after every incoming session handled with the collection.
+func (c *CommandCollection) RecordSessions(record func(SessionRecord)) {
+	c.record = record
+}
+
+// Metrics returns the counters of the sessions handled with the collection.
+func (c *CommandCollection) Metrics() *CommandMetrics {
+	return c.metrics
+}
+
+// Intercept adds interceptors that run around incoming commands, after their arguments were read.
+// Errors are responded like errors of the command.
+// They run inside the interceptor of the collection itself, which recovers panics and counts and records
+// every session, so commands rejected by an interceptor show up in the metrics and the audit log.
+func (c *CommandCollection) Intercept(interceptors ...Interceptor) {
+	c.incoming = append(c.incoming, interceptors...)
+}
+
+// InterceptOutgoing adds interceptors that run around commands sent to partners of a server serving the collection.
+// They run until the partner responded, the command may still be running afterwards.
+func (c *CommandCollection) InterceptOutgoing(interceptors ...Interceptor) {
+	c.outgoing = append(c.outgoing, interceptors...)
+}
+
+// runInterceptors runs the interceptors in the order they were added around next.
+func runInterceptors(interceptors []Interceptor, session *RpcSession, cmd RpcCommand, next func() error) error {
+	if len(interceptors) == 0 {
+		return next()
+	}
+
+	return interceptors[0](session, cmd, func() error {
+		return runInterceptors(interceptors[1:], session, cmd, next)
+	})
+}
+
+// observe is the first interceptor of every incoming command.
+// It recovers panics of the command and the other interceptors, responds errors
+// and counts and records the session once it is done.
+func (c *CommandCollection) observe(session *RpcSession, cmd RpcCommand, next func() error) (err error) {
+	start := time.Now()
+
+	defer func() {
+		panicked := false
+		if r := recover(); r != nil {
+			err = session.recoverPanic(r)
+			panicked = true
+		}
+
+		c.recordSession(session, cmd.GetKey(), cmd.GetKey(), cmd, err != nil, panicked, start)
+	}()
+
+	err = next()
+
+	// handlers that fail before responding leave the response to the session
+	if err != nil && session.ensureState(RpcSessionRequested) == nil {
+		session.WriteError(err)
+	}
+
+	return err
+}
+
+// recordSession counts the session in the metrics under key and passes it to the session recorder.
+func (c *CommandCollection) recordSession(session *RpcSession, key string, command string, cmd RpcCommand, failed bool, panicked bool, start time.Time) {
+	c.metrics.record(key, failed || session.responseCode >= 400, panicked, time.Since(start))
+
+	if c.record != nil {
+		c.record(newSessionRecord(session, command, cmd, start))
+	}
+}
+
+// sendIntercepted sends cmd on the session, the interceptors see intercepted instead if it is wrapped for forwarding.
+func sendIntercepted(interceptors []Interceptor, session *RpcSession, cmd RpcCommand, intercepted RpcCommand) (util.AsyncAction, error) {
+	var running util.AsyncAction
+
+	err := runInterceptors(interceptors, session, intercepted, func() error {
+		var err error
+		running, err = session.sendCommand(cmd)
+		return err
+	})
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return running, nil
+}
+
+// Keys returns the sorted keys of all commands in the collection.
+func (c *CommandCollection) Keys() []string {
+	keys := make([]string, 0, len(c.Commands))
+	for key := range c.Commands {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
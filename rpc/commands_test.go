@@ -0,0 +1,147 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// callLog collects the steps of a command across goroutines.
+type callLog struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(call string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+func (l *callLog) get() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.calls...)
+}
+
+func (l *callLog) interceptor(name string) rpc.Interceptor {
+	return func(session *rpc.RpcSession, cmd rpc.RpcCommand, next func() error) error {
+		l.add(name + " before " + cmd.GetKey())
+		err := next()
+		l.add(name + " after " + cmd.GetKey())
+		return err
+	}
+}
+
+var errRejected = errors.New("rejected by interceptor")
+
+func reject(session *rpc.RpcSession, cmd rpc.RpcCommand, next func() error) error {
+	return fmt.Errorf("%w: %w", rpc.ErrPermissionDenied, errRejected)
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	log := &callLog{}
+
+	commands := rpc.NewCommandCollection(echoCommandHandler(func(text string) {
+		log.add("server handles " + text)
+	}))
+	commands.Intercept(log.interceptor("first"), log.interceptor("second"))
+	commands.InterceptOutgoing(log.interceptor("first out"), log.interceptor("second out"))
+
+	server := startTestServer(t, commands, rpc.Limits{})
+
+	clientCommands := rpc.NewCommandCollection(echoCommandHandler(func(text string) {
+		log.add("client handles " + text)
+	}))
+	client, clientCreds := server.connect(t, "client", clientCommands)
+
+	err := client.SendSyncCommand(context.Background(), newEchoCommand("incoming"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.SendSyncCommandTo(context.Background(), clientCreds.Certificate(), newEchoCommand("outgoing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"first before echo",
+		"second before echo",
+		"server handles incoming",
+		"second after echo",
+		"first after echo",
+		"first out before echo",
+		"second out before echo",
+		"client handles outgoing",
+		"second out after echo",
+		"first out after echo",
+	}
+
+	if calls := log.get(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("unexpected order of interceptors: %v", calls)
+	}
+}
+
+func TestInterceptorRejects(t *testing.T) {
+	log := &callLog{}
+	records := make(chan rpc.SessionRecord, 1)
+
+	commands := rpc.NewCommandCollection(echoCommandHandler(func(text string) {
+		log.add("server handles " + text)
+	}))
+	commands.Intercept(reject)
+	commands.RecordSessions(func(record rpc.SessionRecord) {
+		records <- record
+	})
+
+	server := startTestServer(t, commands, rpc.Limits{})
+	client, _ := server.connect(t, "client", nil)
+
+	err := client.SendSyncCommand(context.Background(), newEchoCommand("incoming"))
+	if !errors.Is(err, rpc.ErrPermissionDenied) {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+
+	record := <-records
+	if record.Command != "echo" || record.Code != 403 {
+		t.Errorf("rejected command was recorded as %s with code %d", record.Command, record.Code)
+	}
+
+	stats := commands.Metrics().Stats()["echo"]
+	if stats.Calls != 1 || stats.Errors != 1 {
+		t.Errorf("rejected command was counted with %d calls and %d errors", stats.Calls, stats.Errors)
+	}
+
+	if calls := log.get(); len(calls) != 0 {
+		t.Errorf("rejected command was handled: %v", calls)
+	}
+}
+
+func TestInterceptOutgoingRejects(t *testing.T) {
+	log := &callLog{}
+
+	commands := rpc.NewCommandCollection()
+	commands.InterceptOutgoing(reject)
+
+	server := startTestServer(t, commands, rpc.Limits{})
+
+	clientCommands := rpc.NewCommandCollection(echoCommandHandler(func(text string) {
+		log.add("client handles " + text)
+	}))
+	_, clientCreds := server.connect(t, "client", clientCommands)
+
+	err := server.SendSyncCommandTo(context.Background(), clientCreds.Certificate(), newEchoCommand("outgoing"))
+	if !errors.Is(err, errRejected) {
+		t.Errorf("expected the error of the interceptor, got %v", err)
+	}
+
+	if calls := log.get(); len(calls) != 0 {
+		t.Errorf("rejected command was sent: %v", calls)
+	}
+}
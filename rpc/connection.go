@@ -8,6 +8,7 @@ import (
 	"github.com/rahn-it/svalin/util"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/quic-go/quic-go"
@@ -42,6 +43,13 @@ type RpcConnection struct {
 	protocol       TlsConnectionProto
 	credentials    pki.Credentials
 	verifier       pki.Verifier
+	// peer is what the partner told about itself in the handshake, nil before.
+	peer *PeerInfo
+	// codec is used for sessions opened on this connection, JSON until the handshake picked a better one.
+	codec Codec
+	// clockOffset is how far the clock of the partner is ahead, see clock.go.
+	clockOffset   time.Duration
+	clockMeasured bool
 }
 
 func newRpcConnection(conn quic.Connection,
@@ -66,6 +74,7 @@ func newRpcConnection(conn quic.Connection,
 		protocol:       protocol,
 		credentials:    credentials,
 		verifier:       verifier,
+		codec:          jsonCodec{},
 	}
 }
 
@@ -89,7 +98,7 @@ func (conn *RpcConnection) serveRpc(commands *CommandCollection) error {
 	for {
 		log.Printf("Waiting for incoming QUIC stream...")
 
-		session, err := conn.AcceptSession(context.Background())
+		session, err := conn.AcceptSession(conn.connection.Context())
 
 		log.Printf("Session requested")
 		if err != nil {
@@ -121,12 +130,14 @@ func (conn *RpcConnection) serveRpc(commands *CommandCollection) error {
 
 }
 
-func (conn *RpcConnection) AcceptSession(context.Context) (*RpcSession, error) {
-	stream, err := conn.connection.AcceptStream(context.Background())
+// AcceptSession waits for the partner to open a session until ctx ends.
+// Accepted sessions live as long as the connection or the deadline of their request.
+func (conn *RpcConnection) AcceptSession(ctx context.Context) (*RpcSession, error) {
+	stream, err := conn.connection.AcceptStream(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error accepting QUIC stream: %w", err)
 	}
-	session := newRpcSession(stream, conn)
+	session := newRpcSession(conn.connection.Context(), stream, conn)
 
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
@@ -136,6 +147,7 @@ func (conn *RpcConnection) AcceptSession(context.Context) (*RpcSession, error) {
 	return session, nil
 }
 
+// OpenSession opens a session with the partner, it is canceled when ctx ends.
 func (conn *RpcConnection) OpenSession(ctx context.Context) (*RpcSession, error) {
 	err := conn.ensureState(RpcConnectionOpen)
 	if err != nil {
@@ -147,7 +159,7 @@ func (conn *RpcConnection) OpenSession(ctx context.Context) (*RpcSession, error)
 		return nil, fmt.Errorf("error opening QUIC stream: %w", err)
 	}
 
-	return newRpcSession(stream, conn), nil
+	return newRpcSession(ctx, stream, conn), nil
 }
 
 func (conn *RpcConnection) mutateState(from RpcConnectionState, to RpcConnectionState) error {
@@ -183,8 +195,12 @@ func (conn *RpcConnection) Close(code quic.ApplicationErrorCode, msg string) err
 		return fmt.Errorf("error closing connection: %w", err)
 	}
 
+	// closing sessions removes them from the map
 	conn.mutex.Lock()
-	sessionsToClose := conn.activeSessions
+	sessionsToClose := make([]*RpcSession, 0, len(conn.activeSessions))
+	for _, session := range conn.activeSessions {
+		sessionsToClose = append(sessionsToClose, session)
+	}
 	conn.mutex.Unlock()
 
 	// tell all connections to close
@@ -236,3 +252,17 @@ func (conn *RpcConnection) GetProtocol() TlsConnectionProto {
 func (conn *RpcConnection) Partner() *pki.Certificate {
 	return conn.partner
 }
+
+// Codec returns the codec negotiated with the partner.
+func (conn *RpcConnection) Codec() Codec {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.codec
+}
+
+// Peer returns the protocol version, build and commands of the partner, nil if there was no handshake.
+func (conn *RpcConnection) Peer() *PeerInfo {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	return conn.peer
+}
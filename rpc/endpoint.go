@@ -23,9 +23,13 @@ type RpcEndpoint struct {
 	conn  *RpcConnection
 	state RpcEndpointState
 	mutex sync.Mutex
+	// interceptors run around commands sent to the server, see Intercept.
+	interceptors []Interceptor
 }
 
-func ConnectToServer(ctx context.Context, addr string, credentials pki.Credentials, partner *pki.Certificate, verifier pki.Verifier) (*RpcEndpoint, error) {
+// ConnectToServer dials the server and negotiates the protocol with it.
+// commands are the keys of the commands served over the connection, they are announced to the server.
+func ConnectToServer(ctx context.Context, addr string, credentials pki.Credentials, partner *pki.Certificate, verifier pki.Verifier, commands []string) (*RpcEndpoint, error) {
 	if addr == "" {
 		return nil, fmt.Errorf("address cannot be empty")
 	}
@@ -34,7 +38,8 @@ func ConnectToServer(ctx context.Context, addr string, credentials pki.Credentia
 		return nil, fmt.Errorf("partner cannot be nil")
 	}
 
-	tlsConf := getTlsClientConfig(ProtoRpc, credentials)
+	// the legacy protocol is offered to give a clear error on servers without a handshake
+	tlsConf := getTlsClientConfig([]TlsConnectionProto{ProtoRpc, ProtoRpcLegacy}, credentials)
 
 	quicConf := &quic.Config{
 		KeepAlivePeriod: 30 * time.Second,
@@ -49,7 +54,18 @@ func ConnectToServer(ctx context.Context, addr string, credentials pki.Credentia
 		return nil, fmt.Errorf("error creating QUIC connection: %w", err)
 	}
 
-	rpcConn := newRpcConnection(quicConn, nil, RpcRoleClient, util.NewNonceStorage(), partner, ProtoRpc, credentials, verifier)
+	if TlsConnectionProto(quicConn.ConnectionState().TLS.NegotiatedProtocol) != ProtoRpc {
+		quicConn.CloseWithError(426, "protocol version not supported")
+		return nil, fmt.Errorf("%w: server does not support protocol version negotiation, update the server", ErrIncompatiblePeer)
+	}
+
+	rpcConn := newRpcConnection(quicConn, nil, RpcRoleClient, nonces, partner, ProtoRpc, credentials, verifier)
+
+	err = rpcConn.openHandshake(ctx, commands)
+	if err != nil {
+		rpcConn.Close(426, "handshake failed")
+		return nil, fmt.Errorf("error during handshake: %w", err)
+	}
 
 	ep := &RpcEndpoint{
 		conn:  rpcConn,
@@ -60,7 +76,19 @@ func ConnectToServer(ctx context.Context, addr string, credentials pki.Credentia
 	return ep, nil
 }
 
+// Intercept adds interceptors that run around commands sent over the endpoint.
+// Commands sent to another partner are intercepted before they are wrapped for forwarding.
+func (r *RpcEndpoint) Intercept(interceptors ...Interceptor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.interceptors = append(r.interceptors, interceptors...)
+}
+
 func (r *RpcEndpoint) SendCommand(ctx context.Context, cmd RpcCommand) (util.AsyncAction, error) {
+	return r.send(ctx, cmd, cmd)
+}
+
+func (r *RpcEndpoint) send(ctx context.Context, cmd RpcCommand, intercepted RpcCommand) (util.AsyncAction, error) {
 	if r == nil {
 		return nil, fmt.Errorf("endpoint is nil")
 	}
@@ -75,7 +103,11 @@ func (r *RpcEndpoint) SendCommand(ctx context.Context, cmd RpcCommand) (util.Asy
 		return nil, fmt.Errorf("error opening session: %w", err)
 	}
 
-	running, err := session.sendCommand(cmd)
+	r.mutex.Lock()
+	interceptors := r.interceptors
+	r.mutex.Unlock()
+
+	running, err := sendIntercepted(interceptors, session, cmd, intercepted)
 	if err != nil {
 		return nil, fmt.Errorf("error sending command: %w", err)
 	}
@@ -117,7 +149,7 @@ func (r *RpcEndpoint) SendCommandTo(ctx context.Context, to *pki.Certificate, cm
 	}
 
 	forward := newForwardCommand(to, encrypt)
-	return r.SendCommand(ctx, forward)
+	return r.send(ctx, forward, cmd)
 }
 
 func (r *RpcEndpoint) SendSyncCommandTo(ctx context.Context, to *pki.Certificate, cmd RpcCommand) error {
@@ -159,6 +191,11 @@ func (r *RpcEndpoint) ServeRpc(commands *CommandCollection) error {
 	return r.conn.serveRpc(commands)
 }
 
+// Peer returns the protocol version, build and commands of the server.
+func (r *RpcEndpoint) Peer() *PeerInfo {
+	return r.conn.Peer()
+}
+
 func (r *RpcEndpoint) Credentials() *pki.PermanentCredentials {
 	credentials := r.conn.credentials
 
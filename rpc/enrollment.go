@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"sync"
 	"time"
 
@@ -15,7 +16,11 @@ import (
 
 type EnrollmentManager interface {
 	util.ObservableMap[string, *Enrollment]
-	AcceptEnrollment(cert *pki.Certificate) error
+	// AcceptEnrollment issues the certificate to the waiting agent.
+	// Unless the enrollment presented a token, code must match the verification code shown by the agent.
+	AcceptEnrollment(cert *pki.Certificate, code string) (*Enrollment, error)
+	// RejectEnrollment closes the waiting connection and tells the agent the reason.
+	RejectEnrollment(key *pki.PublicKey, reason string) (*Enrollment, error)
 }
 
 type EndPointInitInfo struct {
@@ -28,6 +33,9 @@ type enrollmentManager struct {
 	waitingEnrollments util.UpdateableMap[string, *enrollmentConnection]
 	upstream           *pki.Certificate
 	root               *pki.Certificate
+	tokenHandler       func(token []byte) (*EnrollmentPreset, time.Time, error)
+	autoEnroll         func(enrollment *Enrollment)
+	filter             func(key *pki.PublicKey, addr net.Addr) error
 	mutex              sync.Mutex
 }
 
@@ -35,6 +43,9 @@ type enrollmentConnection struct {
 	connection *RpcConnection
 	session    *RpcSession
 	enrollment *Enrollment
+	deadline   time.Time
+	code       string
+	attempts   int
 	mutex      sync.Mutex
 }
 
@@ -42,6 +53,22 @@ type Enrollment struct {
 	PublicKey   *pki.PublicKey
 	Addr        string
 	RequestTime time.Time
+	Preset      *EnrollmentPreset `json:",omitempty"`
+	Metadata    EnrollmentMetadata
+}
+
+// EnrollmentMetadata is reported by the agent when enrolling.
+// It is not verified and only helps identifying the device.
+type EnrollmentMetadata struct {
+	Hostname    string
+	OS          string
+	HardwareIDs []string `json:",omitempty"`
+	MACs        []string `json:",omitempty"`
+}
+
+type enrollmentRequest struct {
+	Token    []byte
+	Metadata EnrollmentMetadata
 }
 
 const maxEnrollmentTime = 5 * time.Minute
@@ -55,13 +82,22 @@ func newEnrollmentManager(upstream *pki.Certificate, root *pki.Certificate) *enr
 	}
 }
 
+// updateRoot replaces the certificates handed out to enrolled agents.
+func (m *enrollmentManager) updateRoot(upstream *pki.Certificate, root *pki.Certificate) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.upstream = upstream
+	m.root = root
+}
+
 func (m *enrollmentManager) cleanup() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	timeout := make([]string, 0)
 	m.waitingEnrollments.ForEach(func(key string, econn *enrollmentConnection) error {
 		if econn.mutex.TryLock() {
-			if time.Since(econn.enrollment.RequestTime) > maxEnrollmentTime {
+			if time.Now().After(econn.deadline) {
 				timeout = append(timeout, key)
 			}
 			econn.mutex.Unlock()
@@ -93,34 +129,100 @@ func (m *enrollmentManager) startEnrollment(conn *RpcConnection) error {
 		return fmt.Errorf("error exchanging keys: %w", err)
 	}
 
+	if m.filter != nil {
+		err = m.filter(session.partnerKey, conn.connection.RemoteAddr())
+		if err != nil {
+			conn.Close(403, "enrollment blocked")
+			return fmt.Errorf("enrollment blocked: %w", err)
+		}
+	}
+
+	request := &enrollmentRequest{}
+	err = ReadMessage[*enrollmentRequest](session, request)
+	if err != nil {
+		conn.Close(400, "error reading enrollment request")
+		return fmt.Errorf("error reading enrollment request: %w", err)
+	}
+
+	challenge, err := newEnrollmentChallenge()
+	if err != nil {
+		conn.Close(500, "error creating enrollment challenge")
+		return fmt.Errorf("error creating enrollment challenge: %w", err)
+	}
+
+	err = WriteMessage[*enrollmentChallenge](session, challenge)
+	if err != nil {
+		conn.Close(500, "error writing enrollment challenge")
+		return fmt.Errorf("error writing enrollment challenge: %w", err)
+	}
+
+	code := verificationCode(session.partnerKey, session.credentials.PublicKey(), challenge.Nonce)
+
 	encodedKey := session.partnerKey.Base64Encode()
 
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
 	_, ok := m.waitingEnrollments.Get(encodedKey)
 	if ok {
+		m.mutex.Unlock()
+		conn.Close(409, "enrollment already in progress")
 		return fmt.Errorf("enrollment already in progress")
 	}
 
+	requestTime := time.Now()
+	deadline := requestTime.Add(maxEnrollmentTime)
+
+	var preset *EnrollmentPreset
+	if len(request.Token) > 0 {
+		if m.tokenHandler == nil {
+			m.mutex.Unlock()
+			conn.Close(403, "enrollment tokens not supported")
+			return fmt.Errorf("enrollment token presented, but no token handler set")
+		}
+
+		var expires time.Time
+		preset, expires, err = m.tokenHandler(request.Token)
+		if err != nil {
+			m.mutex.Unlock()
+			conn.Close(403, "invalid enrollment token")
+			return fmt.Errorf("invalid enrollment token: %w", err)
+		}
+
+		// the token does not extend how long a connection may be parked
+		if expires.Before(deadline) {
+			deadline = expires
+		}
+	}
+
+	enrollment := &Enrollment{
+		PublicKey:   session.partnerKey,
+		Addr:        conn.connection.RemoteAddr().String(),
+		RequestTime: requestTime,
+		Preset:      preset,
+		Metadata:    request.Metadata,
+	}
+
 	m.waitingEnrollments.Set(encodedKey,
 		&enrollmentConnection{
 			connection: conn,
 			session:    session,
 			mutex:      sync.Mutex{},
-			enrollment: &Enrollment{
-				PublicKey:   session.partnerKey,
-				Addr:        conn.connection.RemoteAddr().String(),
-				RequestTime: time.Now(),
-			},
+			deadline:   deadline,
+			code:       code,
+			enrollment: enrollment,
 		},
 	)
+	m.mutex.Unlock()
 
 	log.Printf("enrollment started for %s", encodedKey)
 
+	if preset != nil && m.autoEnroll != nil {
+		go m.autoEnroll(enrollment)
+	}
+
 	return nil
 }
 
-func (m *enrollmentManager) AcceptEnrollment(cert *pki.Certificate) error {
+func (m *enrollmentManager) AcceptEnrollment(cert *pki.Certificate, code string) (*Enrollment, error) {
 	m.cleanup()
 	encodedKey := cert.PublicKey().Base64Encode()
 
@@ -129,7 +231,7 @@ func (m *enrollmentManager) AcceptEnrollment(cert *pki.Certificate) error {
 	econn, ok := m.waitingEnrollments.Get(encodedKey)
 
 	if !ok {
-		return fmt.Errorf("enrollment not in progress")
+		return nil, fmt.Errorf("enrollment not in progress")
 	}
 
 	log.Printf("trying to aquire lock")
@@ -139,25 +241,61 @@ func (m *enrollmentManager) AcceptEnrollment(cert *pki.Certificate) error {
 
 	log.Printf("enrollment lock aquired")
 
+	if econn.enrollment.Preset == nil && !verificationCodesEqual(econn.code, code) {
+		econn.attempts++
+		if econn.attempts >= maxVerificationAttempts {
+			log.Printf("too many wrong verification codes, dropping enrollment for %s", encodedKey)
+			m.waitingEnrollments.Delete(encodedKey)
+			econn.connection.Close(403, "verification failed")
+		}
+		return nil, ErrInvalidVerificationCode
+	}
+
 	m.waitingEnrollments.Delete(encodedKey)
 
+	m.mutex.Lock()
 	reponse := &enrollmentResponse{
 		Cert:     cert,
 		Root:     m.root,
 		Upstream: m.upstream,
 	}
+	m.mutex.Unlock()
 
 	err := WriteMessage[*enrollmentResponse](econn.session, reponse)
 	if err != nil {
 		econn.connection.Close(500, "error writing response")
-		return fmt.Errorf("error writing response: %w", err)
+		return nil, fmt.Errorf("error writing response: %w", err)
 	}
 
 	time.Sleep(5 * time.Second)
 
 	econn.session.Close()
 
-	return nil
+	return econn.enrollment, nil
+}
+
+func (m *enrollmentManager) RejectEnrollment(key *pki.PublicKey, reason string) (*Enrollment, error) {
+	m.cleanup()
+	encodedKey := key.Base64Encode()
+
+	econn, ok := m.waitingEnrollments.Get(encodedKey)
+	if !ok {
+		return nil, fmt.Errorf("enrollment not in progress")
+	}
+
+	econn.mutex.Lock()
+	defer econn.mutex.Unlock()
+
+	m.waitingEnrollments.Delete(encodedKey)
+
+	log.Printf("enrollment rejected for %s: %s", encodedKey, reason)
+
+	err := econn.connection.Close(403, "enrollment rejected: "+reason)
+	if err != nil {
+		return nil, fmt.Errorf("error closing connection: %w", err)
+	}
+
+	return econn.enrollment, nil
 }
 
 func (m *enrollmentManager) Subscribe(onSet func(string, *Enrollment), onRemove func(string, *Enrollment)) func() {
@@ -186,9 +324,12 @@ type enrollmentResponse struct {
 	Upstream *pki.Certificate
 }
 
-func EnrollWithUpstream(addr string) (*EndPointInitInfo, error) {
+// EnrollWithUpstream waits for the upstream to issue a certificate.
+// If a token is given, the enrollment is approved without interaction.
+// Otherwise onCode receives the verification code the approving user has to enter.
+func EnrollWithUpstream(addr string, token []byte, metadata EnrollmentMetadata, onCode func(code string)) (*EndPointInitInfo, error) {
 
-	tlsConf := getTlsTempClientConfig([]TlsConnectionProto{ProtoAgentEnroll})
+	tlsConf := getTlsTempClientConfig([]TlsConnectionProto{ProtoAgentEnroll, ProtoAgentEnrollLegacy})
 
 	quicConf := &quic.Config{
 		KeepAlivePeriod: 30 * time.Second,
@@ -203,14 +344,20 @@ func EnrollWithUpstream(addr string) (*EndPointInitInfo, error) {
 		return nil, fmt.Errorf("error creating QUIC connection: %w", err)
 	}
 
-	initNonceStorage = util.NewNonceStorage()
+	protocol := TlsConnectionProto(quicConn.ConnectionState().TLS.NegotiatedProtocol)
+	legacy := protocol == ProtoAgentEnrollLegacy
+
+	if legacy && len(token) > 0 {
+		quicConn.CloseWithError(0, "")
+		return nil, fmt.Errorf("server does not support enrollment tokens")
+	}
 
 	tempCredentials, err := pki.GenerateCredentials()
 	if err != nil {
 		return nil, fmt.Errorf("error generating temp credentials: %w", err)
 	}
 
-	conn := newRpcConnection(quicConn, nil, RpcRoleInit, initNonceStorage, nil, ProtoAgentEnroll, tempCredentials, pki.NewNilVerifier())
+	conn := newRpcConnection(quicConn, nil, RpcRoleInit, nonces, nil, protocol, tempCredentials, pki.NewNilVerifier())
 	defer conn.Close(0, "")
 
 	session, err := conn.OpenSession(context.Background())
@@ -229,6 +376,27 @@ func EnrollWithUpstream(addr string) (*EndPointInitInfo, error) {
 		return nil, fmt.Errorf("error exchanging keys: %w", err)
 	}
 
+	// older servers neither read the request nor send a challenge, they are approved without a code
+	if !legacy {
+		err = WriteMessage[enrollmentRequest](session, enrollmentRequest{
+			Token:    token,
+			Metadata: metadata,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error sending enrollment request: %w", err)
+		}
+
+		challenge := &enrollmentChallenge{}
+		err = ReadMessage[*enrollmentChallenge](session, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("error reading enrollment challenge: %w", err)
+		}
+
+		if len(token) == 0 {
+			onCode(verificationCode(tempCredentials.PublicKey(), session.partnerKey, challenge.Nonce))
+		}
+	}
+
 	response := &enrollmentResponse{}
 
 	err = ReadMessage[*enrollmentResponse](session, response)
@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rahn-it/svalin/pki"
+)
+
+// ErrInvalidVerificationCode is returned when an enrollment is accepted with a wrong verification code.
+var ErrInvalidVerificationCode = errors.New("invalid verification code")
+
+const (
+	enrollmentNonceLength  = 32
+	verificationCodeDigits = 8
+	// maxVerificationAttempts limits how often a wrong code may be entered before the enrollment is dropped.
+	maxVerificationAttempts = 3
+)
+
+// enrollmentChallenge is sent by the server after the key exchange.
+// The nonce makes the verification code unique to this enrollment attempt.
+type enrollmentChallenge struct {
+	Nonce []byte
+}
+
+func newEnrollmentChallenge() (*enrollmentChallenge, error) {
+	nonce := make([]byte, enrollmentNonceLength)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return &enrollmentChallenge{
+		Nonce: nonce,
+	}, nil
+}
+
+// verificationCode derives a short code both ends of an enrollment can compute.
+// It covers both public keys, so an impostor or a man in the middle ends up with a different code.
+func verificationCode(agent *pki.PublicKey, server *pki.PublicKey, nonce []byte) string {
+	hash := sha256.New()
+	hash.Write(agent.BinaryEncode())
+	hash.Write(server.BinaryEncode())
+	hash.Write(nonce)
+	sum := hash.Sum(nil)
+
+	n := binary.BigEndian.Uint64(sum[:8]) % 100_000_000
+	code := fmt.Sprintf("%0*d", verificationCodeDigits, n)
+
+	return code[:verificationCodeDigits/2] + " " + code[verificationCodeDigits/2:]
+}
+
+// normalizeVerificationCode strips the separators a user might type.
+func normalizeVerificationCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, code)
+}
+
+func verificationCodesEqual(expected string, entered string) bool {
+	a := normalizeVerificationCode(expected)
+	b := normalizeVerificationCode(entered)
+
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
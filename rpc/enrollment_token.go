@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahn-it/svalin/pki"
+)
+
+// tokenNumberPlaceholder is replaced by the number of the enrollment in the token name pattern.
+const tokenNumberPlaceholder = "{n}"
+
+// EnrollmentToken pre-approves agents that present it when enrolling.
+// It is signed by the issuing user, who delegates issuing the agent certificates to Signer.
+// The server holds the key of the signer until the token expires and issues the certificates without interaction.
+type EnrollmentToken struct {
+	ID          string
+	NamePattern string
+	Tags        []string
+	Group       string
+	Expires     time.Time
+	SingleUse   bool
+	Signer      *pki.Certificate `json:",omitempty"`
+}
+
+// EnrollmentPreset is attached to an enrollment that presented a valid token.
+// It is published to the clients, so it only refers to the token by its ID.
+type EnrollmentPreset struct {
+	TokenID string
+	Issuer  *pki.Certificate
+	Name    string
+	Tags    []string
+	Group   string
+}
+
+// NewEnrollmentToken creates a token with a new signer and returns it in a form that can be passed to an agent.
+// The returned signer credentials have to be handed to the server, so it can issue the agent certificates.
+func NewEnrollmentToken(credentials *pki.PermanentCredentials, namePattern string, tags []string, group string, validFor time.Duration, singleUse bool) (string, *pki.PermanentCredentials, error) {
+	id := uuid.NewString()
+
+	signerKey, err := pki.GenerateCredentials()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate signer key: %w", err)
+	}
+
+	signerCert, err := pki.CreateSignerCert("enrollment-"+id, signerKey.PublicKey(), credentials, validFor)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create signer certificate: %w", err)
+	}
+
+	token := &EnrollmentToken{
+		ID:          id,
+		NamePattern: namePattern,
+		Tags:        tags,
+		Group:       group,
+		Expires:     time.Now().Add(validFor),
+		SingleUse:   singleUse,
+		Signer:      signerCert,
+	}
+
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	blob, err := pki.NewSignedBlob(credentials, payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	signer := pki.CredentialsFromCertAndKey(signerCert, signerKey.PrivateKey())
+
+	return base64.RawURLEncoding.EncodeToString(blob.Raw()), signer, nil
+}
+
+// DecodeEnrollmentToken turns a token passed to an agent back into its signed form.
+func DecodeEnrollmentToken(encoded string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	return raw, nil
+}
+
+// LoadEnrollmentToken verifies the signature of a token and returns it together with its issuer.
+// Expired tokens are rejected.
+func LoadEnrollmentToken(raw []byte, verifier pki.Verifier) (*EnrollmentToken, *pki.Certificate, error) {
+	blob, err := pki.LoadSignedBlob(raw, verifier)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	issuer := blob.Creator()
+	if issuer.Type() != pki.CertTypeUser && issuer.Type() != pki.CertTypeRoot {
+		return nil, nil, fmt.Errorf("token was not issued by a user")
+	}
+
+	token := &EnrollmentToken{}
+	err = json.Unmarshal(blob.Payload(), token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal token: %w", err)
+	}
+
+	if time.Now().After(token.Expires) {
+		return nil, nil, fmt.Errorf("token expired at %s", token.Expires.Format(time.RFC3339))
+	}
+
+	if token.Signer != nil && (token.Signer.Type() != pki.CertTypeSigner || !token.Signer.IsIssuedBy(issuer)) {
+		return nil, nil, fmt.Errorf("token signer was not issued by the token issuer")
+	}
+
+	return token, issuer, nil
+}
+
+// DeviceName returns the name of the n-th device enrolled with this token.
+func (t *EnrollmentToken) DeviceName(n uint64) string {
+	return strings.ReplaceAll(t.NamePattern, tokenNumberPlaceholder, strconv.FormatUint(n, 10))
+}
+
+// MatchesName checks if the name could have been produced by the name pattern.
+func (t *EnrollmentToken) MatchesName(name string) bool {
+	prefix, suffix, found := strings.Cut(t.NamePattern, tokenNumberPlaceholder)
+	if !found {
+		return name == t.NamePattern
+	}
+
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return false
+	}
+
+	n, err := strconv.ParseUint(name[len(prefix):len(name)-len(suffix)], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return t.DeviceName(n) == name
+}
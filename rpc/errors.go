@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/quic-go/quic-go"
+)
+
+// The kinds of errors a command can fail with.
+// Handlers return them, wrapped or not, the session maps them to a response code,
+// and clients match them with errors.Is on the errors returned by SendCommand and Wait.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrUnavailable      = errors.New("unavailable")
+	ErrInternal         = errors.New("internal error")
+	ErrTimeout          = errors.New("timeout")
+	ErrRateLimited      = errors.New("rate limited")
+)
+
+type errorKind struct {
+	name string
+	err  error
+	code int
+}
+
+// errorKinds is in order of precedence, ErrInternal is the fallback.
+var errorKinds = []errorKind{
+	{name: "not-found", err: ErrNotFound, code: 404},
+	{name: "permission-denied", err: ErrPermissionDenied, code: 403},
+	{name: "invalid-argument", err: ErrInvalidArgument, code: 400},
+	{name: "unavailable", err: ErrUnavailable, code: 503},
+	{name: "timeout", err: ErrTimeout, code: 504},
+	{name: "rate-limited", err: ErrRateLimited, code: 429},
+	{name: "internal", err: ErrInternal, code: 500},
+}
+
+// ErrorInfo is sent as Info of error responses.
+type ErrorInfo struct {
+	Kind    string            `json:"kind"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// kindOfError returns the kind err matches, unknown errors are internal.
+func kindOfError(err error) errorKind {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind
+		}
+	}
+	return errorKinds[len(errorKinds)-1]
+}
+
+func kindByName(name string) (errorKind, bool) {
+	for _, kind := range errorKinds {
+		if kind.name == name {
+			return kind, true
+		}
+	}
+	return errorKind{}, false
+}
+
+// kindOfCode maps response codes of peers that don't send an ErrorInfo.
+func kindOfCode(code int) errorKind {
+	var name string
+	switch code {
+	case 400, 409, 422:
+		name = "invalid-argument"
+	case 401, 403:
+		name = "permission-denied"
+	case 404, 405:
+		name = "not-found"
+	case 408, 504:
+		name = "timeout"
+	case 426, 503:
+		name = "unavailable"
+	case 429:
+		name = "rate-limited"
+	default:
+		name = "internal"
+	}
+
+	kind, _ := kindByName(name)
+	return kind
+}
+
+type detailError struct {
+	err   error
+	key   string
+	value string
+}
+
+func (e *detailError) Error() string {
+	return e.err.Error()
+}
+
+func (e *detailError) Unwrap() error {
+	return e.err
+}
+
+// WithDetail attaches a detail to err, it is sent to the client along with the kind of err.
+func WithDetail(err error, key string, value string) error {
+	return &detailError{
+		err:   err,
+		key:   key,
+		value: value,
+	}
+}
+
+func errorDetails(err error) map[string]string {
+	var details map[string]string
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		detail, ok := err.(*detailError)
+		if !ok {
+			continue
+		}
+
+		if details == nil {
+			details = make(map[string]string)
+		}
+		// the outermost detail wins
+		if _, ok := details[detail.key]; !ok {
+			details[detail.key] = detail.value
+		}
+	}
+
+	return details
+}
+
+// WriteError responds with the code and info for the kind of err.
+// The message of internal errors is not sent, it may contain details the client shouldn't see.
+func (s *RpcSession) WriteError(err error) error {
+	kind := kindOfError(err)
+
+	msg := err.Error()
+	if kind.err == ErrInternal {
+		msg = ErrInternal.Error()
+	}
+
+	return s.WriteResponseHeader(SessionResponseHeader{
+		Code: kind.code,
+		Msg:  msg,
+		Info: &ErrorInfo{
+			Kind:    kind.name,
+			Details: errorDetails(err),
+		},
+	})
+}
+
+// newSessionError creates the error for a response that wasn't successful.
+func newSessionError(header SessionResponseHeader) *SessionError {
+	kind := kindOfCode(header.Code)
+
+	info := &ErrorInfo{}
+	if header.Info != nil && reEncode(header.Info, info) == nil {
+		if k, ok := kindByName(info.Kind); ok {
+			kind = k
+		}
+	}
+
+	return &SessionError{
+		code:    header.Code,
+		msg:     header.Msg,
+		kind:    kind.err,
+		details: info.Details,
+	}
+}
+
+// classifyError gives errors that didn't come from the partner a kind,
+// so clients can tell timeouts and lost connections from failed commands.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return err
+		}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	var appErr *quic.ApplicationError
+	var resetErr *quic.StatelessResetError
+	if errors.As(err, &appErr) || errors.As(err, &resetErr) || errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return err
+}
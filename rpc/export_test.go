@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/util"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Hooks for the tests in rpc_test, they are only compiled with the tests.
+
+// NewTestSessionPair connects a requester and a responder session in memory.
+// Every message the requester writes passes through relay, which may change, drop or reorder messages.
+func NewTestSessionPair(requester *pki.PermanentCredentials, responder *pki.PermanentCredentials, relay func(msg []byte) [][]byte) (*RpcSession, *RpcSession) {
+	requesterEnd, relayIn := net.Pipe()
+	relayOut, responderEnd := net.Pipe()
+
+	go func() {
+		defer relayOut.Close()
+		for {
+			msg, err := util.ReadSingleDer(relayIn)
+			if err != nil {
+				return
+			}
+
+			for _, out := range relay(msg) {
+				_, err := relayOut.Write(out)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer relayIn.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := relayOut.Read(buf)
+			if err != nil {
+				return
+			}
+
+			_, err = relayIn.Write(buf[:n])
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return newTestSession(requesterEnd, requester, responder.Certificate()),
+		newTestSession(responderEnd, responder, requester.Certificate())
+}
+
+func newTestSession(stream net.Conn, credentials *pki.PermanentCredentials, partner *pki.Certificate) *RpcSession {
+	conn := &RpcConnection{
+		partner:        partner,
+		activeSessions: make(map[quic.StreamID]*RpcSession),
+		nonceStorage:   nonces,
+		credentials:    credentials,
+		codec:          jsonCodec{},
+		clockMeasured:  true,
+	}
+
+	return &RpcSession{
+		stream:      stream,
+		ctx:         context.Background(),
+		connection:  conn,
+		state:       RpcSessionCreated,
+		mutex:       sync.Mutex{},
+		partnerKey:  partner.PublicKey(),
+		partner:     partner,
+		credentials: credentials,
+		codec:       conn.codec,
+	}
+}
+
+// WriteTestRequest sends a request header for the given command.
+func WriteTestRequest(s *RpcSession, cmd string) error {
+	return s.writeRequestHeader(sessionRequestHeader{Cmd: cmd})
+}
+
+// ReadTestRequest reads a request header and returns its command.
+func ReadTestRequest(s *RpcSession) (string, error) {
+	header, err := s.readRequestHeader()
+	return header.Cmd, err
+}
+
+// ReadTestResponse reads the response header.
+func ReadTestResponse(s *RpcSession) (SessionResponseHeader, error) {
+	return s.readResponseHeader()
+}
+
+// RestartTestSession prepares the session for the next header, like forwarding and encrypting a session do.
+func RestartTestSession(s *RpcSession) error {
+	return s.mutateState(RpcSessionOpen, RpcSessionCreated)
+}
+
+// UsesMac returns whether the session authenticates messages with the session MAC in both directions.
+func UsesMac(s *RpcSession) bool {
+	return s.sendMac != nil && s.recvMac != nil
+}
+
+// ForwardTestSession makes the session look like it was forwarded by server, whose clock is serverOffset ahead.
+// targetOffset is what the server told about the clock of the far peer, nil if it did not measure it.
+func ForwardTestSession(s *RpcSession, server *pki.Certificate, serverOffset time.Duration, targetOffset *time.Duration) {
+	s.connection.partner = server
+	s.connection.setClockOffset(serverOffset)
+	s.setForwardedClock(targetOffset)
+}
+
+// ServerAddr returns the address the server listens on.
+func ServerAddr(s *RpcServer) string {
+	return s.listener.Addr().String()
+}
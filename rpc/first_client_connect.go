@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/rahn-it/svalin/pki"
-	"github.com/rahn-it/svalin/util"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -26,11 +25,9 @@ func FirstClientConnect(addr string) (*RpcConnection, error) {
 		return nil, fmt.Errorf("error creating QUIC connection: %w", err)
 	}
 
-	initNonceStorage = util.NewNonceStorage()
-
 	protocol := quicConn.ConnectionState().TLS.NegotiatedProtocol
 
-	conn := newRpcConnection(quicConn, nil, RpcRoleInit, initNonceStorage, nil, TlsConnectionProto(protocol), nil, pki.NewNilVerifier())
+	conn := newRpcConnection(quicConn, nil, RpcRoleInit, nonces, nil, TlsConnectionProto(protocol), nil, pki.NewNilVerifier())
 
 	return conn, nil
 }
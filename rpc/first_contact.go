@@ -26,6 +26,8 @@ func exchangeKeys(session *RpcSession) error {
 
 type keyPayload struct {
 	PubKey []byte
+	// Codec names the encoding of the following messages, it is left out for JSON so older peers can still parse the payload.
+	Codec string `asn1:"optional,utf8"`
 }
 
 func receivePartnerKey(session *RpcSession) error {
@@ -48,7 +50,13 @@ func receivePartnerKey(session *RpcSession) error {
 		return fmt.Errorf("failed to unmarshal data: %w", err)
 	}
 
+	codec, err := GetCodec(payload.Codec)
+	if err != nil {
+		return fmt.Errorf("partner announced unsupported codec: %w", err)
+	}
+
 	session.partnerKey = partnerKey
+	session.codec = codec
 
 	return nil
 }
@@ -56,9 +64,15 @@ func receivePartnerKey(session *RpcSession) error {
 func sendMyKey(session *RpcSession) error {
 	credentials := session.credentials
 
-	packed, err := asn1.Marshal(keyPayload{
+	payload := keyPayload{
 		PubKey: credentials.PublicKey().BinaryEncode(),
-	})
+	}
+
+	if session.codec.Name() != CodecJson {
+		payload.Codec = session.codec.Name()
+	}
+
+	packed, err := asn1.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to pack data to asn1: %w", err)
 	}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/rahn-it/svalin/pki"
 )
@@ -17,6 +18,14 @@ type forwardCommand struct {
 	cmd    RpcCommand
 }
 
+// forwardInfo tells the client which codecs the target understands, the forwarded part of the session is relayed as is.
+// It is only sent to peers that announced codecs themselves.
+type forwardInfo struct {
+	Codecs []string
+	// ClockOffset is how far the clock of the target was ahead of the server's, if the server measured it.
+	ClockOffset *time.Duration `json:",omitempty"`
+}
+
 func newForwardCommand(target *pki.Certificate, cmd RpcCommand) *forwardCommand {
 	return &forwardCommand{
 		Target: target,
@@ -59,7 +68,7 @@ func (f *forwardCommand) ExecuteServer(session *RpcSession) error {
 	defer func() {
 		err := forwardSession.Close()
 		if err != nil {
-			panic(err)
+			log.Printf("error closing forwarded session: %v", err)
 		}
 	}()
 
@@ -81,6 +90,22 @@ func (f *forwardCommand) ExecuteServer(session *RpcSession) error {
 		return fmt.Errorf("error writing response header: %w", err)
 	}
 
+	if peer := session.connection.Peer(); peer != nil && len(peer.Codecs) > 0 {
+		info := forwardInfo{}
+		if target := conn.Peer(); target != nil {
+			info.Codecs = target.Codecs
+		}
+
+		if offset, measured := conn.measuredClockOffset(); measured {
+			info.ClockOffset = &offset
+		}
+
+		err = WriteMessage[forwardInfo](session, info)
+		if err != nil {
+			return fmt.Errorf("error writing forward info: %w", err)
+		}
+	}
+
 	errChan := make(chan error)
 
 	go func() {
@@ -105,6 +130,17 @@ func (f *forwardCommand) ExecuteServer(session *RpcSession) error {
 
 func (f *forwardCommand) ExecuteClient(session *RpcSession) error {
 
+	if peer := session.connection.Peer(); peer != nil && len(peer.Codecs) > 0 {
+		info := &forwardInfo{}
+		err := ReadMessage[*forwardInfo](session, info)
+		if err != nil {
+			return fmt.Errorf("error reading forward info: %w", err)
+		}
+
+		session.codec = negotiateCodec(info.Codecs)
+		session.setForwardedClock(info.ClockOffset)
+	}
+
 	err := session.mutateState(RpcSessionOpen, RpcSessionCreated)
 	if err != nil {
 		return fmt.Errorf("error mutating session state: %w", err)
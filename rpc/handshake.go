@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// ProtocolVersion is the version of the RPC protocol spoken by this build.
+// Increase it whenever a change needs both peers to know about it.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest version this build still talks to.
+const MinProtocolVersion = 1
+
+const handshakeTimeout = 10 * time.Second
+
+// BuildVersion can be set at link time with -ldflags "-X github.com/rahn-it/svalin/rpc.BuildVersion=...".
+// If it is empty, the version is taken from the build info of the binary.
+var BuildVersion = ""
+
+var ErrIncompatiblePeer = errors.New("incompatible peer")
+
+// PeerInfo is exchanged by both peers when an RPC connection is opened.
+type PeerInfo struct {
+	ProtocolVersion    int
+	MinProtocolVersion int
+	BuildVersion       string
+	// Commands lists the keys of the commands the peer serves.
+	Commands []string
+	// Codecs lists the message encodings the peer understands, most preferred first.
+	Codecs []string `json:",omitempty"`
+	// Time is the clock of the peer when it sent the info, it is used to measure the offset between the clocks.
+	Time time.Time `json:",omitempty"`
+}
+
+func localPeerInfo(commands []string) *PeerInfo {
+	return &PeerInfo{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		BuildVersion:       buildVersion(),
+		Commands:           commands,
+		Codecs:             codecNames(),
+		Time:               time.Now(),
+	}
+}
+
+func buildVersion() string {
+	if BuildVersion != "" {
+		return BuildVersion
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return setting.Value[:12]
+		}
+	}
+
+	return "devel"
+}
+
+// Supports returns whether the peer serves the command with the given key.
+// A nil PeerInfo belongs to a peer that did not tell, it is assumed to support everything.
+func (p *PeerInfo) Supports(key string) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, cmd := range p.Commands {
+		if cmd == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkCompatible returns an error wrapping ErrIncompatiblePeer if the protocol versions have no overlap.
+func (p *PeerInfo) checkCompatible() error {
+	if p.ProtocolVersion < MinProtocolVersion {
+		return fmt.Errorf("%w: peer (build %s) speaks protocol version %d, this build requires at least version %d, update the peer",
+			ErrIncompatiblePeer, p.BuildVersion, p.ProtocolVersion, MinProtocolVersion)
+	}
+
+	if p.MinProtocolVersion > ProtocolVersion {
+		return fmt.Errorf("%w: peer (build %s) requires protocol version %d, this build (%s) speaks version %d, update this installation",
+			ErrIncompatiblePeer, p.BuildVersion, p.MinProtocolVersion, buildVersion(), ProtocolVersion)
+	}
+
+	return nil
+}
+
+// openHandshake sends the local peer info on the first stream of a dialed connection and reads the answer.
+func (conn *RpcConnection) openHandshake(ctx context.Context, commands []string) error {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stream, err := conn.connection.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("error opening handshake stream: %w", err)
+	}
+
+	stream.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	session := newRpcSession(ctx, stream, conn)
+	defer session.Close()
+
+	err = session.mutateState(RpcSessionCreated, RpcSessionOpen)
+	if err != nil {
+		return fmt.Errorf("error mutating state: %w", err)
+	}
+
+	sent := time.Now()
+
+	err = WriteMessage[*PeerInfo](session, localPeerInfo(commands))
+	if err != nil {
+		return fmt.Errorf("error sending peer info: %w", err)
+	}
+
+	peer := &PeerInfo{}
+	err = ReadMessage[*PeerInfo](session, peer)
+	if err != nil {
+		return fmt.Errorf("error reading peer info: %w", err)
+	}
+
+	received := time.Now()
+
+	err = peer.checkCompatible()
+	if err != nil {
+		return err
+	}
+
+	if !peer.Time.IsZero() {
+		conn.setClockOffset(measureClockOffset(peer.Time, sent, received))
+	}
+
+	conn.setPeer(peer)
+
+	return nil
+}
+
+// acceptHandshake answers the handshake of a peer that dialed in.
+// Incompatible peers still get the local peer info, so they can explain the problem to their user.
+func (conn *RpcConnection) acceptHandshake(commands []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	stream, err := conn.connection.AcceptStream(ctx)
+	if err != nil {
+		return fmt.Errorf("error accepting handshake stream: %w", err)
+	}
+
+	stream.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	session := newRpcSession(ctx, stream, conn)
+	defer session.Close()
+
+	err = session.mutateState(RpcSessionCreated, RpcSessionOpen)
+	if err != nil {
+		return fmt.Errorf("error mutating state: %w", err)
+	}
+
+	peer := &PeerInfo{}
+	err = ReadMessage[*PeerInfo](session, peer)
+	if err != nil {
+		return fmt.Errorf("error reading peer info: %w", err)
+	}
+
+	received := time.Now()
+
+	err = WriteMessage[*PeerInfo](session, localPeerInfo(commands))
+	if err != nil {
+		return fmt.Errorf("error sending peer info: %w", err)
+	}
+
+	err = peer.checkCompatible()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("peer %s runs build %s with protocol version %d", conn.partner.GetName(), peer.BuildVersion, peer.ProtocolVersion)
+
+	if !peer.Time.IsZero() {
+		// one way only, the latency adds to the offset
+		conn.setClockOffset(measureClockOffset(peer.Time, received, received))
+	}
+
+	conn.setPeer(peer)
+
+	return nil
+}
+
+func (conn *RpcConnection) setPeer(peer *PeerInfo) {
+	sort.Strings(peer.Commands)
+
+	codec := negotiateCodec(peer.Codecs)
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.peer = peer
+	conn.codec = codec
+}
@@ -0,0 +1,201 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rahn-it/svalin/util"
+)
+
+// Limits bound what a single peer can use of a server, zero disables a limit.
+// Peers are counted by certificate across all their connections, and by IP address.
+type Limits struct {
+	// MaxIncomingStreams is how many streams a connection may have open at once.
+	MaxIncomingStreams int64
+	// MaxSessionsPerPeer and MaxSessionsPerIP limit the concurrent sessions.
+	// Login and enrollment connections count as sessions of their IP address.
+	MaxSessionsPerPeer int
+	MaxSessionsPerIP   int
+	// SessionRate is how many sessions a peer may open per second, SessionBurst how many at once.
+	SessionRate  int
+	SessionBurst int
+	// Bandwidth is how many bytes per second the sessions of a peer may read and write together.
+	Bandwidth int
+}
+
+type peerUsage struct {
+	sessions  int
+	rate      *util.RateLimiter
+	bandwidth *util.RateLimiter
+}
+
+// peerLimiter enforces the limits for the sessions of a server.
+type peerLimiter struct {
+	limits Limits
+	mutex  sync.Mutex
+	peers  map[string]*peerUsage
+}
+
+func newPeerLimiter(limits Limits) *peerLimiter {
+	return &peerLimiter{
+		limits: limits,
+		peers:  make(map[string]*peerUsage),
+	}
+}
+
+func (l *peerLimiter) usage(key string) *peerUsage {
+	usage, ok := l.peers[key]
+	if !ok {
+		usage = &peerUsage{}
+		if l.limits.SessionRate > 0 {
+			burst := l.limits.SessionBurst
+			if burst < 1 {
+				burst = l.limits.SessionRate
+			}
+			usage.rate = util.NewRateLimiter(float64(l.limits.SessionRate), burst)
+		}
+		if l.limits.Bandwidth > 0 {
+			usage.bandwidth = util.NewRateLimiter(float64(l.limits.Bandwidth), l.limits.Bandwidth)
+		}
+		l.peers[key] = usage
+	}
+	return usage
+}
+
+func rateLimited(limit string) error {
+	return WithDetail(fmt.Errorf("%w: too many sessions", ErrRateLimited), "limit", limit)
+}
+
+// admit counts a new session of the partner, release has to be called once it ended.
+// Sessions are counted for the certificate of the connection, which the TLS handshake proved,
+// as the key the session announces is not verified yet when it is admitted.
+// Sessions beyond a limit are refused with ErrRateLimited.
+func (l *peerLimiter) admit(session *RpcSession) (release func(), err error) {
+	key := session.partnerKey
+	if partner := session.connection.partner; partner != nil {
+		key = partner.PublicKey()
+	}
+
+	peer, release, err := l.acquire("cert:"+key.Base64Encode(), session.RemoteAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	if peer.bandwidth != nil {
+		session.stream = &limitedStream{
+			ReadWriteCloser: session.stream,
+			limiter:         peer.bandwidth,
+			ctx:             session.Context,
+		}
+	}
+
+	return release, nil
+}
+
+// admitAddr counts a connection without certificate, like a login or an enrollment, as a session of its address.
+func (l *peerLimiter) admitAddr(addr net.Addr) (release func(), err error) {
+	_, release, err = l.acquire("", addr)
+	return release, err
+}
+
+// acquire counts a session for the peer and the address, peerKey is empty for peers without certificate.
+func (l *peerLimiter) acquire(peerKey string, addr net.Addr) (*peerUsage, func(), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var peer *peerUsage
+	if peerKey != "" {
+		peer = l.usage(peerKey)
+	}
+	ip := l.usage("ip:" + remoteIP(addr))
+
+	if peer != nil && l.limits.MaxSessionsPerPeer > 0 && peer.sessions >= l.limits.MaxSessionsPerPeer {
+		return nil, nil, rateLimited("sessions-per-peer")
+	}
+
+	if l.limits.MaxSessionsPerIP > 0 && ip.sessions >= l.limits.MaxSessionsPerIP {
+		return nil, nil, rateLimited("sessions-per-ip")
+	}
+
+	if peer != nil && peer.rate != nil && !peer.rate.Allow() {
+		return nil, nil, rateLimited("session-rate")
+	}
+
+	if ip.rate != nil && !ip.rate.Allow() {
+		return nil, nil, rateLimited("session-rate")
+	}
+
+	if peer != nil {
+		peer.sessions++
+	}
+	ip.sessions++
+
+	return peer, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if peer != nil {
+			peer.sessions--
+		}
+		ip.sessions--
+	}, nil
+}
+
+// cleanup forgets peers without sessions whose buckets refilled.
+func (l *peerLimiter) cleanup() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, usage := range l.peers {
+		if usage.sessions > 0 {
+			continue
+		}
+		if usage.rate != nil && !usage.rate.Idle() {
+			continue
+		}
+		if usage.bandwidth != nil && !usage.bandwidth.Idle() {
+			continue
+		}
+		delete(l.peers, key)
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// limitedStream throttles a session to the bandwidth of its peer.
+type limitedStream struct {
+	io.ReadWriteCloser
+	limiter *util.RateLimiter
+	ctx     func() context.Context
+}
+
+func (l *limitedStream) Read(p []byte) (int, error) {
+	n, err := l.ReadWriteCloser.Read(p)
+	if n > 0 {
+		waitErr := l.limiter.Wait(l.ctx(), n)
+		if err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (l *limitedStream) Write(p []byte) (int, error) {
+	err := l.limiter.Wait(l.ctx(), len(p))
+	if err != nil {
+		return 0, err
+	}
+	return l.ReadWriteCloser.Write(p)
+}
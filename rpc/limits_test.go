@@ -0,0 +1,141 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// holdCommand keeps its session open on both sides until release is closed or the session ends.
+type holdCommand struct {
+	release chan struct{}
+}
+
+func (c *holdCommand) GetKey() string {
+	return "hold"
+}
+
+func (c *holdCommand) ExecuteServer(session *rpc.RpcSession) error {
+	err := session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.release:
+	case <-session.Context().Done():
+	}
+	return nil
+}
+
+func (c *holdCommand) ExecuteClient(session *rpc.RpcSession) error {
+	select {
+	case <-c.release:
+	case <-session.Context().Done():
+	}
+	return nil
+}
+
+func startLimitedServer(t *testing.T, limits rpc.Limits) (*testServer, chan struct{}) {
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+
+	commands := rpc.NewCommandCollection(
+		echoCommandHandler(nil),
+		func() rpc.RpcCommand {
+			return &holdCommand{release: release}
+		},
+	)
+
+	return startTestServer(t, commands, limits), release
+}
+
+func hold(t *testing.T, ep *rpc.RpcEndpoint, release chan struct{}) {
+	_, err := ep.SendCommand(context.Background(), &holdCommand{release: release})
+	if err != nil {
+		t.Fatalf("error holding session: %v", err)
+	}
+}
+
+func expectLimited(t *testing.T, ep *rpc.RpcEndpoint, limit string) {
+	err := ep.SendSyncCommand(context.Background(), newEchoCommand("limited"))
+	if !errors.Is(err, rpc.ErrRateLimited) {
+		t.Fatalf("expected the session to be rate limited, got %v", err)
+	}
+
+	sessionErr := &rpc.SessionError{}
+	if !errors.As(err, &sessionErr) || sessionErr.Details()["limit"] != limit {
+		t.Errorf("expected limit %s, got %v", limit, err)
+	}
+}
+
+// expectReleased retries until the server counted the end of the held sessions.
+func expectReleased(t *testing.T, ep *rpc.RpcEndpoint) {
+	var err error
+	for i := 0; i < 50; i++ {
+		err = ep.SendSyncCommand(context.Background(), newEchoCommand("released"))
+		if err == nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Errorf("sessions were not released: %v", err)
+}
+
+func TestLimitSessionsPerPeer(t *testing.T) {
+	server, release := startLimitedServer(t, rpc.Limits{MaxSessionsPerPeer: 1})
+	client, _ := server.connect(t, "client", nil)
+	other, _ := server.connect(t, "other", nil)
+
+	hold(t, client, release)
+	expectLimited(t, client, "sessions-per-peer")
+
+	err := other.SendSyncCommand(context.Background(), newEchoCommand("other peer"))
+	if err != nil {
+		t.Errorf("session of another peer was refused: %v", err)
+	}
+
+	close(release)
+	expectReleased(t, client)
+}
+
+func TestLimitSessionsPerIP(t *testing.T) {
+	server, release := startLimitedServer(t, rpc.Limits{MaxSessionsPerIP: 1})
+	client, _ := server.connect(t, "client", nil)
+	other, _ := server.connect(t, "other", nil)
+
+	hold(t, client, release)
+	expectLimited(t, other, "sessions-per-ip")
+
+	close(release)
+	expectReleased(t, other)
+}
+
+func TestLimitReleasedOnClose(t *testing.T) {
+	server, release := startLimitedServer(t, rpc.Limits{MaxSessionsPerIP: 1})
+	holder, _ := server.connect(t, "holder", nil)
+	other, _ := server.connect(t, "other", nil)
+
+	hold(t, holder, release)
+	expectLimited(t, other, "sessions-per-ip")
+
+	err := holder.Close(0, "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectReleased(t, other)
+}
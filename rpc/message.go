@@ -9,6 +9,13 @@ import (
 
 const messageExpiration = 30
 
+// nonceRetention covers the accepted age of a message and the skew between the clocks,
+// the compensation may differ between two connections of the same peer.
+const nonceRetention = messageExpiration*time.Second + clockSkewTolerance + 2*maxClockOffset
+
+// nonces is shared by all connections of the process, so a message can't be replayed on another connection either.
+var nonces = util.NewNonceStorage(nonceRetention)
+
 type RpcMessage[P any] struct {
 	Timestamp int64
 	Receiver  *pki.PublicKey
@@ -30,9 +37,9 @@ func newRpcMessage[P any](receiver *pki.PublicKey, payload P) (*RpcMessage[P], e
 	}, nil
 }
 
-func (m *RpcMessage[P]) Verify(store *util.NonceStorage, receiver *pki.PublicKey) error {
+func (m *RpcMessage[P]) Verify(store *util.NonceStorage, receiver *pki.PublicKey, offset time.Duration, tolerance time.Duration) error {
 
-	if err := m.VerifyTimestamp(); err != nil {
+	if err := m.VerifyTimestamp(offset, tolerance); err != nil {
 		return err
 	}
 
@@ -47,19 +54,27 @@ func (m *RpcMessage[P]) Verify(store *util.NonceStorage, receiver *pki.PublicKey
 	return nil
 }
 
-func (m *RpcMessage[P]) VerifyTimestamp() error {
-	diff := time.Now().Unix() - m.Timestamp
-	if diff > messageExpiration {
-		return fmt.Errorf("message expired, singed at %d, now is %d, off by %d seconds", m.Timestamp, time.Now().Unix(), messageExpiration)
+// VerifyTimestamp checks the age of the message against our clock moved by the offset of the sender's clock.
+// tolerance widens the window in both directions, e.g. while the offset is unknown.
+func (m *RpcMessage[P]) VerifyTimestamp(offset time.Duration, tolerance time.Duration) error {
+	now := time.Now().Add(offset)
+	age := now.Sub(time.Unix(m.Timestamp, 0))
+
+	if age > messageExpiration*time.Second+tolerance {
+		return fmt.Errorf("message expired, signed at %d, now is %d with an offset of %s", m.Timestamp, time.Now().Unix(), offset)
 	}
+
+	if age < -(clockSkewTolerance + tolerance) {
+		return fmt.Errorf("message signed in the future at %d, now is %d with an offset of %s", m.Timestamp, time.Now().Unix(), offset)
+	}
+
 	return nil
 }
 
 func (m *RpcMessage[P]) VerifyNonce(store *util.NonceStorage) error {
-	if !store.CheckNonce(m.Nonce) {
+	if !store.Use(m.Nonce) {
 		return fmt.Errorf("nonce has already been used, possible replay attack")
 	}
-	store.AddNonce(m.Nonce)
 	return nil
 }
 
@@ -0,0 +1,44 @@
+package rpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/rpc"
+)
+
+func TestVerifyTimestamp(t *testing.T) {
+	at := func(offset time.Duration) *rpc.RpcMessage[struct{}] {
+		return &rpc.RpcMessage[struct{}]{
+			Timestamp: time.Now().Add(offset).Unix(),
+		}
+	}
+
+	cases := []struct {
+		name      string
+		signed    time.Duration
+		offset    time.Duration
+		tolerance time.Duration
+		valid     bool
+	}{
+		{"current", 0, 0, 0, true},
+		{"recent", -20 * time.Second, 0, 0, true},
+		{"expired", -time.Minute, 0, 0, false},
+		{"future", time.Minute, 0, 0, false},
+		{"slightly ahead", 2 * time.Second, 0, 0, true},
+		{"compensated ahead", time.Minute, time.Minute, 0, true},
+		{"compensated behind", -time.Minute, -time.Minute, 0, true},
+		{"compensated replay", -time.Minute, time.Minute, 0, false},
+		{"tolerated", time.Minute, 0, 2 * time.Minute, true},
+	}
+
+	for _, c := range cases {
+		err := at(c.signed).VerifyTimestamp(c.offset, c.tolerance)
+		if c.valid && err != nil {
+			t.Errorf("%s: valid timestamp was rejected: %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: invalid timestamp was accepted", c.name)
+		}
+	}
+}
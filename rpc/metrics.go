@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// CommandStats counts the sessions of one command.
+type CommandStats struct {
+	Calls uint64
+	// Errors are sessions that failed or were answered with an error code.
+	Errors uint64
+	// Panics are sessions whose handler panicked, they are also counted as errors.
+	Panics   uint64
+	Duration time.Duration
+}
+
+// CommandMetrics counts the incoming sessions of a collection by command.
+// Sessions for unknown commands are counted with an empty key.
+type CommandMetrics struct {
+	mutex    sync.Mutex
+	commands map[string]*CommandStats
+}
+
+func newCommandMetrics() *CommandMetrics {
+	return &CommandMetrics{
+		commands: make(map[string]*CommandStats),
+	}
+}
+
+func (m *CommandMetrics) record(key string, failed bool, panicked bool, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats, ok := m.commands[key]
+	if !ok {
+		stats = &CommandStats{}
+		m.commands[key] = stats
+	}
+
+	stats.Calls++
+	if failed || panicked {
+		stats.Errors++
+	}
+	if panicked {
+		stats.Panics++
+	}
+	stats.Duration += duration
+}
+
+// Stats returns a copy of the counters by command.
+func (m *CommandMetrics) Stats() map[string]CommandStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := make(map[string]CommandStats, len(m.commands))
+	for key, s := range m.commands {
+		stats[key] = *s
+	}
+
+	return stats
+}
+
+// Panics returns how many handlers panicked in total.
+func (m *CommandMetrics) Panics() uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var panics uint64
+	for _, s := range m.commands {
+		panics += s.Panics
+	}
+
+	return panics
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"sync"
 	"time"
 
@@ -35,13 +36,19 @@ type RpcServer struct {
 	state             RpcServerState
 	activeConnections util.UpdateableMap[uuid.UUID, *RpcConnection]
 	mutex             sync.Mutex
-	nonceStorage      *util.NonceStorage
 	credentials       *pki.PermanentCredentials
 	enrollment        *enrollmentManager
 	verifier          pki.Verifier
 	loginHandler      func(session *RpcSession) error
+	limiter           *peerLimiter
+	// done is closed once the server is closed, it stops the periodic cleanup.
+	done chan struct{}
+	// cleanupStopped is closed once the periodic cleanup returned.
+	cleanupStopped chan struct{}
 }
 
+const cleanupInterval = 30 * time.Second
+
 type RpcServerState int16
 
 const (
@@ -50,14 +57,15 @@ const (
 	RpcServerStopped
 )
 
-func NewRpcServer(listenAddr string, rpcCommands *CommandCollection, verifier pki.Verifier, credentials *pki.PermanentCredentials, root *pki.Certificate) (*RpcServer, error) {
-	tlsConf, err := getTlsServerConfig([]TlsConnectionProto{ProtoRpc, ProtoClientLogin, ProtoAgentEnroll})
+func NewRpcServer(listenAddr string, rpcCommands *CommandCollection, verifier pki.Verifier, credentials *pki.PermanentCredentials, root *pki.Certificate, limits Limits) (*RpcServer, error) {
+	tlsConf, err := getTlsServerConfig([]TlsConnectionProto{ProtoRpc, ProtoRpcLegacy, ProtoClientLogin, ProtoAgentEnroll, ProtoAgentEnrollLegacy})
 	if err != nil {
 		return nil, fmt.Errorf("error getting server tls config: %w", err)
 	}
 
 	quicConf := &quic.Config{
-		KeepAlivePeriod: 30 * time.Second,
+		KeepAlivePeriod:    30 * time.Second,
+		MaxIncomingStreams: limits.MaxIncomingStreams,
 	}
 	listener, err := quic.ListenAddr(listenAddr, tlsConf, quicConf)
 	if err != nil {
@@ -70,10 +78,12 @@ func NewRpcServer(listenAddr string, rpcCommands *CommandCollection, verifier pk
 		state:             RpcServerCreated,
 		activeConnections: util.NewObservableMap[uuid.UUID, *RpcConnection](),
 		mutex:             sync.Mutex{},
-		nonceStorage:      util.NewNonceStorage(),
 		credentials:       credentials,
 		enrollment:        newEnrollmentManager(credentials.Certificate(), root),
 		verifier:          verifier,
+		limiter:           newPeerLimiter(limits),
+		done:              make(chan struct{}),
+		cleanupStopped:    make(chan struct{}),
 	}, nil
 }
 
@@ -129,6 +139,14 @@ func (s *RpcServer) accept() (*RpcConnection, error) {
 			return nil, fmt.Errorf("peer presented wrong certificate type: %s", certType)
 		}
 
+	case ProtoRpcLegacy:
+		conn.CloseWithError(426, fmt.Sprintf("protocol version not supported, this server requires at least version %d, please update", MinProtocolVersion))
+		return nil, fmt.Errorf("%w: peer does not support protocol version negotiation", ErrIncompatiblePeer)
+
+	case ProtoAgentEnrollLegacy:
+		conn.CloseWithError(426, "enrollment protocol not supported, please update the agent")
+		return nil, fmt.Errorf("%w: agent does not send an enrollment request", ErrIncompatiblePeer)
+
 	case ProtoClientLogin, ProtoAgentEnroll:
 
 		if peerCert != nil {
@@ -142,27 +160,42 @@ func (s *RpcServer) accept() (*RpcConnection, error) {
 
 	}
 
-	var connection *RpcConnection
+	s.mutex.Lock()
+	credentials := s.credentials
+	s.mutex.Unlock()
+
+	return newRpcConnection(conn, s, RpcRoleServer, nonces, peerCert, protocol, credentials, s.verifier), nil
+}
+
+// UpdateCredentials replaces the credentials of the server and the root handed out to enrolling agents.
+// Connections opened afterwards use them, open connections keep the previous ones.
+func (s *RpcServer) UpdateCredentials(credentials *pki.PermanentCredentials, root *pki.Certificate) {
+	s.mutex.Lock()
+	s.credentials = credentials
+	s.mutex.Unlock()
+
+	s.enrollment.updateRoot(credentials.Certificate(), root)
+}
 
+// addConnection makes the connection visible, RPC connections are only added after the handshake.
+func (s *RpcServer) addConnection(connection *RpcConnection) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	for i := 0; i < 10; i++ {
-		newConnection := newRpcConnection(conn, s, RpcRoleServer, s.nonceStorage, peerCert, protocol, s.credentials, s.verifier)
-		if _, ok := s.activeConnections.Get(newConnection.uuid); !ok {
-			connection = newConnection
-			break
+		if _, ok := s.activeConnections.Get(connection.uuid); !ok {
+			s.activeConnections.Set(connection.uuid, connection)
+			return nil
 		}
+		connection.uuid = uuid.New()
 	}
 
-	if connection == nil {
-		conn.CloseWithError(400, "")
-		return nil, fmt.Errorf("multiple uuid collisions, this should mathematically be impossible")
-	}
-
-	s.activeConnections.Set(connection.uuid, connection)
+	return fmt.Errorf("multiple uuid collisions, this should mathematically be impossible")
+}
 
-	return connection, nil
+// Nonces returns the storage of nonces seen in signed messages, it is shared by all connections of the process.
+func (s *RpcServer) Nonces() *util.NonceStorage {
+	return nonces
 }
 
 func (s *RpcServer) Connections() util.ObservableMap[uuid.UUID, *RpcConnection] {
@@ -189,12 +222,7 @@ func (s *RpcServer) Run() error {
 	s.state = RpcServerRunning
 	s.mutex.Unlock()
 
-	go func() {
-		for s.state == RpcServerRunning {
-			s.cleanup()
-			time.Sleep(30 * time.Second)
-		}
-	}()
+	go s.cleanupLoop()
 
 	for {
 		conn, err := s.accept()
@@ -211,9 +239,52 @@ func (s *RpcServer) Run() error {
 			continue
 		}
 
+		if conn.protocol != ProtoRpc {
+			// logins and enrollments have no certificate yet, they are limited by their address
+			release, err := s.limiter.admitAddr(conn.connection.RemoteAddr())
+			if err != nil {
+				log.Printf("connection from %s refused: %v", conn.connection.RemoteAddr(), err)
+				conn.Close(429, "too many connections")
+				continue
+			}
+			go func() {
+				<-conn.connection.Context().Done()
+				release()
+			}()
+
+			err = s.addConnection(conn)
+			if err != nil {
+				log.Printf("error adding connection: %v", err)
+				conn.Close(400, "")
+				continue
+			}
+		}
+
 		switch conn.protocol {
 		case ProtoRpc:
-			go conn.serveRpc(s.rpcCommands)
+			go func() {
+				defer s.recoverConnection(conn)
+
+				err := conn.acceptHandshake(s.rpcCommands.Keys())
+				if err != nil {
+					log.Printf("error during handshake with %s: %v", conn.partner.GetName(), err)
+					msg := "handshake failed"
+					if errors.Is(err, ErrIncompatiblePeer) {
+						msg = err.Error()
+					}
+					conn.Close(426, msg)
+					return
+				}
+
+				err = s.addConnection(conn)
+				if err != nil {
+					log.Printf("error adding connection: %v", err)
+					conn.Close(400, "")
+					return
+				}
+
+				conn.serveRpc(s.rpcCommands)
+			}()
 
 		case ProtoClientLogin:
 			if s.loginHandler == nil {
@@ -222,6 +293,8 @@ func (s *RpcServer) Run() error {
 				continue
 			}
 			go func() {
+				defer s.recoverConnection(conn)
+
 				err := s.acceptLoginRequest(conn)
 				if err != nil {
 					log.Printf("error accepting login request: %v", err)
@@ -230,6 +303,8 @@ func (s *RpcServer) Run() error {
 
 		case ProtoAgentEnroll:
 			go func() {
+				defer s.recoverConnection(conn)
+
 				err := s.enrollment.startEnrollment(conn)
 				if err != nil {
 					log.Printf("error accepting agent enroll request: %v", err)
@@ -247,6 +322,19 @@ func (s *RpcServer) Run() error {
 	}
 }
 
+// recoverConnection is deferred by the goroutines serving a connection.
+// A panic outside of a session closes the connection and is counted with the panics of the sessions, the server keeps running.
+func (s *RpcServer) recoverConnection(conn *RpcConnection) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	logPanic("connection", conn, r)
+	s.rpcCommands.metrics.record("", true, true, 0)
+	conn.Close(500, "internal error")
+}
+
 func (s *RpcServer) Close(code quic.ApplicationErrorCode, msg string) error {
 
 	// lock server before closing
@@ -256,6 +344,7 @@ func (s *RpcServer) Close(code quic.ApplicationErrorCode, msg string) error {
 		return fmt.Errorf("RPC server not running")
 	}
 	s.state = RpcServerStopped
+	close(s.done)
 	s.mutex.Unlock()
 
 	// tell all connections to close
@@ -292,12 +381,33 @@ func (s *RpcServer) Close(code quic.ApplicationErrorCode, msg string) error {
 
 	s.listener.Close()
 
+	<-s.cleanupStopped
+
 	return err
 }
 
+// cleanupLoop cleans up every cleanupInterval until the server is closed.
+// Close waits for it, so the enrollments and the limiter are left alone once the server closed.
+func (s *RpcServer) cleanupLoop() {
+	defer close(s.cleanupStopped)
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		s.cleanup()
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (s *RpcServer) cleanup() {
 	s.enrollment.cleanup()
-	s.nonceStorage.Cleanup(messageExpiration * 2)
+	s.limiter.cleanup()
 }
 
 func (s *RpcServer) getConnectionWith(partner *pki.Certificate) (*RpcConnection, error) {
@@ -308,7 +418,7 @@ func (s *RpcServer) getConnectionWith(partner *pki.Certificate) (*RpcConnection,
 		if connection.partner == nil {
 			return nil
 		}
-		if connection.partner.Equal(partner) {
+		if connection.partner.PublicKey().Equal(partner.PublicKey()) {
 			conn = connection
 			return errors.New("found connection")
 		}
@@ -323,6 +433,57 @@ func (s *RpcServer) getConnectionWith(partner *pki.Certificate) (*RpcConnection,
 
 }
 
+func (s *RpcServer) SendCommandTo(ctx context.Context, to *pki.Certificate, cmd RpcCommand) (util.AsyncAction, error) {
+	conn, err := s.getConnectionWith(to)
+	if err != nil {
+		return nil, fmt.Errorf("error getting connection: %w", err)
+	}
+
+	session, err := conn.OpenSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error opening session: %w", err)
+	}
+
+	running, err := sendIntercepted(s.rpcCommands.outgoing, session, cmd, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error sending command: %w", err)
+	}
+
+	return running, nil
+}
+
+func (s *RpcServer) SendSyncCommandTo(ctx context.Context, to *pki.Certificate, cmd RpcCommand) error {
+	running, err := s.SendCommandTo(ctx, to, cmd)
+	if err != nil {
+		return err
+	}
+
+	err = running.Wait()
+	if err != nil {
+		return fmt.Errorf("error executing command: %w", err)
+	}
+
+	return nil
+}
+
+// EnrollmentTokenHandler sets the function validating tokens presented by enrolling agents.
+// It returns the preset for the enrollment and how long the agent may wait for its certificate.
+func (s *RpcServer) EnrollmentTokenHandler(handler func(token []byte) (*EnrollmentPreset, time.Time, error)) {
+	s.enrollment.tokenHandler = handler
+}
+
+// AutoEnrollment sets the function that issues the certificate for enrollments that presented a token.
+// It runs in its own goroutine once the enrollment is waiting, enrollments it does not accept stay waiting for a user.
+func (s *RpcServer) AutoEnrollment(handler func(enrollment *Enrollment)) {
+	s.enrollment.autoEnroll = handler
+}
+
+// EnrollmentFilter sets a check that runs before an agent may start enrolling.
+// Enrollments are refused if it returns an error.
+func (s *RpcServer) EnrollmentFilter(filter func(key *pki.PublicKey, addr net.Addr) error) {
+	s.enrollment.filter = filter
+}
+
 func (s *RpcServer) LoginHandler(handler func(*RpcSession) error) {
 	s.loginHandler = handler
 }
@@ -6,7 +6,6 @@ import (
 	"log"
 
 	"github.com/rahn-it/svalin/pki"
-	"github.com/rahn-it/svalin/util"
 
 	"github.com/quic-go/quic-go"
 )
@@ -29,8 +28,6 @@ func WaitForServerSetup(listenAddr string) (*pki.PermanentCredentials, *pki.Cert
 		return nil, nil, fmt.Errorf("error creating QUIC server: %w", err)
 	}
 
-	initNonceStorage = util.NewNonceStorage()
-
 	for {
 		conn, err := listener.Accept(context.Background())
 		if err != nil {
@@ -56,8 +53,6 @@ func WaitForServerSetup(listenAddr string) (*pki.PermanentCredentials, *pki.Cert
 	}
 }
 
-var initNonceStorage *util.NonceStorage = nil
-
 type serverInitRequest struct {
 	ServerPubKey *pki.PublicKey
 }
@@ -68,7 +63,7 @@ type serverInitResponse struct {
 }
 
 func acceptServerInitialization(quicConn quic.Connection, credentials *pki.TempCredentials) (*pki.PermanentCredentials, *pki.Certificate, error) {
-	conn := newRpcConnection(quicConn, nil, RpcRoleInit, initNonceStorage, nil, ProtoServerInit, credentials, pki.NewNilVerifier())
+	conn := newRpcConnection(quicConn, nil, RpcRoleInit, nonces, nil, ProtoServerInit, credentials, pki.NewNilVerifier())
 
 	log.Printf("Opening init QUIC stream...")
 
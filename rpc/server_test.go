@@ -0,0 +1,230 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// testVerifier trusts the certificates it was given.
+type testVerifier struct {
+	mutex sync.Mutex
+	certs []*pki.Certificate
+}
+
+func (v *testVerifier) trust(cert *pki.Certificate) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.certs = append(v.certs, cert)
+}
+
+func (v *testVerifier) Verify(cert *pki.Certificate) ([]*pki.Certificate, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, known := range v.certs {
+		if known.Equal(cert) {
+			return []*pki.Certificate{known}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown certificate")
+}
+
+func (v *testVerifier) VerifyPublicKey(pub *pki.PublicKey) ([]*pki.Certificate, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, known := range v.certs {
+		if known.PublicKey().Equal(pub) {
+			return []*pki.Certificate{known}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown public key")
+}
+
+type testServer struct {
+	*rpc.RpcServer
+	credentials *pki.PermanentCredentials
+	verifier    *testVerifier
+	done        chan error
+}
+
+// startTestServer runs a server for the commands on a local port until the test ends.
+func startTestServer(t *testing.T, commands *rpc.CommandCollection, limits rpc.Limits) *testServer {
+	credentials, err := pki.GenerateRootCredentials("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &testVerifier{
+		certs: []*pki.Certificate{credentials.Certificate()},
+	}
+
+	server, err := rpc.NewRpcServer("127.0.0.1:0", commands, verifier, credentials, credentials.Certificate(), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		RpcServer:   server,
+		credentials: credentials,
+		verifier:    verifier,
+		done:        make(chan error, 1),
+	}
+
+	go func() {
+		s.done <- server.Run()
+	}()
+
+	t.Cleanup(func() {
+		server.Close(0, "test done")
+
+		select {
+		case <-s.done:
+		case <-time.After(5 * time.Second):
+			t.Error("server did not stop")
+		}
+	})
+
+	return s
+}
+
+// connect opens an endpoint to the server for a new client, which serves commands if they are not nil.
+func (s *testServer) connect(t *testing.T, name string, commands *rpc.CommandCollection) (*rpc.RpcEndpoint, *pki.PermanentCredentials) {
+	credentials, err := pki.GenerateRootCredentials(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.verifier.trust(credentials.Certificate())
+
+	keys := []string{}
+	if commands != nil {
+		keys = commands.Keys()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ep, err := rpc.ConnectToServer(ctx, rpc.ServerAddr(s.RpcServer), credentials, s.credentials.Certificate(), s.verifier, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		ep.Close(0, "test done")
+	})
+
+	if commands != nil {
+		go ep.ServeRpc(commands)
+	}
+
+	// the server adds the connection after its part of the handshake
+	for i := 0; i < 100; i++ {
+		connected := false
+		s.Connections().ForEach(func(_ uuid.UUID, conn *rpc.RpcConnection) error {
+			if conn.Partner().PublicKey().Equal(credentials.PublicKey()) {
+				connected = true
+			}
+			return nil
+		})
+		if connected {
+			return ep, credentials
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("server did not add the connection of %s", name)
+	return nil, nil
+}
+
+type echoRequest struct {
+	Text string
+}
+
+type echoResponse struct {
+	Text string
+}
+
+func echoCommandHandler(handled func(text string)) rpc.RpcCommandHandler {
+	return rpc.UnaryCommandHandler("echo", func(session *rpc.RpcSession, request *echoRequest) (*echoResponse, error) {
+		if handled != nil {
+			handled(request.Text)
+		}
+		return &echoResponse{Text: request.Text}, nil
+	})
+}
+
+func newEchoCommand(text string) *rpc.UnaryCommand[echoRequest, echoResponse] {
+	return rpc.NewUnaryCommand[echoRequest, echoResponse]("echo", &echoRequest{Text: text})
+}
+
+func TestServerEcho(t *testing.T) {
+	server := startTestServer(t, rpc.NewCommandCollection(echoCommandHandler(nil)), rpc.Limits{})
+	client, _ := server.connect(t, "client", nil)
+
+	cmd := newEchoCommand("hello")
+	err := client.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Response().Text != "hello" {
+		t.Errorf("unexpected response: %s", cmd.Response().Text)
+	}
+}
+
+func TestServerRecoversPanic(t *testing.T) {
+	commands := rpc.NewCommandCollection(
+		echoCommandHandler(nil),
+		rpc.UnaryCommandHandler("panic", func(session *rpc.RpcSession, request *rpc.Empty) (*rpc.Empty, error) {
+			panic("test panic")
+		}),
+	)
+
+	server := startTestServer(t, commands, rpc.Limits{})
+	client, _ := server.connect(t, "client", nil)
+
+	err := client.SendSyncCommand(context.Background(), rpc.NewUnaryCommand[rpc.Empty, rpc.Empty]("panic", &rpc.Empty{}))
+
+	sessionErr := &rpc.SessionError{}
+	if !errors.As(err, &sessionErr) || sessionErr.Code() != 500 {
+		t.Fatalf("expected the session to close with 500, got %v", err)
+	}
+
+	if !errors.Is(err, rpc.ErrInternal) {
+		t.Errorf("expected an internal error, got %v", err)
+	}
+
+	stats := commands.Metrics().Stats()["panic"]
+	if stats.Panics != 1 || stats.Errors != 1 {
+		t.Errorf("panic was counted with %d panics and %d errors", stats.Panics, stats.Errors)
+	}
+
+	// the server and the connection keep running
+	err = client.SendSyncCommand(context.Background(), newEchoCommand("still running"))
+	if err != nil {
+		t.Errorf("error sending command after panic: %v", err)
+	}
+
+	other, _ := server.connect(t, "other", nil)
+	err = other.SendSyncCommand(context.Background(), newEchoCommand("new connection"))
+	if err != nil {
+		t.Errorf("error sending command on a new connection after panic: %v", err)
+	}
+
+	select {
+	case err := <-server.done:
+		t.Errorf("server stopped after panic: %v", err)
+	default:
+	}
+}
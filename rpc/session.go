@@ -2,13 +2,17 @@ package rpc
 
 import (
 	"context"
+	"crypto/ecdh"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rahn-it/svalin/pki"
 	"github.com/rahn-it/svalin/util"
@@ -16,9 +20,13 @@ import (
 	"github.com/quic-go/quic-go"
 )
 
+// SessionError is the error a partner responded with.
+// It unwraps to its kind, e.g. ErrNotFound, so it can be matched with errors.Is.
 type SessionError struct {
-	code int
-	msg  string
+	code    int
+	msg     string
+	kind    error
+	details map[string]string
 }
 
 func (e *SessionError) Error() string {
@@ -38,10 +46,23 @@ func (e *SessionError) As(target interface{}) bool {
 	return false
 }
 
+func (e *SessionError) Unwrap() error {
+	return e.kind
+}
+
 func (e *SessionError) Code() int {
 	return e.code
 }
 
+func (e *SessionError) Message() string {
+	return e.msg
+}
+
+// Details returns the structured details the partner sent along with the error.
+func (e *SessionError) Details() map[string]string {
+	return e.details
+}
+
 type RpcSessionState int16
 
 const (
@@ -52,8 +73,13 @@ const (
 )
 
 type RpcSession struct {
-	stream      io.ReadWriteCloser
+	stream     io.ReadWriteCloser
+	quicStream quic.Stream
+	// ctx ends with the session, see session_context.go.
 	ctx         context.Context
+	cancel      context.CancelFunc
+	timeout     time.Duration
+	abortCause  error
 	connection  *RpcConnection
 	id          quic.StreamID
 	state       RpcSessionState
@@ -61,9 +87,23 @@ type RpcSession struct {
 	partnerKey  *pki.PublicKey
 	partner     *pki.Certificate
 	credentials pki.Credentials
-}
-
-func newRpcSession(stream quic.Stream, conn *RpcConnection) *RpcSession {
+	// responseCode is the code of the last response header that was written.
+	responseCode int
+	// codec encodes the messages, the side opening the session picks it.
+	codec Codec
+	// kexKey and peerKex are the ephemeral keys of the headers, see session_mac.go.
+	kexKey  *ecdh.PrivateKey
+	peerKex []byte
+	sendMac *util.MessageAuthenticator
+	recvMac *util.MessageAuthenticator
+	// writeMutex keeps authenticated messages in order.
+	writeMutex sync.Mutex
+	// farClockOffset is how far the clock of the far peer of a forwarded session is ahead, see clock.go.
+	farClockOffset   time.Duration
+	farClockMeasured bool
+}
+
+func newRpcSession(ctx context.Context, stream quic.Stream, conn *RpcConnection) *RpcSession {
 
 	var pubkey *pki.PublicKey = nil
 
@@ -73,22 +113,32 @@ func newRpcSession(stream quic.Stream, conn *RpcConnection) *RpcSession {
 
 	return &RpcSession{
 		stream:      wrapQuicStream(stream),
-		ctx:         stream.Context(),
+		quicStream:  stream,
+		ctx:         ctx,
 		connection:  conn,
 		id:          stream.StreamID(),
 		state:       RpcSessionCreated,
 		mutex:       sync.Mutex{},
 		partnerKey:  pubkey,
 		credentials: conn.credentials,
+		codec:       conn.Codec(),
 	}
 
 }
 
-func (s *RpcSession) handleIncoming(commands *CommandCollection) error {
+func (s *RpcSession) handleIncoming(commands *CommandCollection) (err error) {
 	defer s.Close()
 	log.Printf("handling incoming session...")
 
-	err := s.mutateState(RpcSessionCreated, RpcSessionOpen)
+	// panics before the command is known, later ones are recovered by the observe interceptor
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.recoverPanic(r)
+			commands.metrics.record("", true, true, 0)
+		}
+	}()
+
+	err = s.mutateState(RpcSessionCreated, RpcSessionOpen)
 	if err != nil {
 		return fmt.Errorf("error ensuring state: %w", err)
 	}
@@ -100,13 +150,21 @@ func (s *RpcSession) handleIncoming(commands *CommandCollection) error {
 
 	s.mutateState(RpcSessionOpen, RpcSessionCreated)
 
+	// limits are checked before any signature, so refused sessions cost as little as possible
+	if s.connection.server != nil {
+		release, err := s.connection.server.limiter.admit(s)
+		if err != nil {
+			s.mutateState(RpcSessionCreated, RpcSessionRequested)
+			s.WriteError(err)
+			return fmt.Errorf("session of %s refused: %w", s.connection.partnerName(), err)
+		}
+		defer release()
+	}
+
 	chain, err := s.Verifier().VerifyPublicKey(s.partnerKey)
 	if err != nil {
 		s.mutateState(RpcSessionCreated, RpcSessionRequested)
-		s.WriteResponseHeader(SessionResponseHeader{
-			Code: 401,
-			Msg:  "error verifying public key",
-		})
+		s.WriteError(fmt.Errorf("%w: error verifying public key", ErrPermissionDenied))
 		return fmt.Errorf("error verifying public key: %w", err)
 	}
 
@@ -115,47 +173,45 @@ func (s *RpcSession) handleIncoming(commands *CommandCollection) error {
 	header, err := s.readRequestHeader()
 
 	if err != nil {
-		s.WriteResponseHeader(SessionResponseHeader{
-			Code: 500,
-			Msg:  "error reading request header",
-		})
+		s.WriteError(fmt.Errorf("error reading request header: %w", err))
 		return fmt.Errorf("error reading request header: %w", err)
 	}
 
 	log.Printf("Header: %+v", header)
 
-	// TODO: Check if the command is allowed (permissions)
+	s.setTimeout(header.Timeout)
+	s.watchContext()
+
+	// sessions refused before the interceptors run are counted and recorded here
+	start := time.Now()
 
 	handler, ok := commands.Get(header.Cmd)
 	if !ok {
-		s.WriteResponseHeader(SessionResponseHeader{
-			Code: 405,
-			Msg:  "command not found",
-		})
+		s.WriteError(WithDetail(fmt.Errorf("%w: unknown command", ErrNotFound), "command", header.Cmd))
+		commands.recordSession(s, "", header.Cmd, nil, true, false, start)
 		return fmt.Errorf("unknown command: %s", header.Cmd)
 	}
 
 	cmd := handler()
 
-	err = reEncode(header.Args, cmd)
+	if header.RawArgs != nil {
+		err = s.codec.Unmarshal(header.RawArgs, commandArgs(cmd))
+	} else {
+		err = reEncode(header.Args, commandArgs(cmd))
+	}
 	if err != nil {
-		s.WriteResponseHeader(SessionResponseHeader{
-			Code: 422,
-			Msg:  "error unmarshalling command",
-		})
+		s.WriteError(fmt.Errorf("%w: error unmarshalling command", ErrInvalidArgument))
+		commands.recordSession(s, header.Cmd, header.Cmd, cmd, true, false, start)
 		return fmt.Errorf("error unmarshalling command: %w", err)
 	}
 
-	err = cmd.ExecuteServer(s)
+	// Permissions are checked by the interceptors of the collection, e.g. the role check of the system server.
+	// observe runs around them, so rejected commands are counted and recorded like failed ones.
+	err = runInterceptors(append([]Interceptor{commands.observe}, commands.incoming...), s, cmd, func() error {
+		return cmd.ExecuteServer(s)
+	})
 
 	if err != nil {
-		if s.ensureState(RpcSessionClosed) == nil {
-			s.WriteResponseHeader(SessionResponseHeader{
-				Code: 500,
-				Msg:  "error executing command",
-			})
-			return fmt.Errorf("error executing command: %w", err)
-		}
 		return fmt.Errorf("error executing command: %w", err)
 	} else {
 		if s.ensureState(RpcSessionClosed) == nil {
@@ -183,6 +239,7 @@ func (s *RpcSession) Read(p []byte) (n int, err error) {
 	return s.stream.Read(p)
 }
 
+// Context ends when the session is closed, its deadline passed or the partner stopped reading.
 func (s *RpcSession) Context() context.Context {
 	return s.ctx
 }
@@ -192,16 +249,22 @@ func ReadMessage[P any](s *RpcSession, payload P) error {
 		return fmt.Errorf("can't read message from unknown sender: session has no partner specified")
 	}
 
+	if s.recvMac != nil {
+		return readMacMessage[P](s, payload)
+	}
+
 	message := &RpcMessage[P]{
 		Payload: payload,
 	}
 
-	err := pki.ReadAndUnmarshalAndVerify(s.stream, message, s.partnerKey)
+	err := pki.ReadAndUnmarshalAndVerifyWith(s.stream, message, s.partnerKey, s.codec.Unmarshal)
 	if err != nil {
 		return fmt.Errorf("error reading message: %w", err)
 	}
 
-	err = message.Verify(s.connection.nonceStorage, s.credentials.PublicKey())
+	offset, tolerance := s.timestampWindow()
+
+	err = message.Verify(s.connection.nonceStorage, s.credentials.PublicKey(), offset, tolerance)
 	if err != nil {
 		return fmt.Errorf("error verifying message: %w", err)
 	}
@@ -214,6 +277,10 @@ func WriteMessage[P any](s *RpcSession, payload P) error {
 		return fmt.Errorf("can't address message to unknown sender: session has no partner specified")
 	}
 
+	if s.sendMac != nil {
+		return writeMacMessage[P](s, payload)
+	}
+
 	// log.Printf("creating message...")
 
 	message, err := newRpcMessage[P](s.partnerKey, payload)
@@ -223,7 +290,7 @@ func WriteMessage[P any](s *RpcSession, payload P) error {
 
 	// log.Printf("marshalling message...")
 
-	data, err := pki.MarshalAndSign(message, s.credentials)
+	data, err := pki.MarshalAndSignWith(message, s.credentials, s.codec.Marshal)
 	if err != nil {
 		return fmt.Errorf("error marshalling message: %w", err)
 	}
@@ -247,6 +314,15 @@ func (s *RpcSession) writeRequestHeader(header sessionRequestHeader) error {
 		return fmt.Errorf("error mutating state: %w", err)
 	}
 
+	// headers start a new exchange, e.g. after forwarding, so they are always signed
+	s.resetMac()
+
+	header.Kex, err = s.newKex()
+	if err != nil {
+		s.mutateState(RpcSessionOpen, RpcSessionClosed)
+		return err
+	}
+
 	err = WriteMessage[sessionRequestHeader](s, header)
 	if err != nil {
 		s.mutateState(RpcSessionOpen, RpcSessionClosed)
@@ -267,15 +343,38 @@ func (s *RpcSession) WriteResponseHeader(header SessionResponseHeader) error {
 		return fmt.Errorf("error mutating state: %w", err)
 	}
 
+	s.responseCode = header.Code
+
+	// errors written by hand still tell the client their kind
+	if header.Code >= 400 && header.Info == nil {
+		header.Info = &ErrorInfo{
+			Kind: kindOfCode(header.Code).name,
+		}
+	}
+
+	// peers that don't announce a key keep signing every message
+	peerKex := s.peerKex
+	if peerKex != nil {
+		header.Kex, err = s.newKex()
+		if err != nil {
+			s.mutateState(RpcSessionOpen, RpcSessionClosed)
+			return err
+		}
+	}
+
 	err = WriteMessage[SessionResponseHeader](s, header)
 	if err != nil {
-		stateErr := s.mutateState(s.state, RpcSessionClosed)
-		if stateErr != nil {
-			panic(stateErr)
-		}
+		s.mutateState(RpcSessionOpen, RpcSessionClosed)
 		return fmt.Errorf("error writing response header: %w", err)
 	}
 
+	if peerKex != nil {
+		err = s.deriveMac(peerKex, false)
+		if err != nil {
+			return fmt.Errorf("error deriving session mac: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -307,7 +406,7 @@ func (s *RpcSession) sendCommand(cmd RpcCommand) (util.AsyncAction, error) {
 	err = sendMyKey(s)
 	if err != nil {
 		s.Close()
-		return nil, fmt.Errorf("error sending my public key: %w", err)
+		return nil, fmt.Errorf("error sending my public key: %w", s.explainError(err))
 	}
 
 	err = s.mutateState(RpcSessionOpen, RpcSessionCreated)
@@ -315,38 +414,42 @@ func (s *RpcSession) sendCommand(cmd RpcCommand) (util.AsyncAction, error) {
 		return nil, fmt.Errorf("error mutating state: %w", err)
 	}
 
-	args := make(map[string]interface{})
-	err = reEncode(cmd, &args)
+	s.watchContext()
+
+	header := sessionRequestHeader{
+		Cmd:     cmd.GetKey(),
+		Timeout: s.requestTimeout(),
+	}
+
+	// JSON peers may not know RawArgs yet
+	if s.codec.Name() == CodecJson {
+		header.Args = make(map[string]interface{})
+		err = reEncode(commandArgs(cmd), &header.Args)
+	} else {
+		header.RawArgs, err = s.codec.Marshal(commandArgs(cmd))
+	}
 	if err != nil {
 		s.Close()
 		return nil, fmt.Errorf("error encoding command: %w", err)
 	}
 
-	header := sessionRequestHeader{
-		Cmd:  cmd.GetKey(),
-		Args: args,
-	}
-
 	err = s.writeRequestHeader(header)
 	if err != nil {
 		s.Close()
-		return nil, fmt.Errorf("error writing header to stream: %w", err)
+		return nil, fmt.Errorf("error writing header to stream: %w", s.explainError(err))
 	}
 
 	response, err := s.readResponseHeader()
 	if err != nil {
 		s.Close()
-		return nil, fmt.Errorf("error reading response header: %w", err)
+		return nil, fmt.Errorf("error reading response header: %w", s.explainError(err))
 	}
 
 	log.Printf("Response Header:\n%v\n", response)
 
 	if response.Code != 200 {
 		s.Close()
-		return nil, fmt.Errorf("error sending command: %w", &SessionError{
-			code: response.Code,
-			msg:  response.Msg,
-		})
+		return nil, fmt.Errorf("error sending command: %w", newSessionError(response))
 	}
 
 	log.Printf("Command sent successfully: %s", cmd.GetKey())
@@ -357,16 +460,45 @@ func (s *RpcSession) sendCommand(cmd RpcCommand) (util.AsyncAction, error) {
 	}
 
 	go func() {
-		running.errChan <- cmd.ExecuteClient(s)
+		running.errChan <- s.executeClient(cmd)
 		err := s.Close()
 		if err != nil {
-			panic(err)
+			log.Printf("error closing session: %v", err)
 		}
 	}()
 
 	return running, nil
 }
 
+// executeClient runs the client side of cmd, a panic fails the command instead of the process.
+func (s *RpcSession) executeClient(cmd RpcCommand) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.recoverPanic(r)
+		}
+	}()
+
+	return cmd.ExecuteClient(s)
+}
+
+// recoverPanic logs a recovered panic with its stack and answers with an internal error if no response was written yet.
+func (s *RpcSession) recoverPanic(r any) error {
+	logPanic("session", s.connection, r)
+
+	err := fmt.Errorf("%w: panic: %v", ErrInternal, r)
+
+	if s.ensureState(RpcSessionRequested) == nil {
+		s.WriteError(err)
+	}
+
+	return err
+}
+
+// logPanic logs a recovered panic with the stack, where says if it happened in a session or outside of one.
+func logPanic(where string, conn *RpcConnection, r any) {
+	log.Printf("recovered panic in %s with %s: %v\n%s", where, conn.partnerName(), r, debug.Stack())
+}
+
 type runningCommand struct {
 	session    *RpcSession
 	errChan    chan error
@@ -383,14 +515,18 @@ func (r *runningCommand) Close() error {
 	return nil
 }
 
+// Wait returns the error the command failed with.
+// Errors of the partner are *SessionError, all errors can be matched with the kinds in errors.go.
 func (r *runningCommand) Wait() error {
 	err := <-r.errChan
 	if err != nil {
 		// cancel errors are expected, since we might be force closing
 		streamErr := &quic.StreamError{}
-		if r.forceClose && errors.Is(err, streamErr) {
+		if r.forceClose && errors.As(err, &streamErr) {
 			return nil
 		}
+
+		return r.session.explainError(err)
 	}
 
 	return nil
@@ -405,6 +541,10 @@ func (s *RpcSession) Close() error {
 	}
 	s.state = RpcSessionClosed
 
+	if s.cancel != nil {
+		s.cancel()
+	}
+
 	s.connection.removeSession(s.id)
 
 	err := s.stream.Close()
@@ -418,21 +558,33 @@ func (s *RpcSession) Close() error {
 type sessionRequestHeader struct {
 	Cmd  string                 `json:"cmd"`
 	Args map[string]interface{} `json:"args"`
+	// RawArgs holds the command encoded with the codec of the session, it replaces Args for codecs other than JSON.
+	RawArgs []byte `json:"rawArgs,omitempty"`
+	// Kex is the ephemeral key of the requester for the session MAC.
+	Kex []byte `json:"kex,omitempty"`
+	// Timeout is how many milliseconds the requester waits for the command, 0 for no limit.
+	Timeout int64 `json:"timeout,omitempty"`
 }
 
 type SessionResponseHeader struct {
 	Code int         `json:"code"`
 	Msg  string      `json:"msg"`
 	Info interface{} `json:"info"`
+	// Kex is the ephemeral key of the responder, it is set when the header is written.
+	Kex []byte `json:"kex,omitempty"`
 }
 
 func (s *RpcSession) readRequestHeader() (sessionRequestHeader, error) {
+	s.resetMac()
+
 	header := sessionRequestHeader{}
 	err := ReadMessage[*sessionRequestHeader](s, &header)
 	if err != nil {
 		return sessionRequestHeader{}, fmt.Errorf("error reading request header: %w", err)
 	}
 
+	s.peerKex = header.Kex
+
 	err = s.mutateState(RpcSessionCreated, RpcSessionRequested)
 	if err != nil {
 		return sessionRequestHeader{}, fmt.Errorf("error setting session state: %w", err)
@@ -453,6 +605,13 @@ func (s *RpcSession) readResponseHeader() (SessionResponseHeader, error) {
 		return SessionResponseHeader{}, fmt.Errorf("error reading response header: %w", err)
 	}
 
+	if header.Kex != nil {
+		err = s.deriveMac(header.Kex, true)
+		if err != nil {
+			return SessionResponseHeader{}, fmt.Errorf("error deriving session mac: %w", err)
+		}
+	}
+
 	if header.Code >= 200 || header.Code <= 299 {
 		err = s.mutateState(RpcSessionRequested, RpcSessionOpen)
 		if err != nil {
@@ -503,3 +662,8 @@ func (s *RpcSession) Verifier() pki.Verifier {
 func (s *RpcSession) Partner() *pki.Certificate {
 	return s.partner
 }
+
+// RemoteAddr returns the address of the peer the session is connected to.
+func (s *RpcSession) RemoteAddr() net.Addr {
+	return s.connection.connection.RemoteAddr()
+}
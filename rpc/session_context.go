@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Sessions live as long as the context they were opened with.
+// The requester sends the time left until its deadline along in the request header,
+// so the handler gets the same deadline. If the context ends before the session was closed,
+// the stream is canceled in both directions and the partner stops as well.
+// Handlers find the context with Context(), it also ends when the partner stops reading.
+
+// streamCanceled is the stream error code of sessions whose context ended.
+const streamCanceled quic.StreamErrorCode = 499
+
+// requestTimeout returns the time left for the session in milliseconds, 0 without deadline.
+func (s *RpcSession) requestTimeout() int64 {
+	deadline, ok := s.ctx.Deadline()
+	if !ok {
+		return 0
+	}
+
+	timeout := time.Until(deadline).Milliseconds()
+	if timeout < 1 {
+		timeout = 1
+	}
+
+	return timeout
+}
+
+// setTimeout limits the session to the time the requester has left.
+func (s *RpcSession) setTimeout(timeout int64) {
+	if timeout > 0 {
+		s.timeout = time.Duration(timeout) * time.Millisecond
+	}
+}
+
+// watchContext starts aborting the session once its context ends.
+// Only the first call has an effect, forwarded and encrypted sessions keep the deadline of the first header.
+func (s *RpcSession) watchContext() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancel != nil || s.state == RpcSessionClosed {
+		return
+	}
+
+	lifetime, cancelLifetime := context.WithCancel(s.ctx)
+	if s.timeout > 0 {
+		cancelLifetime()
+		lifetime, cancelLifetime = context.WithTimeout(s.ctx, s.timeout)
+	}
+
+	ctx, cancel := context.WithCancel(lifetime)
+	s.ctx = ctx
+	s.cancel = func() {
+		cancel()
+		cancelLifetime()
+	}
+
+	go func() {
+		select {
+		case <-lifetime.Done():
+			s.abort(lifetime.Err())
+		case <-s.quicStream.Context().Done():
+		}
+		cancel()
+	}()
+}
+
+// abort cancels the stream instead of closing it, pending data is dropped.
+func (s *RpcSession) abort(cause error) {
+	s.mutex.Lock()
+	if s.state == RpcSessionClosed {
+		s.mutex.Unlock()
+		return
+	}
+	s.state = RpcSessionClosed
+	s.abortCause = cause
+	s.mutex.Unlock()
+
+	log.Printf("aborting session: %v", cause)
+
+	s.quicStream.CancelWrite(streamCanceled)
+	s.quicStream.CancelRead(streamCanceled)
+
+	s.connection.removeSession(s.id)
+}
+
+// explainError returns why the session was aborted instead of the error this caused.
+func (s *RpcSession) explainError(err error) error {
+	s.mutex.Lock()
+	cause := s.abortCause
+	s.mutex.Unlock()
+
+	if cause != nil {
+		err = fmt.Errorf("session aborted: %w", cause)
+	}
+
+	return classifyError(err)
+}
@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/util"
+)
+
+// Request and response headers are signed and carry ephemeral keys.
+// Once both were exchanged, the following messages of the session are authenticated
+// with a MAC derived from the keys instead of being signed one by one.
+// The MAC is bound to the session, so messages can't be replayed on another one,
+// and covers a sequence number, which replaces the nonce check within the session.
+
+func (s *RpcSession) resetMac() {
+	s.kexKey = nil
+	s.peerKex = nil
+	s.sendMac = nil
+	s.recvMac = nil
+}
+
+// newKex creates the ephemeral key announced in the next header.
+func (s *RpcSession) newKex() ([]byte, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating session key: %w", err)
+	}
+
+	s.kexKey = key
+	return key.PublicKey().Bytes(), nil
+}
+
+// deriveMac switches the session to MAC authenticated messages.
+func (s *RpcSession) deriveMac(peerKex []byte, initiator bool) error {
+	if s.kexKey == nil {
+		return fmt.Errorf("no session key generated")
+	}
+
+	peerKey, err := ecdh.X25519().NewPublicKey(peerKex)
+	if err != nil {
+		return fmt.Errorf("error parsing peer session key: %w", err)
+	}
+
+	secret, err := s.kexKey.ECDH(peerKey)
+	if err != nil {
+		return fmt.Errorf("error computing shared secret: %w", err)
+	}
+
+	// the salt binds the keys to both headers
+	ownKex := s.kexKey.PublicKey().Bytes()
+	salt := append(append([]byte{}, peerKex...), ownKex...)
+	if initiator {
+		salt = append(append([]byte{}, ownKex...), peerKex...)
+	}
+
+	send, receive, err := util.DeriveMessageAuthenticators(secret, salt, initiator)
+	if err != nil {
+		return err
+	}
+
+	s.kexKey = nil
+	s.peerKex = nil
+	s.sendMac = send
+	s.recvMac = receive
+
+	return nil
+}
+
+func writeMacMessage[P any](s *RpcSession, payload P) error {
+	message := &RpcMessage[P]{
+		Timestamp: time.Now().Unix(),
+		Receiver:  s.partnerKey,
+		Payload:   payload,
+	}
+
+	data, err := s.codec.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error marshalling message: %w", err)
+	}
+
+	// the tag has to be written in the order of the sequence
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	packed, err := asn1.Marshal(pki.PackedData{
+		Data:      data,
+		Signature: s.sendMac.Tag(data),
+	})
+	if err != nil {
+		return fmt.Errorf("error packing message: %w", err)
+	}
+
+	n, err := s.Write(packed)
+	if err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if n != len(packed) {
+		return fmt.Errorf("error writing message: %w", io.ErrShortWrite)
+	}
+
+	return nil
+}
+
+func readMacMessage[P any](s *RpcSession, payload P) error {
+	der, err := util.ReadSingleDer(s.stream)
+	if err != nil {
+		return fmt.Errorf("error reading message: %w", err)
+	}
+
+	packed := pki.PackedData{}
+	rest, err := asn1.Unmarshal(der, &packed)
+	if err != nil {
+		return fmt.Errorf("error unpacking message: %w", err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("found rest after unpacking message")
+	}
+
+	err = s.recvMac.Verify(packed.Data, packed.Signature)
+	if err != nil {
+		return fmt.Errorf("error verifying message: %w", err)
+	}
+
+	message := &RpcMessage[P]{
+		Payload: payload,
+	}
+
+	err = s.codec.Unmarshal(packed.Data, message)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling message: %w", err)
+	}
+
+	err = message.VerifyTimestamp(s.timestampWindow())
+	if err != nil {
+		return fmt.Errorf("error verifying message: %w", err)
+	}
+
+	err = message.VerifyReceiver(s.credentials.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error verifying message: %w", err)
+	}
+
+	return nil
+}
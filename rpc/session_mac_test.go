@@ -0,0 +1,294 @@
+package rpc_test
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+// BenchmarkMessageAuthentication compares signing every message with the session MAC.
+func BenchmarkMessageAuthentication(b *testing.B) {
+	message := testMessage(b)
+	message.Payload.Cert = nil
+
+	credentials, err := pki.GenerateCredentials()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	codec, err := rpc.GetCodec(rpc.CodecCbor)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("signed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			signed, err := pki.MarshalAndSignWith(message, credentials, codec.Marshal)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			decoded := &rpc.RpcMessage[*codecTestPayload]{}
+			err = pki.UnmarshalAndVerifyWith(signed, decoded, credentials.PublicKey(), codec.Unmarshal)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+	})
+
+	b.Run("mac", func(b *testing.B) {
+		send, _, err := util.DeriveMessageAuthenticators([]byte("secret"), nil, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, receive, err := util.DeriveMessageAuthenticators([]byte("secret"), nil, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for i := 0; i < b.N; i++ {
+			data, err := codec.Marshal(message)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			tag := send.Tag(data)
+
+			err = receive.Verify(data, tag)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			decoded := &rpc.RpcMessage[*codecTestPayload]{}
+			err = codec.Unmarshal(data, decoded)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+	})
+}
+
+type macTestPayload struct {
+	Text string
+}
+
+// macSessionPair returns a requester and responder that exchanged headers.
+// Messages the requester writes afterwards pass through relay.
+func macSessionPair(t *testing.T, relay func(msg []byte) [][]byte) (*rpc.RpcSession, *rpc.RpcSession) {
+	requesterCreds, err := pki.GenerateRootCredentials("requester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responderCreds, err := pki.GenerateRootCredentials("responder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := 0
+	requester, responder := rpc.NewTestSessionPair(requesterCreds, responderCreds, func(msg []byte) [][]byte {
+		if headers < 1 {
+			headers++
+			return [][]byte{msg}
+		}
+		return relay(msg)
+	})
+	t.Cleanup(func() {
+		requester.Close()
+		responder.Close()
+	})
+
+	exchangeTestHeaders(t, requester, responder)
+
+	return requester, responder
+}
+
+func exchangeTestHeaders(t *testing.T, requester *rpc.RpcSession, responder *rpc.RpcSession) {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- rpc.WriteTestRequest(requester, "test")
+	}()
+
+	cmd, err := rpc.ReadTestRequest(responder)
+	if err != nil {
+		t.Fatalf("error reading request header: %v", err)
+	}
+	if cmd != "test" {
+		t.Fatalf("expected command test, got %s", cmd)
+	}
+
+	err = <-errChan
+	if err != nil {
+		t.Fatalf("error writing request header: %v", err)
+	}
+
+	go func() {
+		errChan <- responder.WriteResponseHeader(rpc.SessionResponseHeader{Code: 200, Msg: "OK"})
+	}()
+
+	_, err = rpc.ReadTestResponse(requester)
+	if err != nil {
+		t.Fatalf("error reading response header: %v", err)
+	}
+
+	err = <-errChan
+	if err != nil {
+		t.Fatalf("error writing response header: %v", err)
+	}
+}
+
+// sendTestMessages writes the texts from the requester and reads them on the responder until the first error.
+func sendTestMessages(requester *rpc.RpcSession, responder *rpc.RpcSession, texts ...string) ([]string, error) {
+	go func() {
+		for _, text := range texts {
+			err := rpc.WriteMessage(requester, &macTestPayload{Text: text})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	received := make([]string, 0)
+	for range texts {
+		payload := &macTestPayload{}
+		err := rpc.ReadMessage(responder, payload)
+		if err != nil {
+			return received, err
+		}
+		received = append(received, payload.Text)
+	}
+
+	return received, nil
+}
+
+func passThrough(msg []byte) [][]byte {
+	return [][]byte{msg}
+}
+
+// signedBy checks if msg is a message signed with the given key instead of authenticated with the session MAC.
+func signedBy(t *testing.T, msg []byte, pub *pki.PublicKey) bool {
+	codec, err := rpc.GetCodec(rpc.CodecJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &rpc.RpcMessage[map[string]any]{}
+	return pki.UnmarshalAndVerifyWith(msg, message, pub, codec.Unmarshal) == nil
+}
+
+func TestSessionSwitchesToMac(t *testing.T) {
+	var relayed [][]byte
+	requester, responder := macSessionPair(t, func(msg []byte) [][]byte {
+		relayed = append(relayed, msg)
+		return [][]byte{msg}
+	})
+
+	if !rpc.UsesMac(requester) || !rpc.UsesMac(responder) {
+		t.Fatalf("session did not switch to the session MAC after the headers")
+	}
+
+	received, err := sendTestMessages(requester, responder, "first", "second")
+	if err != nil {
+		t.Fatalf("error reading message: %v", err)
+	}
+
+	if received[0] != "first" || received[1] != "second" {
+		t.Errorf("unexpected messages: %v", received)
+	}
+
+	for _, msg := range relayed {
+		if signedBy(t, msg, responder.Partner().PublicKey()) {
+			t.Errorf("message was signed after switching to the session MAC")
+		}
+	}
+}
+
+func TestSessionMacRejectsReordered(t *testing.T) {
+	var held []byte
+	requester, responder := macSessionPair(t, func(msg []byte) [][]byte {
+		if held == nil {
+			held = msg
+			return nil
+		}
+		return [][]byte{msg, held}
+	})
+
+	_, err := sendTestMessages(requester, responder, "first", "second")
+	if err == nil {
+		t.Errorf("reordered message was accepted")
+	}
+}
+
+func TestSessionMacRejectsTampered(t *testing.T) {
+	requester, responder := macSessionPair(t, func(msg []byte) [][]byte {
+		packed := pki.PackedData{}
+		_, err := asn1.Unmarshal(msg, &packed)
+		if err != nil {
+			t.Errorf("error unpacking message: %v", err)
+			return [][]byte{msg}
+		}
+
+		packed.Data = bytes.Replace(packed.Data, []byte("pay"), []byte("PAY"), 1)
+
+		tampered, err := asn1.Marshal(packed)
+		if err != nil {
+			t.Errorf("error packing message: %v", err)
+			return [][]byte{msg}
+		}
+
+		return [][]byte{tampered}
+	})
+
+	_, err := sendTestMessages(requester, responder, "pay 10")
+	if err == nil {
+		t.Errorf("tampered message was accepted")
+	}
+}
+
+func TestSessionMacResetsOnNextHeader(t *testing.T) {
+	var relayed [][]byte
+	requester, responder := macSessionPair(t, func(msg []byte) [][]byte {
+		relayed = append(relayed, msg)
+		return [][]byte{msg}
+	})
+
+	_, err := sendTestMessages(requester, responder, "before")
+	if err != nil {
+		t.Fatalf("error reading message: %v", err)
+	}
+
+	// forwarding and encrypting a session send the next header on the same stream
+	err = rpc.RestartTestSession(requester)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rpc.RestartTestSession(responder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exchangeTestHeaders(t, requester, responder)
+
+	// the far peer of a forwarded session can only verify a signed header
+	header := relayed[len(relayed)-1]
+	if !signedBy(t, header, responder.Partner().PublicKey()) {
+		t.Errorf("next request header was not signed")
+	}
+
+	if !rpc.UsesMac(requester) || !rpc.UsesMac(responder) {
+		t.Fatalf("session did not switch to the session MAC after the next headers")
+	}
+
+	_, err = sendTestMessages(requester, responder, "after")
+	if err != nil {
+		t.Errorf("error reading message with the new session MAC: %v", err)
+	}
+}
@@ -8,12 +8,18 @@ import (
 
 type TlsConnectionProto string
 
+// The version suffix of ProtoRpc only changes if the handshake itself changes,
+// everything else is negotiated in the handshake, see PeerInfo.
 const (
 	ProtoError       TlsConnectionProto = ""
 	ProtoServerInit  TlsConnectionProto = "github.com/rahn-it/svalin-server-init"
-	ProtoRpc         TlsConnectionProto = "github.com/rahn-it/svalin-rpc"
+	ProtoRpc         TlsConnectionProto = "github.com/rahn-it/svalin-rpc/1"
 	ProtoClientLogin TlsConnectionProto = "github.com/rahn-it/svalin-client-login"
-	ProtoAgentEnroll TlsConnectionProto = "github.com/rahn-it/svalin-agent-enroll"
+	ProtoAgentEnroll TlsConnectionProto = "github.com/rahn-it/svalin-agent-enroll/1"
+	// ProtoRpcLegacy is spoken by builds without a handshake, they are only accepted to tell them to update.
+	ProtoRpcLegacy TlsConnectionProto = "github.com/rahn-it/svalin-rpc"
+	// ProtoAgentEnrollLegacy is spoken by servers that neither read an enrollment request nor send a challenge.
+	ProtoAgentEnrollLegacy TlsConnectionProto = "github.com/rahn-it/svalin-agent-enroll"
 )
 
 func getTlsTempClientConfig(protos []TlsConnectionProto) *tls.Config {
@@ -31,7 +37,7 @@ func getTlsTempClientConfig(protos []TlsConnectionProto) *tls.Config {
 	}
 }
 
-func getTlsClientConfig(proto TlsConnectionProto, credentials pki.Credentials) *tls.Config {
+func getTlsClientConfig(protos []TlsConnectionProto, credentials pki.Credentials) *tls.Config {
 	var certGetter func(*tls.CertificateRequestInfo) (*tls.Certificate, error) = nil
 
 	tlsCredentials, ok := credentials.(interface {
@@ -53,10 +59,16 @@ func getTlsClientConfig(proto TlsConnectionProto, credentials pki.Credentials) *
 		}
 	}
 
+	tlsProtos := make([]string, len(protos))
+
+	for i, proto := range protos {
+		tlsProtos[i] = string(proto)
+	}
+
 	return &tls.Config{
 		// TODO: implement ACME certificate request and remove the InsecureSkipVerify option
 		InsecureSkipVerify:   true,
-		NextProtos:           []string{string(proto)},
+		NextProtos:           tlsProtos,
 		GetClientCertificate: certGetter,
 	}
 }
@@ -0,0 +1,220 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Most commands send a request and get back a single response or a stream of them.
+// Instead of implementing RpcCommand, they can be declared with a handler for a typed request and response.
+// The request is sent as arguments of the command, so it has to be a struct.
+// The response header is written for the handler,
+// and errors of the handler are responded with WriteError, so clients can match their kind.
+
+// Empty is the request or response of commands that don't need one.
+type Empty struct{}
+
+// UnaryHandler handles the request of a unary command and returns the response.
+type UnaryHandler[Req any, Resp any] func(session *RpcSession, request *Req) (*Resp, error)
+
+// StreamHandler handles the request of a server streaming command, it calls send for every response.
+// The stream ends when the handler returns.
+type StreamHandler[Req any, Resp any] func(session *RpcSession, request *Req, send func(*Resp) error) error
+
+// argsCommand is implemented by commands that only send part of them as arguments.
+type argsCommand interface {
+	args() any
+}
+
+// commandArgs returns what is sent as arguments of cmd.
+func commandArgs(cmd RpcCommand) any {
+	if c, ok := cmd.(argsCommand); ok {
+		return c.args()
+	}
+	return cmd
+}
+
+// UnaryCommand is a command declared with a UnaryHandler.
+type UnaryCommand[Req any, Resp any] struct {
+	key      string
+	request  *Req
+	response *Resp
+	handler  UnaryHandler[Req, Resp]
+}
+
+var _ RpcCommand = (*UnaryCommand[Empty, Empty])(nil)
+
+// UnaryCommandHandler creates the handler to add a unary command to a CommandCollection.
+func UnaryCommandHandler[Req any, Resp any](key string, handler UnaryHandler[Req, Resp]) RpcCommandHandler {
+	return func() RpcCommand {
+		return &UnaryCommand[Req, Resp]{
+			key:     key,
+			request: new(Req),
+			handler: handler,
+		}
+	}
+}
+
+// RegisterUnary adds a unary command to the collection.
+func RegisterUnary[Req any, Resp any](c *CommandCollection, key string, handler UnaryHandler[Req, Resp]) {
+	c.Add(UnaryCommandHandler(key, handler))
+}
+
+// NewUnaryCommand creates the command a client sends, the response is available after it was executed.
+func NewUnaryCommand[Req any, Resp any](key string, request *Req) *UnaryCommand[Req, Resp] {
+	return &UnaryCommand[Req, Resp]{
+		key:      key,
+		request:  request,
+		response: new(Resp),
+	}
+}
+
+func (c *UnaryCommand[Req, Resp]) GetKey() string {
+	return c.key
+}
+
+func (c *UnaryCommand[Req, Resp]) args() any {
+	return c.request
+}
+
+// Response returns the response of the partner, it is empty until the command was executed.
+func (c *UnaryCommand[Req, Resp]) Response() *Resp {
+	return c.response
+}
+
+func (c *UnaryCommand[Req, Resp]) ExecuteServer(session *RpcSession) error {
+	response, err := c.handler(session, c.request)
+	if err != nil {
+		session.WriteError(err)
+		return fmt.Errorf("error handling %s: %w", c.key, err)
+	}
+
+	if response == nil {
+		response = new(Resp)
+	}
+
+	err = session.WriteResponseHeader(SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	err = WriteMessage[*Resp](session, response)
+	if err != nil {
+		return fmt.Errorf("error writing response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *UnaryCommand[Req, Resp]) ExecuteClient(session *RpcSession) error {
+	err := ReadMessage[*Resp](session, c.response)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	return nil
+}
+
+// StreamCommand is a command declared with a StreamHandler.
+type StreamCommand[Req any, Resp any] struct {
+	key     string
+	request *Req
+	receive func(*Resp) error
+	handler StreamHandler[Req, Resp]
+}
+
+var _ RpcCommand = (*StreamCommand[Empty, Empty])(nil)
+
+// StreamCommandHandler creates the handler to add a server streaming command to a CommandCollection.
+func StreamCommandHandler[Req any, Resp any](key string, handler StreamHandler[Req, Resp]) RpcCommandHandler {
+	return func() RpcCommand {
+		return &StreamCommand[Req, Resp]{
+			key:     key,
+			request: new(Req),
+			handler: handler,
+		}
+	}
+}
+
+// RegisterStream adds a server streaming command to the collection.
+func RegisterStream[Req any, Resp any](c *CommandCollection, key string, handler StreamHandler[Req, Resp]) {
+	c.Add(StreamCommandHandler(key, handler))
+}
+
+// NewStreamCommand creates the command a client sends, receive is called for every response.
+// The command ends without error when the partner ends the stream.
+func NewStreamCommand[Req any, Resp any](key string, request *Req, receive func(*Resp) error) *StreamCommand[Req, Resp] {
+	return &StreamCommand[Req, Resp]{
+		key:     key,
+		request: request,
+		receive: receive,
+	}
+}
+
+func (c *StreamCommand[Req, Resp]) GetKey() string {
+	return c.key
+}
+
+func (c *StreamCommand[Req, Resp]) args() any {
+	return c.request
+}
+
+func (c *StreamCommand[Req, Resp]) ExecuteServer(session *RpcSession) error {
+	// the header is written with the first response, so handlers can still fail before
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+
+		return session.WriteResponseHeader(SessionResponseHeader{
+			Code: 200,
+			Msg:  "OK",
+		})
+	}
+
+	err := c.handler(session, c.request, func(response *Resp) error {
+		err := start()
+		if err != nil {
+			return fmt.Errorf("error writing response header: %w", err)
+		}
+
+		return WriteMessage[*Resp](session, response)
+	})
+	if err != nil {
+		if !started {
+			session.WriteError(err)
+		}
+		return fmt.Errorf("error handling %s: %w", c.key, err)
+	}
+
+	err = start()
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	return nil
+}
+
+func (c *StreamCommand[Req, Resp]) ExecuteClient(session *RpcSession) error {
+	for {
+		response := new(Resp)
+		err := ReadMessage[*Resp](session, response)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading response: %w", err)
+		}
+
+		err = c.receive(response)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -4,19 +4,40 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/rahn-it/svalin/config"
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
 	"github.com/rahn-it/svalin/rmm"
 	"github.com/rahn-it/svalin/rpc"
 	"github.com/rahn-it/svalin/system"
 )
 
+const maintenanceInterval = 12 * time.Hour
+
+// revocationInterval is how often revocations are fetched from the upstream,
+// revoked certificates are accepted until then.
+const revocationInterval = 10 * time.Minute
+
 type Agent struct {
-	ep           *rpc.RpcEndpoint
-	profile      *config.Profile
-	agent_config *agentConfig
-	commands     *rpc.CommandCollection
+	ep              *rpc.RpcEndpoint
+	profile         *config.Profile
+	agent_config    *agentConfig
+	revocationStore *system.RevocationStore
+	commands        *rpc.CommandCollection
+	// auditTrail records every end-to-end command executed for a user.
+	auditTrail *system.AuditLog
+	// auditUploaded is the last audit entry the upstream confirmed, it is kept in auditCursor.
+	auditUploaded uint64
+	auditCursor   db.Scope
+	// shellRecordings keeps recorded shell sessions until they were uploaded.
+	shellRecordings db.Scope
+	// renewal serializes requesting a renewal with receiving a certificate pushed by the upstream.
+	renewal   sync.Mutex
+	reconnect bool
+	mutex     sync.Mutex
 }
 
 func Connect(profile *config.Profile) (*Agent, error) {
@@ -27,37 +48,252 @@ func Connect(profile *config.Profile) (*Agent, error) {
 		return nil, fmt.Errorf("error opening client config: %w", err)
 	}
 
-	verifier := system.NewUpstreamVerifier(config.Upstream(), config.Root(), nil)
+	revocationStore, err := system.OpenRevocationStore(scope.Scope("revocation"), config.Root())
+	if err != nil {
+		return nil, fmt.Errorf("error opening revocation store: %w", err)
+	}
 
-	ep, err := rpc.ConnectToServer(context.Background(), config.ServerAddr(), config.Credentials(), config.Upstream(), verifier)
+	auditTrail, err := system.OpenAuditLog(scope.Scope("audit"), func() pki.Credentials {
+		return config.Credentials()
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server: %w", err)
+		return nil, fmt.Errorf("error opening audit trail: %w", err)
 	}
 
-	verifier.SetEndPoint(ep)
+	a := &Agent{
+		profile:         profile,
+		agent_config:    config,
+		revocationStore: revocationStore,
+		auditTrail:      auditTrail,
+		auditCursor:     scope.Scope("audit-upload"),
+		shellRecordings: scope.Scope("shell-recordings"),
+	}
 
-	commands := rpc.NewCommandCollection(
+	err = a.loadAuditCursor()
+	if err != nil {
+		return nil, fmt.Errorf("error loading audit upload cursor: %w", err)
+	}
+
+	a.commands = rpc.NewCommandCollection(
 		rmm.MonitorSystemCommandHandler,
 		rmm.MonitorProcessesCommandHandler,
 		rmm.MonitorServicesCommandHandler,
 		rmm.KillProcessCommandHandler,
-		rmm.RemoteShellCommandHandler,
+		rmm.CreateRemoteShellCommandHandler(profile.Config().Bool("agent.record-shell-input"), a.saveShellRecording),
 	)
 
-	a := &Agent{
-		ep:           ep,
-		profile:      profile,
-		agent_config: config,
-		commands:     commands,
+	a.commands.RecordSessions(auditTrail.RecordSession)
+
+	err = a.connect()
+	if err != nil {
+		return nil, err
 	}
 
 	return a, nil
 }
 
+func (a *Agent) connect() error {
+	config := a.agent_config
+
+	verifier := system.NewUpstreamVerifier(config.Anchors(), a.revocationStore)
+
+	// users reach the commands of the agent through end to end encrypted sessions, the server needs to know about them
+	commands := append(a.upstreamCommands().Keys(), a.commands.Keys()...)
+
+	ep, err := rpc.ConnectToServer(context.Background(), config.ServerAddr(), config.Credentials(), config.Upstream(), verifier, commands)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+
+	verifier.SetEndPoint(ep)
+
+	a.mutex.Lock()
+	a.ep = ep
+	a.mutex.Unlock()
+
+	return nil
+}
+
 func (a *Agent) Run() error {
-	return a.ep.ServeRpc(rpc.NewCommandCollection(
+	go a.maintenanceLoop()
+	go a.revocationLoop()
+	go a.auditUploadLoop()
+
+	for {
+		a.mutex.Lock()
+		ep := a.ep
+		a.mutex.Unlock()
+
+		err := ep.ServeRpc(a.upstreamCommands())
+
+		a.mutex.Lock()
+		reconnect := a.reconnect
+		a.reconnect = false
+		a.mutex.Unlock()
+
+		if !reconnect {
+			return err
+		}
+
+		log.Printf("reconnecting with new credentials...")
+
+		err = a.connect()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// upstreamCommands are served to the upstream directly.
+func (a *Agent) upstreamCommands() *rpc.CommandCollection {
+	return rpc.NewCommandCollection(
 		rpc.CreateE2eDecryptCommandHandler(a.commands),
-	))
+		system.CreateUpdateHostCertificateCommandHandler(a.agent_config.Anchors(), a.updateCertificate),
+	)
+}
+
+// updateCertificate saves a certificate pushed by the upstream.
+// The connection is still authenticated with the old key, so a rotated key requires a reconnect.
+func (a *Agent) updateCertificate(cert *pki.Certificate) error {
+	a.renewal.Lock()
+	defer a.renewal.Unlock()
+
+	rotated := !cert.PublicKey().Equal(a.agent_config.Credentials().PublicKey())
+
+	if rotated {
+		// the upstream verifies the trail with the key of the connection, entries signed with the old key have to go first
+		err := a.uploadAuditTrail()
+		if err != nil {
+			log.Printf("error uploading audit trail before key rotation: %v", err)
+		}
+	}
+
+	err := a.agent_config.updateCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("host certificate updated, valid until %s", cert.NotAfter())
+
+	if rotated {
+		go func() {
+			// allow the response to be sent
+			time.Sleep(5 * time.Second)
+
+			a.mutex.Lock()
+			a.reconnect = true
+			ep := a.ep
+			a.mutex.Unlock()
+
+			err := ep.Close(200, "host key rotated")
+			if err != nil {
+				log.Printf("error closing connection after key rotation: %v", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (a *Agent) maintenanceLoop() {
+	for {
+		err := a.checkRootRollover()
+		if err != nil {
+			log.Printf("error checking for root rollover: %v", err)
+		}
+
+		err = a.checkRenewal()
+		if err != nil {
+			log.Printf("error requesting certificate renewal: %v", err)
+		}
+
+		time.Sleep(maintenanceInterval)
+	}
+}
+
+func (a *Agent) revocationLoop() {
+	for {
+		err := a.syncRevocations()
+		if err != nil {
+			log.Printf("error syncing revocations: %v", err)
+		}
+
+		time.Sleep(revocationInterval)
+	}
+}
+
+// syncRevocations fetches the revocations the upstream made since the newest one known.
+func (a *Agent) syncRevocations() error {
+	latest, err := a.revocationStore.Latest()
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	ep := a.ep
+	a.mutex.Unlock()
+
+	cmd := system.NewGetRevocationsCommand(latest)
+	err = ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("error requesting revocations: %w", err)
+	}
+
+	return a.revocationStore.AddRevocations(cmd.Response().Revocations)
+}
+
+// checkRootRollover asks the upstream for a root rollover and starts trusting the next root.
+func (a *Agent) checkRootRollover() error {
+	a.mutex.Lock()
+	ep := a.ep
+	a.mutex.Unlock()
+
+	cmd := system.NewGetRootRolloverCommand()
+	err := ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("error requesting root rollover: %w", err)
+	}
+
+	rollover := cmd.Rollover()
+	if rollover == nil {
+		return nil
+	}
+
+	return a.agent_config.Anchors().BeginRollover(rollover)
+}
+
+func (a *Agent) checkRenewal() error {
+	a.renewal.Lock()
+	defer a.renewal.Unlock()
+
+	credentials := a.agent_config.Credentials()
+
+	if time.Until(credentials.Certificate().NotAfter()) > pki.AgentRenewBefore {
+		return nil
+	}
+
+	log.Printf("host certificate expires at %s, requesting renewal", credentials.Certificate().NotAfter())
+
+	next, err := a.agent_config.prepareRenewal(a.profile.Config().Bool("agent.rotate-key"))
+	if err != nil {
+		return fmt.Errorf("error preparing renewal: %w", err)
+	}
+
+	cmd, err := system.NewRequestRenewalCommand(credentials, next)
+	if err != nil {
+		return fmt.Errorf("error creating renewal request: %w", err)
+	}
+
+	a.mutex.Lock()
+	ep := a.ep
+	a.mutex.Unlock()
+
+	err = ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("error sending renewal request: %w", err)
+	}
+
+	return nil
 }
 
 func Init(profile *config.Profile) error {
@@ -75,9 +311,20 @@ func Init(profile *config.Profile) error {
 	if addr == "" {
 		return fmt.Errorf("agent address not set")
 	}
+	var token []byte
+	encodedToken := profile.Config().String("token")
+	if encodedToken != "" {
+		token, err = rpc.DecodeEnrollmentToken(encodedToken)
+		if err != nil {
+			return fmt.Errorf("error decoding enrollment token: %w", err)
+		}
+	}
+
 	log.Printf("Starting enrollment with server at %s", addr)
 
-	initInfo, err := rpc.EnrollWithUpstream(addr)
+	initInfo, err := rpc.EnrollWithUpstream(addr, token, collectEnrollmentMetadata(), func(code string) {
+		fmt.Printf("\nVerification code: %s\nEnter this code when approving the enrollment.\n\n", code)
+	})
 	if err != nil {
 		return fmt.Errorf("error enrolling with server: %w", err)
 	}
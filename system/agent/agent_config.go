@@ -3,6 +3,7 @@ package agent
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/rahn-it/svalin/db"
 	"github.com/rahn-it/svalin/pki"
@@ -12,10 +13,11 @@ import (
 
 type agentConfig struct {
 	scope       db.Scope
-	root        *pki.Certificate
-	upstream    *pki.Certificate
+	anchors     *system.TrustAnchors
 	credentials *pki.PermanentCredentials
 	serverAddr  string
+	// mutex guards the credentials and the pending key, renewals are requested and received concurrently.
+	mutex sync.Mutex
 }
 
 func openClientConfig(scope db.Scope) (*agentConfig, error) {
@@ -23,15 +25,11 @@ func openClientConfig(scope db.Scope) (*agentConfig, error) {
 		scope: scope,
 	}
 
-	err := conf.loadRoot()
+	anchors, err := system.OpenTrustAnchors(scope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load root: %w", err)
 	}
-
-	err = conf.loadUpstream()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load upstream: %w", err)
-	}
+	conf.anchors = anchors
 
 	err = conf.loadCredentials()
 	if err != nil {
@@ -46,38 +44,6 @@ func openClientConfig(scope db.Scope) (*agentConfig, error) {
 	return conf, nil
 }
 
-func (conf *agentConfig) loadRoot() error {
-	return conf.scope.View(func(b db.Bucket) error {
-		raw := b.Get([]byte("root"))
-		if raw == nil {
-			return errors.New("root certificate not found")
-		}
-		root, err := pki.CertificateFromPem(raw)
-		if err != nil {
-			return fmt.Errorf("failed to load root certificate: %w", err)
-		}
-
-		conf.root = root
-		return nil
-	})
-}
-
-func (conf *agentConfig) loadUpstream() error {
-	return conf.scope.View(func(b db.Bucket) error {
-		raw := b.Get([]byte("upstream"))
-		if raw == nil {
-			return errors.New("upstream certificate not found")
-		}
-		upstream, err := pki.CertificateFromPem(raw)
-		if err != nil {
-			return fmt.Errorf("failed to load upstream certificate: %w", err)
-		}
-
-		conf.upstream = upstream
-		return nil
-	})
-}
-
 func (conf *agentConfig) loadCredentials() error {
 
 	creds, err := system.LoadHostCredentials(conf.scope)
@@ -101,17 +67,94 @@ func (conf *agentConfig) loadServerAddr() error {
 }
 
 func (conf *agentConfig) Root() *pki.Certificate {
-	return conf.root
+	return conf.anchors.Root()
+}
+
+func (conf *agentConfig) Anchors() *system.TrustAnchors {
+	return conf.anchors
 }
 
 func (conf *agentConfig) Upstream() *pki.Certificate {
-	return conf.upstream
+	return conf.anchors.Upstream()
 }
 
 func (conf *agentConfig) Credentials() *pki.PermanentCredentials {
+	conf.mutex.Lock()
+	defer conf.mutex.Unlock()
+
 	return conf.credentials
 }
 
+// updateCertificate swaps the certificate in the stored host credentials.
+// If the certificate was issued for the pending renewal key, that key replaces the current one.
+func (conf *agentConfig) updateCertificate(cert *pki.Certificate) error {
+	if cert.Type() != pki.CertTypeAgent {
+		return fmt.Errorf("invalid certificate type: %s", cert.Type())
+	}
+
+	conf.mutex.Lock()
+	defer conf.mutex.Unlock()
+
+	var credentials *pki.PermanentCredentials
+
+	err := conf.scope.Update(func(b db.Bucket) error {
+		key := conf.credentials.PrivateKey()
+
+		if !cert.PublicKey().Equal(conf.credentials.PublicKey()) {
+			pending, err := system.LoadPendingHostKey(b)
+			if err != nil {
+				return fmt.Errorf("failed to load pending key: %w", err)
+			}
+
+			if pending == nil || !cert.PublicKey().Equal(pending.PublicKey()) {
+				return errors.New("certificate does not match the host key")
+			}
+
+			key = pending
+		}
+
+		credentials = pki.CredentialsFromCertAndKey(cert, key)
+
+		err := system.SaveHostCredentials(b, credentials)
+		if err != nil {
+			return fmt.Errorf("failed to save host credentials: %w", err)
+		}
+
+		return system.DeletePendingHostKey(b)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	conf.credentials = credentials
+	return nil
+}
+
+// prepareRenewal returns the credentials a renewal should be requested for.
+// When rotating, a new key is generated and kept until its certificate arrives.
+func (conf *agentConfig) prepareRenewal(rotateKey bool) (pki.Credentials, error) {
+	conf.mutex.Lock()
+	defer conf.mutex.Unlock()
+
+	if !rotateKey {
+		return conf.credentials, nil
+	}
+
+	next, err := pki.GenerateCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate credentials: %w", err)
+	}
+
+	err = conf.scope.Update(func(b db.Bucket) error {
+		return system.SavePendingHostKey(b, next.PrivateKey())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save pending key: %w", err)
+	}
+
+	return next, nil
+}
+
 func (conf *agentConfig) ServerAddr() string {
 	return conf.serverAddr
 }
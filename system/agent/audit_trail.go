@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/system"
+)
+
+const auditUploadInterval = 10 * time.Minute
+
+func (a *Agent) auditUploadLoop() {
+	for {
+		err := a.uploadAuditTrail()
+		if err != nil {
+			log.Printf("error uploading audit trail: %v", err)
+		}
+
+		err = a.uploadShellRecordings()
+		if err != nil {
+			log.Printf("error uploading shell recordings: %v", err)
+		}
+
+		time.Sleep(auditUploadInterval)
+	}
+}
+
+// uploadAuditTrail sends the entries the upstream doesn't have yet.
+// The upstream answers with its last entry, entries it already has are skipped.
+func (a *Agent) uploadAuditTrail() error {
+	for {
+		a.mutex.Lock()
+		ep := a.ep
+		uploaded := a.auditUploaded
+		a.mutex.Unlock()
+
+		page, err := a.auditTrail.Query(system.AuditQuery{
+			After: uploaded,
+			Limit: system.MaxAuditQueryLimit,
+		})
+		if err != nil {
+			return fmt.Errorf("error reading audit trail: %w", err)
+		}
+
+		if page.ChainError != "" {
+			log.Printf("WARNING: local audit trail is broken: %s", page.ChainError)
+		}
+
+		if len(page.Entries) == 0 {
+			return nil
+		}
+
+		cmd := system.NewUploadAuditTrailCommand(page.Entries)
+		err = ep.SendSyncCommand(context.Background(), cmd)
+		if err != nil {
+			return fmt.Errorf("error sending audit trail: %w", err)
+		}
+
+		if cmd.Head() <= uploaded {
+			return fmt.Errorf("upstream did not accept audit entries after %d", uploaded)
+		}
+
+		err = a.saveAuditCursor(cmd.Head())
+		if err != nil {
+			return err
+		}
+
+		if len(page.Entries) < system.MaxAuditQueryLimit {
+			return nil
+		}
+	}
+}
+
+var auditUploadedKey = []byte("uploaded")
+
+func (a *Agent) loadAuditCursor() error {
+	return a.auditCursor.View(func(b db.Bucket) error {
+		raw := b.Get(auditUploadedKey)
+		if raw == nil {
+			return nil
+		}
+
+		if len(raw) != 8 {
+			return fmt.Errorf("invalid audit upload cursor")
+		}
+
+		a.auditUploaded = binary.BigEndian.Uint64(raw)
+		return nil
+	})
+}
+
+// saveAuditCursor remembers the entries the upstream confirmed, so they aren't sent again after a restart.
+func (a *Agent) saveAuditCursor(uploaded uint64) error {
+	raw := binary.BigEndian.AppendUint64(nil, uploaded)
+
+	err := a.auditCursor.Update(func(b db.Bucket) error {
+		return b.Put(auditUploadedKey, raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	a.mutex.Lock()
+	a.auditUploaded = uploaded
+	a.mutex.Unlock()
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/rahn-it/svalin/rmm"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// collectEnrollmentMetadata gathers what is shown to the user approving the enrollment.
+// Missing information is left empty, it is not worth failing the enrollment for.
+func collectEnrollmentMetadata() rpc.EnrollmentMetadata {
+	metadata := rpc.EnrollmentMetadata{
+		OS: runtime.GOOS,
+	}
+
+	info, err := rmm.GetHostInfo()
+	if err != nil {
+		log.Printf("error getting host info: %v", err)
+
+		metadata.Hostname, _ = os.Hostname()
+	} else {
+		metadata.Hostname = info.Hostname
+		metadata.OS = strings.TrimSpace(strings.Join([]string{info.Platform, info.PlatformVersion}, " "))
+		if metadata.OS == "" {
+			metadata.OS = info.OS
+		}
+		if info.HostID != "" {
+			metadata.HardwareIDs = append(metadata.HardwareIDs, info.HostID)
+		}
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("error listing network interfaces: %v", err)
+		return metadata
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+
+		metadata.MACs = append(metadata.MACs, iface.HardwareAddr.String())
+	}
+
+	return metadata
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/rmm"
+)
+
+// saveShellRecording keeps the recording until the upstream has it.
+func (a *Agent) saveShellRecording(recording *rmm.ShellRecording) {
+	recording.Device = a.agent_config.Credentials().Certificate()
+
+	raw, err := json.Marshal(recording)
+	if err != nil {
+		log.Printf("error marshalling shell recording: %v", err)
+		return
+	}
+
+	err = a.shellRecordings.Update(func(b db.Bucket) error {
+		return b.Put([]byte(recording.ID), raw)
+	})
+	if err != nil {
+		log.Printf("error saving shell recording: %v", err)
+		return
+	}
+
+	go func() {
+		err := a.uploadShellRecordings()
+		if err != nil {
+			log.Printf("error uploading shell recordings: %v", err)
+		}
+	}()
+}
+
+// uploadShellRecordings sends all saved recordings to the upstream and removes them afterwards.
+func (a *Agent) uploadShellRecordings() error {
+	a.mutex.Lock()
+	ep := a.ep
+	a.mutex.Unlock()
+
+	recordings := make([]*rmm.ShellRecording, 0)
+
+	err := a.shellRecordings.View(func(b db.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			recording := &rmm.ShellRecording{}
+			err := json.Unmarshal(v, recording)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal shell recording: %w", err)
+			}
+
+			recordings = append(recordings, recording)
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error reading shell recordings: %w", err)
+	}
+
+	for _, recording := range recordings {
+		err := ep.SendSyncCommand(context.Background(), rmm.NewUploadShellRecordingCommand(recording))
+		if err != nil {
+			return fmt.Errorf("error uploading shell recording %s: %w", recording.ID, err)
+		}
+
+		err = a.shellRecordings.Update(func(b db.Bucket) error {
+			return b.Delete([]byte(recording.ID))
+		})
+		if err != nil {
+			return fmt.Errorf("error removing uploaded shell recording: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package system
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+)
+
+const (
+	AuditLoginSucceeded   = "login-succeeded"
+	AuditLoginFailed      = "login-failed"
+	AuditLoginLockedOut   = "login-locked-out"
+	AuditTotpReplay       = "totp-replay"
+	AuditRecoveryCodeUsed = "recovery-code-used"
+	AuditRpcSession       = "rpc-session"
+)
+
+// AuditEvent records a security relevant event on the server.
+type AuditEvent struct {
+	Time time.Time
+	Type string
+	// Subject is the user or device the event is about.
+	Subject string
+	Addr    string `json:",omitempty"`
+	Detail  string `json:",omitempty"`
+	// Partner, Command, Target, Code and Duration are set for RPC sessions.
+	Partner  *pki.Certificate `json:",omitempty"`
+	Command  string           `json:",omitempty"`
+	Target   string           `json:",omitempty"`
+	Code     int              `json:",omitempty"`
+	Duration time.Duration    `json:",omitempty"`
+}
+
+// AuditEntry is an event in the audit log.
+// Every entry contains the hash of the one before and is signed by the server,
+// so changing or removing entries breaks the chain.
+type AuditEntry struct {
+	Seq      uint64
+	Event    AuditEvent
+	PrevHash []byte
+	// Signature is the hash of the entry, signed by the server.
+	Signature []byte
+}
+
+// NewAuditEntry appends the event to the entry with the given hash.
+func NewAuditEntry(seq uint64, prevHash []byte, event AuditEvent, credentials pki.Credentials) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		Seq:      seq,
+		Event:    event,
+		PrevHash: prevHash,
+	}
+
+	hash, err := entry.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Signature, err = pki.MarshalAndSign(hash, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign audit entry: %w", err)
+	}
+
+	return entry, nil
+}
+
+// Hash covers the sequence number, the previous hash and the event.
+func (e *AuditEntry) Hash() ([]byte, error) {
+	rawEvent, err := json.Marshal(e.Event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, e.Seq)
+	h.Write(e.PrevHash)
+	h.Write(rawEvent)
+
+	return h.Sum(nil), nil
+}
+
+// Verify checks that the entry was signed by the server with the given key.
+func (e *AuditEntry) Verify(pub *pki.PublicKey) error {
+	hash, err := e.Hash()
+	if err != nil {
+		return err
+	}
+
+	var signed []byte
+	err = pki.UnmarshalAndVerify(e.Signature, &signed, pub)
+	if err != nil {
+		return fmt.Errorf("invalid signature on audit entry %d: %w", e.Seq, err)
+	}
+
+	if !bytes.Equal(signed, hash) {
+		return fmt.Errorf("audit entry %d was modified", e.Seq)
+	}
+
+	return nil
+}
+
+// Follows checks that the entry directly follows the given one.
+func (e *AuditEntry) Follows(prev *AuditEntry) error {
+	if prev == nil {
+		if e.Seq != 1 || len(e.PrevHash) != 0 {
+			return fmt.Errorf("audit entry %d is not the first entry", e.Seq)
+		}
+		return nil
+	}
+
+	if e.Seq != prev.Seq+1 {
+		return fmt.Errorf("audit entries between %d and %d are missing", prev.Seq, e.Seq)
+	}
+
+	prevHash, err := prev.Hash()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(e.PrevHash, prevHash) {
+		return fmt.Errorf("audit entry %d does not follow entry %d", e.Seq, prev.Seq)
+	}
+
+	return nil
+}
+
+// AuditQuery selects entries of the audit log, empty fields match every entry.
+type AuditQuery struct {
+	// Device selects the audit trail uploaded by the device with the given key instead of the server log.
+	Device *pki.PublicKey `json:",omitempty"`
+	// After only selects entries with a higher sequence number.
+	After   uint64
+	Since   time.Time
+	Until   time.Time
+	Type    string
+	Subject string
+	Target  string
+	Command string
+	// Limit is the maximum number of entries, the server caps it at MaxAuditQueryLimit.
+	Limit int
+}
+
+const MaxAuditQueryLimit = 1000
+
+func (q AuditQuery) Matches(entry *AuditEntry) bool {
+	event := entry.Event
+
+	switch {
+	case entry.Seq <= q.After:
+		return false
+	case !q.Since.IsZero() && event.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && event.Time.After(q.Until):
+		return false
+	case q.Type != "" && event.Type != q.Type:
+		return false
+	case q.Subject != "" && event.Subject != q.Subject:
+		return false
+	case q.Target != "" && event.Target != q.Target:
+		return false
+	case q.Command != "" && event.Command != q.Command:
+		return false
+	}
+
+	return true
+}
+
+// AuditLogPage contains the entries matching a query.
+type AuditLogPage struct {
+	Entries []*AuditEntry
+	// ChainError is set if the server found a broken link between the entries it read for the query.
+	ChainError string `json:",omitempty"`
+}
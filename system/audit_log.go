@@ -0,0 +1,427 @@
+package system
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// AuditLog appends audit events to a scope, they are never changed or removed.
+// Entries are hash chained and signed with the key of the host, see AuditEntry.
+type AuditLog struct {
+	scope db.Scope
+	// credentials returns the current credentials of the host, they change when its certificate is renewed.
+	credentials func() pki.Credentials
+}
+
+// auditHeadKey stores the sequence number and hash of the last entry.
+var auditHeadKey = []byte("head")
+
+const auditEventPrefix = "event_"
+
+type auditHead struct {
+	Seq  uint64
+	Hash []byte
+}
+
+// OpenAuditLog opens the log in the given scope.
+// credentials may be nil for logs that only import entries signed by other hosts.
+func OpenAuditLog(scope db.Scope, credentials func() pki.Credentials) (*AuditLog, error) {
+	return &AuditLog{
+		scope:       scope,
+		credentials: credentials,
+	}, nil
+}
+
+func auditEventKey(seq uint64) []byte {
+	key := make([]byte, len(auditEventPrefix)+8)
+	copy(key, auditEventPrefix)
+	binary.BigEndian.PutUint64(key[len(auditEventPrefix):], seq)
+	return key
+}
+
+func getAuditHead(b db.Bucket) (auditHead, error) {
+	head := auditHead{}
+
+	rawHead := b.Get(auditHeadKey)
+	if rawHead == nil {
+		return head, nil
+	}
+
+	err := json.Unmarshal(rawHead, &head)
+	if err != nil {
+		return head, fmt.Errorf("failed to unmarshal audit head: %w", err)
+	}
+
+	return head, nil
+}
+
+// putAuditEntry stores the entry and moves the head to it.
+func putAuditEntry(b db.Bucket, entry *AuditEntry) error {
+	hash, err := entry.Hash()
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	err = b.Put(auditEventKey(entry.Seq), raw)
+	if err != nil {
+		return err
+	}
+
+	rawHead, err := json.Marshal(auditHead{
+		Seq:  entry.Seq,
+		Hash: hash,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit head: %w", err)
+	}
+
+	return b.Put(auditHeadKey, rawHead)
+}
+
+func (a *AuditLog) append(event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	err := a.scope.Update(func(b db.Bucket) error {
+		head, err := getAuditHead(b)
+		if err != nil {
+			return err
+		}
+
+		if a.credentials == nil {
+			return fmt.Errorf("audit log can only import entries")
+		}
+
+		entry, err := NewAuditEntry(head.Seq+1, head.Hash, event, a.credentials())
+		if err != nil {
+			return err
+		}
+
+		return putAuditEntry(b, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Import appends entries signed by another host, e.g. the audit trail uploaded by an agent.
+// Entries the log already has are skipped, the others have to continue the log and be signed with pub.
+// Entries signed with one of the previous keys of the host are accepted until the first entry signed with pub,
+// they were written before the host was rekeyed.
+func (a *AuditLog) Import(entries []*AuditEntry, pub *pki.PublicKey, previous ...*pki.PublicKey) error {
+	err := a.scope.Update(func(b db.Bucket) error {
+		head, err := getAuditHead(b)
+		if err != nil {
+			return err
+		}
+
+		rotated, err := headSignedBy(b, head, pub)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.Seq <= head.Seq {
+				// already imported by an earlier upload
+				continue
+			}
+
+			if entry.Seq != head.Seq+1 || !bytes.Equal(entry.PrevHash, head.Hash) {
+				return fmt.Errorf("audit entry %d does not continue the log at %d", entry.Seq, head.Seq)
+			}
+
+			err := entry.Verify(pub)
+			if err == nil {
+				rotated = true
+			} else if rotated || !verifiesWithAny(entry, previous) {
+				return err
+			}
+
+			err = putAuditEntry(b, entry)
+			if err != nil {
+				return err
+			}
+
+			head.Seq = entry.Seq
+			head.Hash, err = entry.Hash()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// headSignedBy checks if the last entry of the log was signed with the given key.
+func headSignedBy(b db.Bucket, head auditHead, pub *pki.PublicKey) (bool, error) {
+	if head.Seq == 0 {
+		return false, nil
+	}
+
+	raw := b.Get(auditEventKey(head.Seq))
+	if raw == nil {
+		return false, fmt.Errorf("audit entry %d of the head is missing", head.Seq)
+	}
+
+	entry := &AuditEntry{}
+	err := json.Unmarshal(raw, entry)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal audit entry %d: %w", head.Seq, err)
+	}
+
+	return entry.Verify(pub) == nil, nil
+}
+
+func verifiesWithAny(entry *AuditEntry, keys []*pki.PublicKey) bool {
+	for _, key := range keys {
+		if entry.Verify(key) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Head returns the sequence number of the last entry, 0 if the log is empty.
+func (a *AuditLog) Head() (uint64, error) {
+	var seq uint64
+
+	err := a.scope.View(func(b db.Bucket) error {
+		head, err := getAuditHead(b)
+		seq = head.Seq
+		return err
+	})
+
+	return seq, err
+}
+
+// Record appends the event and only logs failures, auditing must not break the action that is audited.
+func (a *AuditLog) Record(event AuditEvent) {
+	log.Printf("audit: %s %s %s %s", event.Type, event.Subject, event.Addr, event.Detail)
+
+	err := a.append(event)
+	if err != nil {
+		log.Printf("error recording audit event: %v", err)
+	}
+}
+
+// RecordSession adds an incoming RPC session to the log.
+func (a *AuditLog) RecordSession(session rpc.SessionRecord) {
+	subject := ""
+	if session.Partner != nil {
+		subject = session.Partner.GetName()
+	}
+
+	a.Record(AuditEvent{
+		Type:     AuditRpcSession,
+		Subject:  subject,
+		Detail:   session.Summary,
+		Partner:  session.Partner,
+		Command:  session.Command,
+		Target:   session.Target,
+		Code:     session.Code,
+		Duration: session.Duration,
+	})
+}
+
+// ForEach walks the whole log in order and checks the chain on the way.
+// It stops at the first broken link and returns its error after calling fn for all entries before.
+func (a *AuditLog) ForEach(fn func(entry *AuditEntry) error) (chainErr error, err error) {
+	err = a.scope.View(func(b db.Bucket) error {
+		var prev *AuditEntry
+
+		err := b.ForPrefix([]byte(auditEventPrefix), func(k, v []byte) error {
+			if chainErr != nil {
+				return nil
+			}
+
+			entry := &AuditEntry{}
+			err := json.Unmarshal(v, entry)
+			if err != nil {
+				chainErr = fmt.Errorf("failed to unmarshal audit entry %x: %w", k, err)
+				return nil
+			}
+
+			err = entry.Follows(prev)
+			if err != nil {
+				chainErr = err
+				return nil
+			}
+
+			prev = entry
+			return fn(entry)
+		})
+		if err != nil || chainErr != nil {
+			return err
+		}
+
+		head := auditHead{}
+		rawHead := b.Get(auditHeadKey)
+		if rawHead == nil {
+			if prev != nil {
+				chainErr = fmt.Errorf("audit head is missing")
+			}
+			return nil
+		}
+
+		err = json.Unmarshal(rawHead, &head)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal audit head: %w", err)
+		}
+
+		last := uint64(0)
+		if prev != nil {
+			last = prev.Seq
+		}
+
+		if last != head.Seq {
+			chainErr = fmt.Errorf("audit log ends at entry %d, but its head is at %d", last, head.Seq)
+		}
+
+		return nil
+	})
+
+	return chainErr, err
+}
+
+func (a *AuditLog) VerifyChain() error {
+	chainErr, err := a.ForEach(func(entry *AuditEntry) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return chainErr
+}
+
+// errAuditQueryDone stops reading the log once a query has enough entries.
+var errAuditQueryDone = errors.New("audit query done")
+
+// Query seeks to the entries after query.After and reads until the limit is reached.
+// Only the links between the entries read are checked, the whole chain is checked when appending and importing.
+func (a *AuditLog) Query(query AuditQuery) (*AuditLogPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MaxAuditQueryLimit {
+		limit = MaxAuditQueryLimit
+	}
+
+	page := &AuditLogPage{
+		Entries: make([]*AuditEntry, 0),
+	}
+
+	err := a.scope.View(func(b db.Bucket) error {
+		var prev *AuditEntry
+
+		if query.After > 0 {
+			raw := b.Get(auditEventKey(query.After))
+			if raw == nil {
+				return nil
+			}
+
+			prev = &AuditEntry{}
+			err := json.Unmarshal(raw, prev)
+			if err != nil {
+				page.ChainError = fmt.Sprintf("failed to unmarshal audit entry %d: %v", query.After, err)
+				return nil
+			}
+		}
+
+		err := b.ForPrefixFrom([]byte(auditEventPrefix), auditEventKey(query.After+1), func(k, v []byte) error {
+			entry := &AuditEntry{}
+			err := json.Unmarshal(v, entry)
+			if err != nil {
+				page.ChainError = fmt.Sprintf("failed to unmarshal audit entry %x: %v", k, err)
+				return errAuditQueryDone
+			}
+
+			err = entry.Follows(prev)
+			if err != nil {
+				page.ChainError = err.Error()
+				return errAuditQueryDone
+			}
+			prev = entry
+
+			if query.Matches(entry) {
+				page.Entries = append(page.Entries, entry)
+			}
+
+			if len(page.Entries) >= limit {
+				return errAuditQueryDone
+			}
+			return nil
+		})
+		if errors.Is(err, errAuditQueryDone) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+
+	return page, nil
+}
+
+// VerifyHead checks that the head points to the last entry, without walking the chain.
+func (a *AuditLog) VerifyHead() error {
+	return a.scope.View(func(b db.Bucket) error {
+		head, err := getAuditHead(b)
+		if err != nil {
+			return err
+		}
+
+		if head.Seq == 0 {
+			return nil
+		}
+
+		raw := b.Get(auditEventKey(head.Seq))
+		if raw == nil {
+			return fmt.Errorf("audit entry %d of the head is missing", head.Seq)
+		}
+
+		entry := &AuditEntry{}
+		err = json.Unmarshal(raw, entry)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal audit entry %d: %w", head.Seq, err)
+		}
+
+		hash, err := entry.Hash()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(hash, head.Hash) {
+			return fmt.Errorf("audit head does not match entry %d", head.Seq)
+		}
+
+		if b.Get(auditEventKey(head.Seq+1)) != nil {
+			return fmt.Errorf("audit log continues after its head at %d", head.Seq)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package system_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
+)
+
+func openTestScope(t *testing.T) db.Scope {
+	store, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store.Context([]byte("test"))
+}
+
+func TestAuditLogImportAfterRekey(t *testing.T) {
+	oldKey, err := pki.GenerateRootCredentials("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := pki.GenerateRootCredentials("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var credentials pki.Credentials = oldKey
+	trail, err := system.OpenAuditLog(openTestScope(t), func() pki.Credentials {
+		return credentials
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trail.Record(system.AuditEvent{Type: system.AuditRpcSession, Subject: "before"})
+	credentials = newKey
+	trail.Record(system.AuditEvent{Type: system.AuditRpcSession, Subject: "after"})
+
+	page, err := trail.Query(system.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream, err := system.OpenAuditLog(openTestScope(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = upstream.Import(page.Entries[:1], oldKey.PublicKey())
+	if err != nil {
+		t.Fatalf("failed to import entry before rekey: %v", err)
+	}
+
+	// the agent lost its cursor and sends everything again with its new key
+	err = upstream.Import(page.Entries, newKey.PublicKey(), oldKey.PublicKey())
+	if err != nil {
+		t.Fatalf("failed to import trail after rekey: %v", err)
+	}
+
+	head, err := upstream.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if head != 2 {
+		t.Errorf("expected head at 2, got %d", head)
+	}
+
+	err = upstream.Import(page.Entries, newKey.PublicKey(), oldKey.PublicKey())
+	if err != nil {
+		t.Errorf("failed to skip entries uploaded before: %v", err)
+	}
+}
+
+func TestAuditLogImportRejectsPreviousKeyAfterRotation(t *testing.T) {
+	oldKey, err := pki.GenerateRootCredentials("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := pki.GenerateRootCredentials("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var credentials pki.Credentials = newKey
+	trail, err := system.OpenAuditLog(openTestScope(t), func() pki.Credentials {
+		return credentials
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trail.Record(system.AuditEvent{Type: system.AuditRpcSession, Subject: "after"})
+	credentials = oldKey
+	trail.Record(system.AuditEvent{Type: system.AuditRpcSession, Subject: "forged"})
+
+	page, err := trail.Query(system.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream, err := system.OpenAuditLog(openTestScope(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = upstream.Import(page.Entries[:1], newKey.PublicKey(), oldKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = upstream.Import(page.Entries, newKey.PublicKey(), oldKey.PublicKey())
+	if err == nil {
+		t.Errorf("accepted an entry signed with the previous key after the rotation")
+	}
+}
@@ -0,0 +1,135 @@
+package system
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+const getRolloverUsersKey = "get-rollover-users"
+const beginRootRolloverKey = "begin-root-rollover"
+
+// RootRolloverRequest is sent by the holder of the current root to start a rollover.
+type RootRolloverRequest struct {
+	Rollover *RootRollover
+	// Users contains the user certificates re-issued by the next root.
+	Users []*pki.Certificate
+	// EncryptedRootKey is the private key of the next root, encrypted with the password of the root user.
+	EncryptedRootKey []byte
+}
+
+type RolloverUsers struct {
+	Users []*pki.Certificate
+}
+
+// requireRoot fails unless the partner is the current root and no rollover is in progress.
+func requireRoot(anchors *TrustAnchors, partner *pki.Certificate) error {
+	if partner == nil || !partner.Equal(anchors.Root()) {
+		return fmt.Errorf("%w: only the root may start a root rollover", ErrPermissionDenied)
+	}
+
+	if anchors.Rollover() != nil {
+		return fmt.Errorf("%w: a root rollover is already in progress", rpc.ErrInvalidArgument)
+	}
+
+	return nil
+}
+
+// CreateGetRolloverUsersCommandHandler hands the current user certificates to the root,
+// it re-issues them with the next root before starting the rollover.
+func CreateGetRolloverUsersCommandHandler(anchors *TrustAnchors, listUsers func() ([]*pki.Certificate, error)) rpc.RpcCommandHandler {
+	return rpc.UnaryCommandHandler(getRolloverUsersKey, func(session *rpc.RpcSession, request *rpc.Empty) (*RolloverUsers, error) {
+		err := requireRoot(anchors, session.Partner())
+		if err != nil {
+			return nil, err
+		}
+
+		users, err := listUsers()
+		if err != nil {
+			return nil, fmt.Errorf("error listing users: %w", err)
+		}
+
+		return &RolloverUsers{
+			Users: users,
+		}, nil
+	})
+}
+
+func NewGetRolloverUsersCommand() *rpc.UnaryCommand[rpc.Empty, RolloverUsers] {
+	return rpc.NewUnaryCommand[rpc.Empty, RolloverUsers](getRolloverUsersKey, &rpc.Empty{})
+}
+
+// CreateBeginRootRolloverCommandHandler lets the root user start a rollover.
+// The request is checked completely before the rollover is confirmed.
+func CreateBeginRootRolloverCommandHandler(anchors *TrustAnchors, onBegin func(request *RootRolloverRequest) error) rpc.RpcCommandHandler {
+	return rpc.UnaryCommandHandler(beginRootRolloverKey, func(session *rpc.RpcSession, request *RootRolloverRequest) (*rpc.Empty, error) {
+		err := requireRoot(anchors, session.Partner())
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Rollover == nil || request.Rollover.RetireAt.Before(time.Now()) {
+			return nil, fmt.Errorf("%w: invalid retirement date", rpc.ErrInvalidArgument)
+		}
+
+		err = onBegin(request)
+		if err != nil {
+			return nil, fmt.Errorf("error starting root rollover: %w", err)
+		}
+
+		return &rpc.Empty{}, nil
+	})
+}
+
+// NewBeginRootRolloverCommand cross signs the next root and re-issues the upstream and the users issued by the current root.
+func NewBeginRootRolloverCommand(
+	current *pki.PermanentCredentials,
+	next *pki.PermanentCredentials,
+	upstream *pki.Certificate,
+	users []*pki.Certificate,
+	password []byte,
+	retireAt time.Time,
+) (*rpc.UnaryCommand[RootRolloverRequest, rpc.Empty], error) {
+	encryptedKey, err := next.PrivateKey().PemEncode(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	crossSigned, err := pki.CrossSignRoot(next.Certificate(), current)
+	if err != nil {
+		return nil, fmt.Errorf("error cross signing next root: %w", err)
+	}
+
+	reissuedUpstream, err := pki.ReissueCertificate(upstream, next)
+	if err != nil {
+		return nil, fmt.Errorf("error re-issuing upstream certificate: %w", err)
+	}
+
+	request := &RootRolloverRequest{
+		Rollover: &RootRollover{
+			Next:        next.Certificate(),
+			CrossSigned: crossSigned,
+			Upstream:    reissuedUpstream,
+			RetireAt:    retireAt,
+		},
+		Users:            make([]*pki.Certificate, 0, len(users)),
+		EncryptedRootKey: encryptedKey,
+	}
+
+	for _, user := range users {
+		if !user.IsIssuedBy(current.Certificate()) {
+			continue
+		}
+
+		reissued, err := pki.ReissueCertificate(user, next)
+		if err != nil {
+			return nil, fmt.Errorf("error re-issuing certificate of %s: %w", user.GetName(), err)
+		}
+
+		request.Users = append(request.Users, reissued)
+	}
+
+	return rpc.NewUnaryCommand[RootRolloverRequest, rpc.Empty](beginRootRolloverKey, request), nil
+}
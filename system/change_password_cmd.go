@@ -0,0 +1,126 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+var _ rpc.RpcCommand = (*changePasswordCommand)(nil)
+
+// PasswordChange contains everything of a user that depends on the password.
+type PasswordChange struct {
+	EncryptedPrivateKey  []byte
+	ClientHashingParams  *util.ArgonParameters
+	ServerHashingParams  *util.ArgonParameters
+	DoubleHashedPassword []byte
+}
+
+func CreateChangePasswordCommandHandler(changePassword func(partner *pki.Certificate, totp string, change *PasswordChange) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &changePasswordCommand{
+			changePassword: changePassword,
+		}
+	}
+}
+
+// changePasswordCommand replaces the password of the requesting user.
+// The private key is re-encrypted by the client, the server only sees the new password hash.
+type changePasswordCommand struct {
+	EncryptedKey        []byte
+	ClientHashingParams *util.ArgonParameters
+	PasswordHash        []byte
+	CurrentTotp         string
+	changePassword      func(partner *pki.Certificate, totp string, change *PasswordChange) error
+}
+
+func NewChangePasswordCommand(credentials *pki.PermanentCredentials, password []byte, currentTotp string) (*changePasswordCommand, error) {
+	hashingParams, err := util.GenerateArgonParameters(util.ArgonStrengthStrong)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate hashing parameters: %w", err)
+	}
+
+	hashedPassword, err := util.HashPassword(password, hashingParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	encryptedKey, err := credentials.PrivateKey().PemEncode(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	return &changePasswordCommand{
+		EncryptedKey:        encryptedKey,
+		ClientHashingParams: &hashingParams,
+		PasswordHash:        hashedPassword,
+		CurrentTotp:         currentTotp,
+	}, nil
+}
+
+func (c *changePasswordCommand) GetKey() string {
+	return "change-password"
+}
+
+func (c *changePasswordCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.ClientHashingParams == nil || c.ClientHashingParams.IsInsecure() {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Client hashing parameters are insecure",
+		})
+		return fmt.Errorf("client hashing parameters are insecure")
+	}
+
+	serverHashingParams, err := util.GenerateArgonParameters(util.ArgonStrengthDefault)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "failed to generate Argon Parameters",
+		})
+		return fmt.Errorf("failed to generate Argon Parameters: %w", err)
+	}
+
+	doubleHash, err := util.HashPassword(c.PasswordHash, serverHashingParams)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "failed to hash password",
+		})
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	err = c.changePassword(session.Partner(), c.CurrentTotp, &PasswordChange{
+		EncryptedPrivateKey:  c.EncryptedKey,
+		ClientHashingParams:  c.ClientHashingParams,
+		ServerHashingParams:  &serverHashingParams,
+		DoubleHashedPassword: doubleHash,
+	})
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error changing password: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error changing password: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *changePasswordCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
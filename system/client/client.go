@@ -2,8 +2,11 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/rahn-it/svalin/config"
 	"github.com/rahn-it/svalin/pki"
@@ -14,11 +17,14 @@ import (
 )
 
 type Client struct {
-	profile      *config.Profile
-	clientConfig *clientConfig
-	ep           *rpc.RpcEndpoint
-	devices      *util.SyncedMap[string, *rmm.Device]
-	enrollments  *util.SyncedMap[string, *rpc.Enrollment]
+	profile       *config.Profile
+	clientConfig  *clientConfig
+	ep            *rpc.RpcEndpoint
+	devices       *util.SyncedMap[string, *rmm.Device]
+	enrollments   *util.SyncedMap[string, *rpc.Enrollment]
+	renewals      *util.SyncedMap[string, *system.Renewal]
+	registrations *util.SyncedMap[string, *system.Registration]
+	verifier      pki.Verifier
 }
 
 func OpenClient(profile *config.Profile, password []byte) (*Client, error) {
@@ -33,9 +39,9 @@ func OpenClient(profile *config.Profile, password []byte) (*Client, error) {
 		return nil, fmt.Errorf("error opening revocation store: %w", err)
 	}
 
-	verifier := system.NewUpstreamVerifier(clientConfig.Upstream(), clientConfig.Root(), revocationStore)
+	verifier := system.NewUpstreamVerifier(clientConfig.Anchors(), revocationStore)
 
-	ep, err := rpc.ConnectToServer(context.Background(), clientConfig.ServerAddr(), clientConfig.Credentials(), clientConfig.Upstream(), verifier)
+	ep, err := rpc.ConnectToServer(context.Background(), clientConfig.ServerAddr(), clientConfig.Credentials(), clientConfig.Upstream(), verifier, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
@@ -110,14 +116,80 @@ func OpenClient(profile *config.Profile, password []byte) (*Client, error) {
 		},
 	)
 
+	var rRunning util.AsyncAction
+
+	renewals := util.NewSyncedMap[string, *system.Renewal](
+		func(m util.UpdateableMap[string, *system.Renewal]) {
+			cmd := system.NewGetPendingRenewalsCommand(m)
+
+			running, err := ep.SendCommand(context.Background(), cmd)
+			if err != nil {
+				log.Printf("Error subscribing to renewals: %v", err)
+				return
+			}
+
+			rRunning = running
+		},
+		func(m util.UpdateableMap[string, *system.Renewal]) {
+			err := rRunning.Close()
+			if err != nil {
+				log.Printf("Error unsubscribing from renewals: %v", err)
+			}
+		},
+	)
+
+	var regRunning util.AsyncAction
+
+	registrations := util.NewSyncedMap[string, *system.Registration](
+		func(m util.UpdateableMap[string, *system.Registration]) {
+			cmd := system.NewGetPendingRegistrationsCommand(m)
+
+			running, err := ep.SendCommand(context.Background(), cmd)
+			if err != nil {
+				log.Printf("Error subscribing to registrations: %v", err)
+				return
+			}
+
+			regRunning = running
+		},
+		func(m util.UpdateableMap[string, *system.Registration]) {
+			err := regRunning.Close()
+			if err != nil {
+				log.Printf("Error unsubscribing from registrations: %v", err)
+			}
+		},
+	)
+
 	client := &Client{
-		profile:      profile,
-		clientConfig: clientConfig,
-		ep:           ep,
-		devices:      devices,
-		enrollments:  enrollments,
+		profile:       profile,
+		clientConfig:  clientConfig,
+		ep:            ep,
+		devices:       devices,
+		enrollments:   enrollments,
+		renewals:      renewals,
+		registrations: registrations,
+		verifier:      verifier,
+	}
+
+	_, err = client.checkRootRollover()
+	if err != nil {
+		log.Printf("Error checking for root rollover: %v", err)
 	}
 
+	renewals.Subscribe(
+		func(_ string, renewal *system.Renewal) {
+			go client.autoRenew(renewal)
+		},
+		func(_ string, _ *system.Renewal) {},
+	)
+
+	registrations.Subscribe(
+		func(_ string, registration *system.Registration) {
+			go client.autoApprove(registration)
+		},
+		func(_ string, _ *system.Registration) {},
+	)
+
 	return client, nil
 }
 
@@ -137,6 +209,11 @@ func SetupClient(
 	return nil
 }
 
+// Username returns the name of the logged in user.
+func (c *Client) Username() string {
+	return c.clientConfig.Credentials().GetName()
+}
+
 func (c *Client) Devices() util.ObservableMap[string, *rmm.Device] {
 	return c.devices
 }
@@ -149,13 +226,237 @@ func (c *Client) Enrollments() util.ObservableMap[string, *rpc.Enrollment] {
 	return c.enrollments
 }
 
-func (c *Client) EnrollDevice(pub *pki.PublicKey, name string) error {
+func (c *Client) Renewals() util.ObservableMap[string, *system.Renewal] {
+	return c.renewals
+}
+
+// adminRenewDelay is how long admins leave a renewal to the issuing user.
+const adminRenewDelay = time.Minute
+
+// autoRenew re-issues certificates of devices that were enrolled by this user.
+// Devices enrolled by other users are left to them, unless the renewal policy lets admins step in.
+func (c *Client) autoRenew(renewal *system.Renewal) {
+	creds := c.clientConfig.Credentials()
+	key := renewal.Device.PublicKey().Base64Encode()
+
+	if renewal.Issuer == nil || !renewal.Issuer.PublicKey().Equal(creds.PublicKey()) {
+		if !renewal.AnyAdmin {
+			return
+		}
+
+		time.Sleep(adminRenewDelay)
+
+		if _, ok := c.renewals.Get(key); !ok {
+			return
+		}
+	}
+
+	cert, err := pki.CreateAgentCert(renewal.Device.GetName(), renewal.PublicKey, creds)
+	if err != nil {
+		log.Printf("Error creating renewed certificate: %v", err)
+		return
+	}
+
+	cmd := system.NewRenewDeviceCommand(renewal.Device.PublicKey(), cert)
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if errors.Is(err, rpc.ErrPermissionDenied) || errors.Is(err, rpc.ErrNotFound) {
+		// not an admin, or renewed by someone else in the meantime
+		return
+	}
+	if err != nil {
+		log.Printf("Error renewing device %s: %v", renewal.Device.GetName(), err)
+		return
+	}
+
+	log.Printf("Renewed certificate of device %s", renewal.Device.GetName())
+}
+
+// checkRootRollover asks the server for a root rollover and starts trusting the next root.
+// A certificate re-issued for this user is kept until the old root is retired.
+func (c *Client) checkRootRollover() (*system.RootRollover, error) {
+	cmd := system.NewGetRootRolloverCommand()
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request root rollover: %w", err)
+	}
+
+	rollover := cmd.Rollover()
+	if rollover == nil {
+		return nil, nil
+	}
+
+	err = c.clientConfig.Anchors().BeginRollover(rollover)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin root rollover: %w", err)
+	}
+
+	if cmd.Certificate() != nil {
+		err = c.clientConfig.savePendingCertificate(cmd.Certificate())
+		if err != nil {
+			return nil, fmt.Errorf("failed to save re-issued certificate: %w", err)
+		}
+	}
+
+	return rollover, nil
+}
+
+// RollOverRoot replaces the root with a newly generated one, cross signed by the current root.
+// Only the root user can do this. Both roots are trusted until the window has passed.
+func (c *Client) RollOverRoot(password []byte, window time.Duration) error {
+	current := c.clientConfig.Credentials()
+	if !current.Certificate().Equal(c.clientConfig.Root()) {
+		return errors.New("only the root user can roll over the root")
+	}
+
+	err := c.clientConfig.loadCredentials(password)
+	if err != nil {
+		return fmt.Errorf("invalid password: %w", err)
+	}
+
+	next, err := pki.GenerateRootCredentials(current.GetName())
+	if err != nil {
+		return fmt.Errorf("failed to generate root credentials: %w", err)
+	}
+
+	usersCmd := system.NewGetRolloverUsersCommand()
+	err = c.ep.SendSyncCommand(context.Background(), usersCmd)
+	if err != nil {
+		return fmt.Errorf("failed to get user certificates: %w", err)
+	}
+
+	cmd, err := system.NewBeginRootRolloverCommand(current, next, c.clientConfig.Upstream(), usersCmd.Response().Users, password, time.Now().Add(window))
+	if err != nil {
+		return fmt.Errorf("failed to create root rollover command: %w", err)
+	}
+
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to begin root rollover: %w", err)
+	}
+
+	rollover, err := c.checkRootRollover()
+	if err != nil {
+		return err
+	}
+
+	if rollover == nil || !rollover.Next.Equal(next.Certificate()) {
+		return errors.New("server did not start the root rollover")
+	}
+
+	err = c.clientConfig.saveCredentials(next, password)
+	if err != nil {
+		return fmt.Errorf("failed to save next root credentials: %w", err)
+	}
+
+	log.Printf("Root rollover started, old root will be retired at %s", rollover.RetireAt)
+
+	return nil
+}
+
+func (c *Client) Registrations() util.ObservableMap[string, *system.Registration] {
+	return c.registrations
+}
+
+// CreateInvite signs an invite for a new user.
+// If autoApprove is set, the registration is approved by this client while it is connected.
+func (c *Client) CreateInvite(username string, roles []string, validFor time.Duration, autoApprove bool) (string, error) {
+	invite, err := system.NewInvite(c.clientConfig.Credentials(), username, roles, validFor, autoApprove)
+	if err != nil {
+		return "", fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+// autoApprove issues certificates for registrations with an auto approved invite of this user.
+// The invite is checked again, the server can't issue certificates on its own.
+func (c *Client) autoApprove(registration *system.Registration) {
+	creds := c.clientConfig.Credentials()
+	if !registration.AutoApprove || !registration.Issuer.Equal(creds.Certificate()) {
+		return
+	}
+
+	invite, issuer, err := system.LoadInvite(registration.Invite, c.verifier)
+	if err != nil {
+		log.Printf("Error loading invite: %v", err)
+		return
+	}
+
+	if !issuer.Equal(creds.Certificate()) || !invite.AutoApprove || invite.Username != registration.Username {
+		log.Printf("Registration does not match invite %s, ignoring", invite.ID)
+		return
+	}
+
+	err = c.ApproveRegistration(registration)
+	if err != nil {
+		log.Printf("Error approving registration of %s: %v", registration.Username, err)
+		return
+	}
+
+	log.Printf("Approved registration of %s", registration.Username)
+}
+
+// ApproveRegistration issues the certificate for a user that registered with an invite.
+func (c *Client) ApproveRegistration(registration *system.Registration) error {
+	cert, err := pki.CreateUserCert(registration.Username, registration.PublicKey, c.clientConfig.Credentials())
+	if err != nil {
+		return fmt.Errorf("failed to create user certificate: %w", err)
+	}
+
+	cmd := system.NewApproveRegistrationCommand(cert)
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to approve registration: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) RejectRegistration(registration *system.Registration) error {
+	cmd := system.NewRejectRegistrationCommand(registration.PublicKey)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to reject registration: %w", err)
+	}
+
+	return nil
+}
+
+// CreateEnrollmentToken mints a token that lets agents enroll without interactive approval.
+// Issuing the agent certificates is delegated to a signer, whose key is handed to the server until the token expires.
+func (c *Client) CreateEnrollmentToken(namePattern string, tags []string, group string, validFor time.Duration, singleUse bool) (string, error) {
+	token, signer, err := rpc.NewEnrollmentToken(c.clientConfig.Credentials(), namePattern, tags, group, validFor, singleUse)
+	if err != nil {
+		return "", fmt.Errorf("failed to create enrollment token: %w", err)
+	}
+
+	raw, err := rpc.DecodeEnrollmentToken(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode enrollment token: %w", err)
+	}
+
+	cmd, err := system.NewRegisterEnrollmentSignerCommand(raw, signer)
+	if err != nil {
+		return "", fmt.Errorf("failed to create signer registration: %w", err)
+	}
+
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to register enrollment signer: %w", err)
+	}
+
+	return token, nil
+}
+
+// EnrollDevice issues a certificate for a waiting enrollment.
+// The code has to match the verification code shown on the agent.
+func (c *Client) EnrollDevice(pub *pki.PublicKey, name string, code string) error {
 	cert, err := pki.CreateAgentCert(name, pub, c.clientConfig.Credentials())
 	if err != nil {
 		return fmt.Errorf("failed to create agent certificate: %w", err)
 	}
 
-	cmd := system.NewEnrollDeviceCommand(cert)
+	cmd := system.NewEnrollDeviceCommand(cert, code)
 	err = c.ep.SendSyncCommand(context.Background(), cmd)
 	if err != nil {
 		return fmt.Errorf("failed to enroll device: %w", err)
@@ -163,3 +464,211 @@ func (c *Client) EnrollDevice(pub *pki.PublicKey, name string) error {
 
 	return nil
 }
+
+// RejectEnrollment drops a waiting enrollment, the agent is told the reason.
+// If block is set, the agent's address may not enroll again.
+func (c *Client) RejectEnrollment(pub *pki.PublicKey, reason string, block bool) error {
+	cmd := system.NewRejectEnrollmentCommand(pub, reason, block)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to reject enrollment: %w", err)
+	}
+
+	return nil
+}
+
+// ListUsers returns all users, it requires the admin role.
+func (c *Client) ListUsers() ([]*system.UserInfo, error) {
+	cmd := system.NewListUsersCommand()
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return cmd.Users(), nil
+}
+
+// UpdateUser disables or re-enables a user and sets its roles.
+func (c *Client) UpdateUser(pub *pki.PublicKey, disabled bool, roles []string) error {
+	cmd := system.NewUpdateUserCommand(pub, disabled, roles)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUser removes a user and revokes its certificate.
+func (c *Client) DeleteUser(pub *pki.PublicKey) error {
+	cmd := system.NewDeleteUserCommand(pub)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
+
+// ChangePassword replaces the password of the user, on the server and for the local profile.
+func (c *Client) ChangePassword(oldPassword []byte, newPassword []byte, totp string) error {
+	err := c.clientConfig.loadCredentials(oldPassword)
+	if err != nil {
+		return fmt.Errorf("invalid password: %w", err)
+	}
+
+	credentials := c.clientConfig.Credentials()
+
+	cmd, err := system.NewChangePasswordCommand(credentials, newPassword, totp)
+	if err != nil {
+		return fmt.Errorf("failed to create change password command: %w", err)
+	}
+
+	// save locally first, so a failed save can't leave the profile locked with the old password
+	err = c.clientConfig.saveCredentials(credentials, newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		restoreErr := c.clientConfig.saveCredentials(credentials, oldPassword)
+		if restoreErr != nil {
+			return fmt.Errorf("failed to change password: %w, failed to restore local credentials: %w", err, restoreErr)
+		}
+
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+
+	return nil
+}
+
+// ResetTotp allows another user to replace its TOTP secret, it requires the admin role.
+func (c *Client) ResetTotp(pub *pki.PublicKey) error {
+	cmd := system.NewResetTotpCommand(pub)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to reset totp: %w", err)
+	}
+
+	return nil
+}
+
+// ConfirmTotp replaces the TOTP secret of the user after an admin reset it.
+func (c *Client) ConfirmTotp(secret string, code string) error {
+	cmd := system.NewConfirmTotpCommand(secret, code)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to confirm totp: %w", err)
+	}
+
+	return nil
+}
+
+// QueryAuditLog returns the audit log entries matching the query, it requires the admin role.
+// The entries are checked against the key of the server or the queried device, problems are reported in the ChainError of the page.
+func (c *Client) QueryAuditLog(query system.AuditQuery) (*system.AuditLogPage, error) {
+	cmd := system.NewQueryAuditLogCommand(query)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	page := cmd.Page()
+
+	pub := c.clientConfig.Upstream().PublicKey()
+	if query.Device != nil {
+		pub = query.Device
+	}
+
+	var prev *system.AuditEntry
+	for _, entry := range page.Entries {
+		err := entry.Verify(pub)
+		if err == nil && prev != nil && entry.Seq == prev.Seq+1 {
+			err = entry.Follows(prev)
+		}
+		if err != nil {
+			page.ChainError = strings.TrimPrefix(page.ChainError+"; "+err.Error(), "; ")
+			break
+		}
+
+		prev = entry
+	}
+
+	return page, nil
+}
+
+// CommandMetrics returns how often each command was called, failed and panicked on the server, it requires the admin role.
+func (c *Client) CommandMetrics() (*system.CommandMetrics, error) {
+	cmd := system.NewGetCommandMetricsCommand()
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command metrics: %w", err)
+	}
+
+	return cmd.Response(), nil
+}
+
+// ShellRecordings lists the recorded shell sessions of a device without their data, it requires the admin role.
+func (c *Client) ShellRecordings(device *pki.PublicKey) ([]*rmm.ShellRecording, error) {
+	cmd := rmm.NewGetShellRecordingsCommand(device, "")
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shell recordings: %w", err)
+	}
+
+	return cmd.Recordings(), nil
+}
+
+// ShellRecording downloads a recorded shell session and checks it against its hash.
+func (c *Client) ShellRecording(device *pki.PublicKey, id string) (*rmm.ShellRecording, error) {
+	cmd := rmm.NewGetShellRecordingsCommand(device, id)
+	err := c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shell recording: %w", err)
+	}
+
+	recordings := cmd.Recordings()
+	if len(recordings) != 1 {
+		return nil, fmt.Errorf("shell recording %s not found", id)
+	}
+
+	err = recordings[0].Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	return recordings[0], nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user and returns the new ones.
+func (c *Client) RegenerateRecoveryCodes(totp string) ([]string, error) {
+	cmd, err := system.NewRegenerateRecoveryCodesCommand(totp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create regenerate recovery codes command: %w", err)
+	}
+
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to regenerate recovery codes: %w", err)
+	}
+
+	return cmd.RecoveryCodes, nil
+}
+
+// RenameDevice issues a new certificate with the given name for the same device key.
+// The server pushes it to the agent and revokes the old certificate.
+func (c *Client) RenameDevice(cert *pki.Certificate, name string) error {
+	newCert, err := pki.CreateAgentCert(name, cert.PublicKey(), c.clientConfig.Credentials())
+	if err != nil {
+		return fmt.Errorf("failed to create agent certificate: %w", err)
+	}
+
+	cmd := system.NewRenameDeviceCommand(newCert)
+	err = c.ep.SendSyncCommand(context.Background(), cmd)
+	if err != nil {
+		return fmt.Errorf("failed to rename device: %w", err)
+	}
+
+	return nil
+}
@@ -6,12 +6,12 @@ import (
 
 	"github.com/rahn-it/svalin/db"
 	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
 )
 
 type clientConfig struct {
 	scope       db.Scope
-	root        *pki.Certificate
-	upstream    *pki.Certificate
+	anchors     *system.TrustAnchors
 	credentials *pki.PermanentCredentials
 	serverAddr  string
 }
@@ -21,19 +21,20 @@ func openClientConfig(scope db.Scope, password []byte) (*clientConfig, error) {
 		scope: scope,
 	}
 
-	err := conf.loadRoot()
+	anchors, err := system.OpenTrustAnchors(scope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load root: %w", err)
 	}
+	conf.anchors = anchors
 
-	err = conf.loadUpstream()
+	err = conf.loadCredentials(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load upstream: %w", err)
+		return nil, fmt.Errorf("failed to load credentials: %w", err)
 	}
 
-	err = conf.loadCredentials(password)
+	err = conf.applyPendingCertificate(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load credentials: %w", err)
+		return nil, fmt.Errorf("failed to apply pending certificate: %w", err)
 	}
 
 	err = conf.loadSererAddr()
@@ -44,44 +45,16 @@ func openClientConfig(scope db.Scope, password []byte) (*clientConfig, error) {
 	return conf, nil
 }
 
-func (conf *clientConfig) loadRoot() error {
-	return conf.scope.View(func(b db.Bucket) error {
-		raw := b.Get([]byte("root"))
-		if raw == nil {
-			return errors.New("root certificate not found")
-		}
-		root, err := pki.CertificateFromPem(raw)
-		if err != nil {
-			return fmt.Errorf("failed to load root certificate: %w", err)
-		}
-
-		conf.root = root
-		return nil
-	})
-}
-
 func (conf *clientConfig) Root() *pki.Certificate {
-	return conf.root
+	return conf.anchors.Root()
 }
 
-func (conf *clientConfig) loadUpstream() error {
-	return conf.scope.View(func(b db.Bucket) error {
-		raw := b.Get([]byte("upstream"))
-		if raw == nil {
-			return errors.New("upstream certificate not found")
-		}
-		upstream, err := pki.CertificateFromPem(raw)
-		if err != nil {
-			return fmt.Errorf("failed to load upstream certificate: %w", err)
-		}
-
-		conf.upstream = upstream
-		return nil
-	})
+func (conf *clientConfig) Anchors() *system.TrustAnchors {
+	return conf.anchors
 }
 
 func (conf *clientConfig) Upstream() *pki.Certificate {
-	return conf.upstream
+	return conf.anchors.Upstream()
 }
 
 func (conf *clientConfig) loadCredentials(password []byte) error {
@@ -104,6 +77,74 @@ func (conf *clientConfig) Credentials() *pki.PermanentCredentials {
 	return conf.credentials
 }
 
+// saveCredentials replaces the stored credentials.
+func (conf *clientConfig) saveCredentials(credentials *pki.PermanentCredentials, password []byte) error {
+	raw, err := credentials.PemEncode(password)
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+
+	err = conf.scope.Update(func(b db.Bucket) error {
+		return b.Put([]byte("credentials"), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+
+	conf.credentials = credentials
+	return nil
+}
+
+// savePendingCertificate keeps a certificate re-issued by the next root until the old root is retired.
+func (conf *clientConfig) savePendingCertificate(cert *pki.Certificate) error {
+	if !cert.PublicKey().Equal(conf.credentials.PublicKey()) {
+		return errors.New("certificate does not match the own key")
+	}
+
+	return conf.scope.Update(func(b db.Bucket) error {
+		return b.Put([]byte("pending-certificate"), cert.PemEncode())
+	})
+}
+
+// applyPendingCertificate switches to the pending certificate once the old root was retired.
+func (conf *clientConfig) applyPendingCertificate(password []byte) error {
+	if conf.anchors.Rollover() != nil {
+		return nil
+	}
+
+	var cert *pki.Certificate
+	err := conf.scope.View(func(b db.Bucket) error {
+		raw := b.Get([]byte("pending-certificate"))
+		if raw == nil {
+			return nil
+		}
+
+		pending, err := pki.CertificateFromPem(raw)
+		if err != nil {
+			return fmt.Errorf("failed to load pending certificate: %w", err)
+		}
+
+		cert = pending
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if cert == nil {
+		return nil
+	}
+
+	err = conf.saveCredentials(pki.CredentialsFromCertAndKey(cert, conf.credentials.PrivateKey()), password)
+	if err != nil {
+		return err
+	}
+
+	return conf.scope.Update(func(b db.Bucket) error {
+		return b.Delete([]byte("pending-certificate"))
+	})
+}
+
 func (conf *clientConfig) loadSererAddr() error {
 	return conf.scope.View(func(b db.Bucket) error {
 		raw := b.Get([]byte("serverAddr"))
@@ -0,0 +1,94 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*completeRegistrationCommand)(nil)
+var _ rpc.AuditableCommand = (*completeRegistrationCommand)(nil)
+
+func CreateCompleteRegistrationCommandHandler(complete func(partner *pki.Certificate, key *pki.PublicKey, cert *pki.Certificate) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &completeRegistrationCommand{
+			complete: complete,
+		}
+	}
+}
+
+// completeRegistrationCommand approves a registration with the issued user certificate.
+// Without a certificate, the registration is rejected.
+type completeRegistrationCommand struct {
+	PublicKey *pki.PublicKey
+	Cert      *pki.Certificate
+	complete  func(partner *pki.Certificate, key *pki.PublicKey, cert *pki.Certificate) error
+}
+
+func NewApproveRegistrationCommand(cert *pki.Certificate) *completeRegistrationCommand {
+	return &completeRegistrationCommand{
+		PublicKey: cert.PublicKey(),
+		Cert:      cert,
+	}
+}
+
+func NewRejectRegistrationCommand(key *pki.PublicKey) *completeRegistrationCommand {
+	return &completeRegistrationCommand{
+		PublicKey: key,
+	}
+}
+
+func (c *completeRegistrationCommand) GetKey() string {
+	return "complete-registration"
+}
+
+func (c *completeRegistrationCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.PublicKey == nil || (c.Cert != nil && !c.Cert.PublicKey().Equal(c.PublicKey)) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid public key",
+		})
+		return fmt.Errorf("invalid public key")
+	}
+
+	err := c.complete(session.Partner(), c.PublicKey, c.Cert)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error completing registration: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error completing registration: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *completeRegistrationCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *completeRegistrationCommand) AuditTarget() string {
+	if c.PublicKey == nil {
+		return ""
+	}
+
+	return c.PublicKey.Base64Encode()
+}
+
+func (c *completeRegistrationCommand) AuditSummary() string {
+	return fmt.Sprintf("approved=%t", c.Cert != nil)
+}
@@ -0,0 +1,66 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*confirmTotpCommand)(nil)
+
+func CreateConfirmTotpCommandHandler(confirmTotp func(partner *pki.Certificate, secret string, code string) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &confirmTotpCommand{
+			confirmTotp: confirmTotp,
+		}
+	}
+}
+
+// confirmTotpCommand sets a new TOTP secret for the requesting user after an admin reset it.
+type confirmTotpCommand struct {
+	TotpSecret  string
+	CurrentTotp string
+	confirmTotp func(partner *pki.Certificate, secret string, code string) error
+}
+
+func NewConfirmTotpCommand(totpSecret string, currentTotp string) *confirmTotpCommand {
+	return &confirmTotpCommand{
+		TotpSecret:  totpSecret,
+		CurrentTotp: currentTotp,
+	}
+}
+
+func (c *confirmTotpCommand) GetKey() string {
+	return "confirm-totp"
+}
+
+func (c *confirmTotpCommand) ExecuteServer(session *rpc.RpcSession) error {
+	err := c.confirmTotp(session.Partner(), c.TotpSecret, c.CurrentTotp)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error confirming totp: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error confirming totp: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *confirmTotpCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*deleteUserCommand)(nil)
+var _ rpc.AuditableCommand = (*deleteUserCommand)(nil)
+
+func CreateDeleteUserCommandHandler(deleteUser func(partner *pki.Certificate, key *pki.PublicKey) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &deleteUserCommand{
+			deleteUser: deleteUser,
+		}
+	}
+}
+
+// deleteUserCommand removes a user and revokes its certificate.
+// Devices enrolled by the user are issued by that certificate and lose access as well.
+type deleteUserCommand struct {
+	PublicKey  *pki.PublicKey
+	deleteUser func(partner *pki.Certificate, key *pki.PublicKey) error
+}
+
+func NewDeleteUserCommand(key *pki.PublicKey) *deleteUserCommand {
+	return &deleteUserCommand{
+		PublicKey: key,
+	}
+}
+
+func (c *deleteUserCommand) GetKey() string {
+	return "delete-user"
+}
+
+func (c *deleteUserCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.PublicKey == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Missing public key",
+		})
+		return fmt.Errorf("missing public key")
+	}
+
+	err := c.deleteUser(session.Partner(), c.PublicKey)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *deleteUserCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *deleteUserCommand) AuditTarget() string {
+	if c.PublicKey == nil {
+		return ""
+	}
+
+	return c.PublicKey.Base64Encode()
+}
+
+func (c *deleteUserCommand) AuditSummary() string {
+	return ""
+}
@@ -1,12 +1,48 @@
 package system
 
-import "github.com/rahn-it/svalin/pki"
+import (
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
 
 type DeviceInfo struct {
 	Certificate *pki.Certificate
+	Attributes  DeviceAttributes
 	LiveInfo    LiveDeviceInfo
 }
 
+// DeviceAttributes are assigned to a device on enrollment to organize devices.
+type DeviceAttributes struct {
+	Tags  []string `json:",omitempty"`
+	Group string   `json:",omitempty"`
+}
+
 type LiveDeviceInfo struct {
 	Online bool
+	// Peer is what the agent told about its version and commands when it connected.
+	Peer *rpc.PeerInfo `json:",omitempty"`
+	// ClockOffset is how far the clock of the agent was ahead of the server's when it connected.
+	ClockOffset time.Duration `json:",omitempty"`
+	// ClockWarning is set if the clock of the agent is drifting.
+	ClockWarning string `json:",omitempty"`
+}
+
+// NewLiveDeviceInfo describes an agent connected to the server.
+func NewLiveDeviceInfo(conn *rpc.RpcConnection) LiveDeviceInfo {
+	offset := conn.ClockOffset()
+
+	info := LiveDeviceInfo{
+		Online:      true,
+		Peer:        conn.Peer(),
+		ClockOffset: offset,
+	}
+
+	if offset > rpc.ClockDriftWarning || offset < -rpc.ClockDriftWarning {
+		info.ClockWarning = fmt.Sprintf("the clock of the device is off by %s", offset.Round(time.Second))
+	}
+
+	return info
 }
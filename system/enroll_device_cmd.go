@@ -1,6 +1,7 @@
 package system
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -8,7 +9,9 @@ import (
 	"github.com/rahn-it/svalin/rpc"
 )
 
-func CreateEnrollDeviceCommandHandler(enrollmentManager rpc.EnrollmentManager, verifier pki.Verifier, onSuccess func(cert *pki.Certificate) error) rpc.RpcCommandHandler {
+var _ rpc.AuditableCommand = (*enrollDeviceCommand)(nil)
+
+func CreateEnrollDeviceCommandHandler(enrollmentManager rpc.EnrollmentManager, verifier pki.Verifier, onSuccess func(cert *pki.Certificate, enrollment *rpc.Enrollment) error) rpc.RpcCommandHandler {
 	return func() rpc.RpcCommand {
 		return &enrollDeviceCommand{
 			enrollmentManager: enrollmentManager,
@@ -20,14 +23,18 @@ func CreateEnrollDeviceCommandHandler(enrollmentManager rpc.EnrollmentManager, v
 
 type enrollDeviceCommand struct {
 	Cert              *pki.Certificate
+	Code              string
 	enrollmentManager rpc.EnrollmentManager
 	verfifier         pki.Verifier
-	onSuccess         func(cert *pki.Certificate) error
+	onSuccess         func(cert *pki.Certificate, enrollment *rpc.Enrollment) error
 }
 
-func NewEnrollDeviceCommand(cert *pki.Certificate) *enrollDeviceCommand {
+// NewEnrollDeviceCommand approves a waiting enrollment.
+// The code is the verification code shown by the agent, it may be empty for enrollments with a token.
+func NewEnrollDeviceCommand(cert *pki.Certificate, code string) *enrollDeviceCommand {
 	return &enrollDeviceCommand{
 		Cert: cert,
+		Code: code,
 	}
 }
 
@@ -45,7 +52,14 @@ func (c *enrollDeviceCommand) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("invalid certificate: %w", err)
 	}
 
-	err = c.enrollmentManager.AcceptEnrollment(c.Cert)
+	enrollment, err := c.enrollmentManager.AcceptEnrollment(c.Cert, c.Code)
+	if errors.Is(err, rpc.ErrInvalidVerificationCode) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Invalid verification code",
+		})
+		return fmt.Errorf("error accepting enrollment: %w", err)
+	}
 	if err != nil {
 		session.WriteResponseHeader(rpc.SessionResponseHeader{
 			Code: 500,
@@ -54,7 +68,7 @@ func (c *enrollDeviceCommand) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("error accepting enrollment: %w", err)
 	}
 
-	err = c.onSuccess(c.Cert)
+	err = c.onSuccess(c.Cert, enrollment)
 	if err != nil {
 		session.WriteResponseHeader(rpc.SessionResponseHeader{
 			Code: 500,
@@ -75,3 +89,19 @@ func (c *enrollDeviceCommand) ExecuteClient(session *rpc.RpcSession) error {
 	log.Printf("sending enrollment successful")
 	return nil
 }
+
+func (c *enrollDeviceCommand) AuditTarget() string {
+	if c.Cert == nil {
+		return ""
+	}
+
+	return c.Cert.GetName()
+}
+
+func (c *enrollDeviceCommand) AuditSummary() string {
+	if c.Cert == nil {
+		return ""
+	}
+
+	return "key=" + c.Cert.PublicKey().Base64Encode()
+}
@@ -0,0 +1,32 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+const getCommandMetricsKey = "get-command-metrics"
+
+// CommandMetrics counts the sessions the server handled since it started, by command.
+// Sessions refused before their command was known are counted with an empty key.
+type CommandMetrics struct {
+	Commands map[string]rpc.CommandStats
+}
+
+// CreateGetCommandMetricsCommandHandler lets admins see how often commands failed or panicked on the server.
+func CreateGetCommandMetricsCommandHandler(getMetrics func(partner *pki.Certificate) (*CommandMetrics, error)) rpc.RpcCommandHandler {
+	return rpc.UnaryCommandHandler(getCommandMetricsKey, func(session *rpc.RpcSession, request *rpc.Empty) (*CommandMetrics, error) {
+		metrics, err := getMetrics(session.Partner())
+		if err != nil {
+			return nil, fmt.Errorf("error getting command metrics: %w", err)
+		}
+
+		return metrics, nil
+	})
+}
+
+func NewGetCommandMetricsCommand() *rpc.UnaryCommand[rpc.Empty, CommandMetrics] {
+	return rpc.NewUnaryCommand[rpc.Empty, CommandMetrics](getCommandMetricsKey, &rpc.Empty{})
+}
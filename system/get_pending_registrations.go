@@ -0,0 +1,30 @@
+package system
+
+import (
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+var _ rpc.RpcCommand = (*getPendingRegistrationsCommand)(nil)
+
+func CreateGetPendingRegistrationsCommandHandler(sourceMap util.ObservableMap[string, *Registration]) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		cmd := NewGetPendingRegistrationsCommand(nil)
+		cmd.SetSourceMap(sourceMap)
+		return cmd
+	}
+}
+
+type getPendingRegistrationsCommand struct {
+	*SyncDownCommand[string, *Registration]
+}
+
+func NewGetPendingRegistrationsCommand(targetMap util.UpdateableMap[string, *Registration]) *getPendingRegistrationsCommand {
+	return &getPendingRegistrationsCommand{
+		SyncDownCommand: NewSyncDownCommand[string, *Registration](targetMap),
+	}
+}
+
+func (c *getPendingRegistrationsCommand) GetKey() string {
+	return "get-pending-registrations"
+}
@@ -0,0 +1,30 @@
+package system
+
+import (
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+var _ rpc.RpcCommand = (*getPendingRenewalsCommand)(nil)
+
+func CreateGetPendingRenewalsCommandHandler(sourceMap util.ObservableMap[string, *Renewal]) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		cmd := NewGetPendingRenewalsCommand(nil)
+		cmd.SetSourceMap(sourceMap)
+		return cmd
+	}
+}
+
+type getPendingRenewalsCommand struct {
+	*SyncDownCommand[string, *Renewal]
+}
+
+func NewGetPendingRenewalsCommand(targetMap util.UpdateableMap[string, *Renewal]) *getPendingRenewalsCommand {
+	return &getPendingRenewalsCommand{
+		SyncDownCommand: NewSyncDownCommand[string, *Renewal](targetMap),
+	}
+}
+
+func (c *getPendingRenewalsCommand) GetKey() string {
+	return "get-pending-renewals"
+}
@@ -0,0 +1,44 @@
+package system
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+const getRevocationsKey = "get-revocations"
+
+type GetRevocationsRequest struct {
+	// Since is the unix time of the newest revocation the partner already knows.
+	Since int64
+}
+
+type RevocationList struct {
+	Revocations []Revocation
+}
+
+// CreateGetRevocationsCommandHandler hands out the revocations of the upstream,
+// so hosts reject revoked certificates as well.
+func CreateGetRevocationsCommandHandler(revocations func(partner *pki.Certificate, since time.Time) ([]Revocation, error)) rpc.RpcCommandHandler {
+	return rpc.UnaryCommandHandler(getRevocationsKey, func(session *rpc.RpcSession, request *GetRevocationsRequest) (*RevocationList, error) {
+		list, err := revocations(session.Partner(), time.Unix(request.Since, 0))
+		if err != nil {
+			return nil, fmt.Errorf("error getting revocations: %w", err)
+		}
+
+		return &RevocationList{
+			Revocations: list,
+		}, nil
+	})
+}
+
+func NewGetRevocationsCommand(since time.Time) *rpc.UnaryCommand[GetRevocationsRequest, RevocationList] {
+	request := &GetRevocationsRequest{}
+	if !since.IsZero() {
+		request.Since = since.Unix()
+	}
+
+	return rpc.NewUnaryCommand[GetRevocationsRequest, RevocationList](getRevocationsKey, request)
+}
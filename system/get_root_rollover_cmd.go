@@ -0,0 +1,86 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*getRootRolloverCommand)(nil)
+
+// CreateGetRootRolloverCommandHandler hands out the root rollover in progress.
+// Besides the rollover, the partner receives its own re-issued certificate if there is one.
+func CreateGetRootRolloverCommandHandler(getRollover func(partner *pki.Certificate) (*RootRollover, *pki.Certificate, error)) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &getRootRolloverCommand{
+			getRollover: getRollover,
+		}
+	}
+}
+
+type rootRolloverResponse struct {
+	Rollover    *RootRollover
+	Certificate *pki.Certificate
+}
+
+type getRootRolloverCommand struct {
+	getRollover func(partner *pki.Certificate) (*RootRollover, *pki.Certificate, error)
+	response    rootRolloverResponse
+}
+
+func NewGetRootRolloverCommand() *getRootRolloverCommand {
+	return &getRootRolloverCommand{}
+}
+
+func (c *getRootRolloverCommand) GetKey() string {
+	return "get-root-rollover"
+}
+
+func (c *getRootRolloverCommand) ExecuteServer(session *rpc.RpcSession) error {
+	rollover, cert, err := c.getRollover(session.Partner())
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error getting root rollover: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	err = rpc.WriteMessage[rootRolloverResponse](session, rootRolloverResponse{
+		Rollover:    rollover,
+		Certificate: cert,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+
+	return nil
+}
+
+func (c *getRootRolloverCommand) ExecuteClient(session *rpc.RpcSession) error {
+	err := rpc.ReadMessage[*rootRolloverResponse](session, &c.response)
+	if err != nil {
+		return fmt.Errorf("error reading message: %w", err)
+	}
+
+	return nil
+}
+
+// Rollover returns the received rollover, or nil if none is in progress.
+func (c *getRootRolloverCommand) Rollover() *RootRollover {
+	return c.response.Rollover
+}
+
+// Certificate returns the re-issued certificate of the requesting host, if any.
+func (c *getRootRolloverCommand) Certificate() *pki.Certificate {
+	return c.response.Certificate
+}
@@ -68,3 +68,63 @@ func SaveHostCredentials(b db.Bucket, credentials *pki.PermanentCredentials) err
 
 	return nil
 }
+
+var pendingHostPasswordKey = []byte("pending-host-password")
+var pendingHostKeyKey = []byte("pending-host-key")
+
+// SavePendingHostKey stores a key that is waiting for its certificate.
+// It uses its own password, so replacing the host credentials doesn't invalidate it.
+func SavePendingHostKey(b db.Bucket, key *pki.PrivateKey) error {
+	password, err := util.GeneratePassword()
+	if err != nil {
+		return fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	raw, err := key.PemEncode(password)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	err = b.Put(pendingHostPasswordKey, password)
+	if err != nil {
+		return fmt.Errorf("failed to save password: %w", err)
+	}
+
+	err = b.Put(pendingHostKeyKey, raw)
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+
+	return nil
+}
+
+// LoadPendingHostKey returns the key waiting for its certificate.
+// If there is none, nil is returned without an error.
+func LoadPendingHostKey(b db.Bucket) (*pki.PrivateKey, error) {
+	password := b.Get(pendingHostPasswordKey)
+	raw := b.Get(pendingHostKeyKey)
+	if password == nil || raw == nil {
+		return nil, nil
+	}
+
+	key, err := pki.PrivateKeyFromPem(raw, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	return key, nil
+}
+
+func DeletePendingHostKey(b db.Bucket) error {
+	err := b.Delete(pendingHostPasswordKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete password: %w", err)
+	}
+
+	err = b.Delete(pendingHostKeyKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+
+	return nil
+}
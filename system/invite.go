@@ -0,0 +1,131 @@
+package system
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/util"
+)
+
+// Invite allows someone to register as a user without the admin being present.
+// It is signed by the admin, whose client issues the user certificate once the invite was redeemed.
+// User certificates are CAs themselves, so signing them is never delegated to the server.
+type Invite struct {
+	ID       string
+	Username string
+	Roles    []string `json:",omitempty"`
+	Expires  time.Time
+	// AutoApprove lets the client of the admin issue the certificate without asking.
+	AutoApprove bool
+}
+
+// Registration is a redeemed invite waiting for its user certificate.
+type Registration struct {
+	Username    string
+	PublicKey   *pki.PublicKey
+	Invite      []byte
+	Issuer      *pki.Certificate
+	AutoApprove bool
+	RequestTime time.Time
+}
+
+// PendingRegistration is everything a redeemed invite leaves on the server until it is approved.
+type PendingRegistration struct {
+	Registration         *Registration
+	Roles                []string `json:",omitempty"`
+	EncryptedPrivateKey  []byte
+	ClientHashingParams  *util.ArgonParameters
+	ServerHashingParams  *util.ArgonParameters
+	DoubleHashedPassword []byte
+	TotpSecret           string
+}
+
+// NewInvite signs an invite and returns it in a form that can be passed to the new user.
+func NewInvite(credentials *pki.PermanentCredentials, username string, roles []string, validFor time.Duration, autoApprove bool) (string, error) {
+	err := CheckRoles(roles)
+	if err != nil {
+		return "", err
+	}
+
+	invite := &Invite{
+		ID:          uuid.NewString(),
+		Username:    username,
+		Roles:       roles,
+		Expires:     time.Now().Add(validFor),
+		AutoApprove: autoApprove,
+	}
+
+	payload, err := json.Marshal(invite)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal invite: %w", err)
+	}
+
+	blob, err := pki.NewSignedBlob(credentials, payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign invite: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(blob.Raw()), nil
+}
+
+// DecodeInvite turns an invite passed to a new user back into its signed form.
+func DecodeInvite(encoded string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode invite: %w", err)
+	}
+
+	return raw, nil
+}
+
+// InviteUsername reads the username from an invite without verifying it.
+// Only the server can verify invites, the new user does not know the root yet.
+func InviteUsername(raw []byte) (string, error) {
+	invite, _, err := LoadInvite(raw, pki.NewNilVerifier())
+	if err != nil {
+		return "", err
+	}
+
+	return invite.Username, nil
+}
+
+// LoadInvite verifies the signature of an invite and returns it together with its issuer.
+// Expired invites are rejected.
+func LoadInvite(raw []byte, verifier pki.Verifier) (*Invite, *pki.Certificate, error) {
+	blob, err := pki.LoadSignedBlob(raw, verifier)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to verify invite: %w", err)
+	}
+
+	issuer := blob.Creator()
+	if issuer.Type() != pki.CertTypeUser && issuer.Type() != pki.CertTypeRoot {
+		return nil, nil, fmt.Errorf("invite was not issued by a user")
+	}
+
+	invite := &Invite{}
+	err = json.Unmarshal(blob.Payload(), invite)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal invite: %w", err)
+	}
+
+	if time.Now().After(invite.Expires) {
+		return nil, nil, fmt.Errorf("invite expired at %s", invite.Expires.Format(time.RFC3339))
+	}
+
+	// invites signed before roles were required may have none, their users start as viewers
+	if len(invite.Roles) == 0 {
+		invite.Roles = []string{RoleViewer}
+	}
+
+	err = CheckRoles(invite.Roles)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid roles in invite: %w", err)
+	}
+
+	return invite, issuer, nil
+}
@@ -0,0 +1,80 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*listUsersCommand)(nil)
+
+func CreateListUsersCommandHandler(listUsers func(partner *pki.Certificate) ([]*UserInfo, error)) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &listUsersCommand{
+			listUsers: listUsers,
+		}
+	}
+}
+
+type listUsersCommand struct {
+	listUsers func(partner *pki.Certificate) ([]*UserInfo, error)
+	users     []*UserInfo
+}
+
+func NewListUsersCommand() *listUsersCommand {
+	return &listUsersCommand{}
+}
+
+func (c *listUsersCommand) GetKey() string {
+	return "list-users"
+}
+
+func (c *listUsersCommand) ExecuteServer(session *rpc.RpcSession) error {
+	users, err := c.listUsers(session.Partner())
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error listing users: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error listing users: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	err = rpc.WriteMessage[[]*UserInfo](session, users)
+	if err != nil {
+		return fmt.Errorf("error writing users: %w", err)
+	}
+
+	return nil
+}
+
+func (c *listUsersCommand) ExecuteClient(session *rpc.RpcSession) error {
+	c.users = make([]*UserInfo, 0)
+	err := rpc.ReadMessage[*[]*UserInfo](session, &c.users)
+	if err != nil {
+		return fmt.Errorf("error reading users: %w", err)
+	}
+
+	return nil
+}
+
+// Users returns the received users.
+func (c *listUsersCommand) Users() []*UserInfo {
+	return c.users
+}
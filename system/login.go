@@ -1,8 +1,13 @@
 package system
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/rahn-it/svalin/pki"
 	"github.com/rahn-it/svalin/rpc"
@@ -16,10 +21,21 @@ type User struct {
 	ServerHashingParams  *util.ArgonParameters
 	DoubleHashedPassword []byte
 	TotpSecret           string
+	// NextCertificate is the certificate re-issued by the next root during a root rollover.
+	NextCertificate *pki.Certificate `json:",omitempty"`
+	// Disabled users can neither log in nor connect.
+	Disabled bool     `json:",omitempty"`
+	Roles    []string `json:",omitempty"`
+	// TotpResetUntil is set when an admin allowed the user to replace its TOTP secret.
+	TotpResetUntil time.Time
+	// RecoveryCodes can be used instead of a TOTP code, each only once.
+	RecoveryCodes []RecoveryCode `json:",omitempty"`
 }
 
 type loginParameterRequest struct {
 	Username string
+	// Invite is set by new users, they register instead of logging in.
+	Invite []byte `json:",omitempty"`
 }
 
 type loginParameters struct {
@@ -38,11 +54,32 @@ type loginSuccessResponse struct {
 	EncryptedPrivateKey []byte
 }
 
+// ErrTotpReplay is returned if a TOTP code was already used.
+var ErrTotpReplay = errors.New("totp code was already used")
+
+// LoginGuard protects logins against guessing passwords and TOTP codes.
+type LoginGuard interface {
+	// Check returns how long the attempt has to be delayed, it fails if the user or address is locked out.
+	Check(username string, addr net.Addr) (time.Duration, error)
+	Failed(username string, addr net.Addr) error
+	Succeeded(username string, addr net.Addr) error
+	// UseTotp fails with ErrTotpReplay if a code of the given time step or a later one was already used.
+	UseTotp(username string, step uint64) error
+}
+
 type loginRequestHandler struct {
 	getUser  func(string) (*User, error)
-	seed     []byte
-	root     *pki.Certificate
-	upstream *pki.Certificate
+	register func(invite []byte, pending *PendingRegistration) error
+	// useRecoveryCode is nil if recovery codes are not accepted.
+	useRecoveryCode func(username string, id string) error
+	guard           LoginGuard
+	audit           func(AuditEvent)
+	totpSkew        uint
+	seed            []byte
+	root            *pki.Certificate
+	upstream        *pki.Certificate
+	// mutex guards root and upstream, they change when the root is retired.
+	mutex sync.Mutex
 }
 
 func NewLoginHandler(getUser func(string) (*User, error), seed []byte, root *pki.Certificate, upstream *pki.Certificate) *loginRequestHandler {
@@ -54,6 +91,102 @@ func NewLoginHandler(getUser func(string) (*User, error), seed []byte, root *pki
 	}
 }
 
+// UpdateRoot replaces the certificates handed out to logged in users.
+func (h *loginRequestHandler) UpdateRoot(root *pki.Certificate, upstream *pki.Certificate) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.root = root
+	h.upstream = upstream
+}
+
+// HandleInvites lets new users register with an invite.
+// The registration is passed on after the key and password of the new user were checked.
+func (h *loginRequestHandler) HandleInvites(register func(invite []byte, pending *PendingRegistration) error) {
+	h.register = register
+}
+
+// Guard throttles login attempts and records failures with audit.
+func (h *loginRequestHandler) Guard(guard LoginGuard, audit func(AuditEvent)) {
+	h.guard = guard
+	h.audit = audit
+}
+
+// TotpSkew sets how many periods a TOTP code may be off, the default is 0.
+func (h *loginRequestHandler) TotpSkew(skew uint) {
+	h.totpSkew = skew
+}
+
+func (h *loginRequestHandler) recordEvent(eventType string, username string, addr net.Addr, detail string) {
+	if h.audit == nil {
+		return
+	}
+
+	h.audit(AuditEvent{
+		Time:    time.Now(),
+		Type:    eventType,
+		Subject: username,
+		Addr:    addr.String(),
+		Detail:  detail,
+	})
+}
+
+// loginFailed counts the failure and returns the error for the login.
+func (h *loginRequestHandler) loginFailed(username string, addr net.Addr, reason error) error {
+	h.recordEvent(AuditLoginFailed, username, addr, reason.Error())
+
+	if h.guard != nil {
+		err := h.guard.Failed(username, addr)
+		if err != nil {
+			log.Printf("error counting failed login: %v", err)
+		}
+	}
+
+	return reason
+}
+
+// HandleRecoveryCodes accepts recovery codes instead of TOTP codes.
+// use has to remove the recovery code with the given ID and fail if it was already removed.
+func (h *loginRequestHandler) HandleRecoveryCodes(use func(username string, id string) error) {
+	h.useRecoveryCode = use
+}
+
+func (h *loginRequestHandler) checkSecondFactor(user *User, addr net.Addr, code string) error {
+	username := user.Certificate.GetName()
+
+	if h.useRecoveryCode != nil && IsRecoveryCode(code) {
+		id, err := user.VerifyRecoveryCode(code)
+		if err != nil {
+			return err
+		}
+
+		err = h.useRecoveryCode(username, id)
+		if err != nil {
+			return fmt.Errorf("error using recovery code: %w", err)
+		}
+
+		h.recordEvent(AuditRecoveryCodeUsed, username, addr, id)
+		return nil
+	}
+
+	step, ok := util.ValidateTotpStep(user.TotpSecret, code, h.totpSkew)
+	if !ok {
+		return fmt.Errorf("error validating totp")
+	}
+
+	if h.guard != nil {
+		err := h.guard.UseTotp(username, step)
+		if err != nil {
+			if errors.Is(err, ErrTotpReplay) {
+				h.recordEvent(AuditTotpReplay, username, addr, "")
+			}
+			return fmt.Errorf("error using totp: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (h *loginRequestHandler) HandleLoginRequest(session *rpc.RpcSession) error {
 
 	// read the parameter request for the username
@@ -69,8 +202,27 @@ func (h *loginRequestHandler) HandleLoginRequest(session *rpc.RpcSession) error
 
 	username := paramsRequest.Username
 
+	if len(paramsRequest.Invite) > 0 {
+		return h.handleInvite(session, paramsRequest)
+	}
+
 	log.Printf("Received params request with username: %s\n", username)
 
+	addr := session.RemoteAddr()
+
+	if h.guard != nil {
+		delay, err := h.guard.Check(username, addr)
+		if err != nil {
+			h.recordEvent(AuditLoginFailed, username, addr, err.Error())
+			return fmt.Errorf("login rejected: %w", err)
+		}
+
+		if delay > 0 {
+			log.Printf("Delaying login of %s from %s by %s", username, addr, delay)
+			time.Sleep(delay)
+		}
+	}
+
 	// check if the user exists
 
 	failed := false
@@ -78,7 +230,7 @@ func (h *loginRequestHandler) HandleLoginRequest(session *rpc.RpcSession) error
 	user, err := h.getUser(username)
 	if err != nil {
 		failed = true
-		log.Printf("failed to retrieve user for login: %w", err)
+		log.Printf("failed to retrieve user for login: %v", err)
 	}
 
 	// return the client hashing parameters, return a decoy if the user does not exist
@@ -115,28 +267,46 @@ func (h *loginRequestHandler) HandleLoginRequest(session *rpc.RpcSession) error
 
 	if failed {
 		util.HashPassword(login.PasswordHash, clientHashing)
-		return fmt.Errorf("user does not exist")
+		return h.loginFailed(username, addr, fmt.Errorf("user does not exist"))
+	}
+
+	// disabled users fail like unknown ones, before their password or code is checked
+	if user.Disabled {
+		util.HashPassword(login.PasswordHash, *user.ServerHashingParams)
+		return h.loginFailed(username, addr, fmt.Errorf("user is disabled"))
 	}
 
 	// check the password hash
 	err = util.VerifyPassword(login.PasswordHash, user.DoubleHashedPassword, *user.ServerHashingParams)
 	if err != nil {
-		return fmt.Errorf("error verifying password: %w", err)
+		return h.loginFailed(username, addr, fmt.Errorf("error verifying password: %w", err))
+	}
+
+	// check the totp or recovery code, every code is only accepted once
+	err = h.checkSecondFactor(user, addr, login.Totp)
+	if err != nil {
+		return h.loginFailed(username, addr, err)
 	}
 
-	// check the totp code
-	if !util.ValidateTotp(user.TotpSecret, login.Totp) {
-		return fmt.Errorf("error validating totp: %w", err)
+	if h.guard != nil {
+		err = h.guard.Succeeded(username, addr)
+		if err != nil {
+			log.Printf("error resetting failed logins: %v", err)
+		}
 	}
 
+	h.recordEvent(AuditLoginSucceeded, username, addr, "")
+
 	// login successful, return the certificate and encrypted private key
 
+	h.mutex.Lock()
 	success := &loginSuccessResponse{
 		RootCert:            h.root,
 		UpstreamCert:        h.upstream,
 		Cert:                user.Certificate,
 		EncryptedPrivateKey: user.EncryptedPrivateKey,
 	}
+	h.mutex.Unlock()
 
 	err = rpc.WriteMessage[*loginSuccessResponse](session, success)
 	if err != nil {
@@ -226,3 +396,223 @@ func (e *loginExecutor) Login(session *rpc.RpcSession) error {
 
 	return nil
 }
+
+// certificateRequest is signed with the key of a new user to prove its possession.
+type certificateRequest struct {
+	Username string
+	Invite   []byte
+}
+
+type inviteRegistration struct {
+	PublicKey           *pki.PublicKey
+	CertificateRequest  []byte
+	EncryptedKey        []byte
+	ClientHashingParams *util.ArgonParameters
+	PasswordHash        []byte
+	TotpSecret          string
+	CurrentTotp         string
+}
+
+type inviteRegistrationResponse struct {
+	RequestTime time.Time
+	// Error tells the new user why the registration was rejected.
+	Error string `json:",omitempty"`
+}
+
+// handleInvite registers a new user with an invite and always answers, so the client does not wait for nothing.
+// Only rejections of the registration itself are explained, other errors are answered with a generic message.
+func (h *loginRequestHandler) handleInvite(session *rpc.RpcSession, paramsRequest loginParameterRequest) error {
+	requestTime, err := h.redeemInvite(session, paramsRequest)
+
+	response := &inviteRegistrationResponse{
+		RequestTime: requestTime,
+	}
+
+	if err != nil {
+		response.Error = "registration failed"
+		if errors.Is(err, rpc.ErrInvalidArgument) || errors.Is(err, rpc.ErrPermissionDenied) {
+			response.Error = err.Error()
+		}
+	}
+
+	writeErr := rpc.WriteMessage[*inviteRegistrationResponse](session, response)
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("error writing registration response: %w", writeErr)
+	}
+
+	session.Close()
+	return nil
+}
+
+func (h *loginRequestHandler) redeemInvite(session *rpc.RpcSession, paramsRequest loginParameterRequest) (time.Time, error) {
+	if h.register == nil {
+		return time.Time{}, fmt.Errorf("%w: invites are not accepted", rpc.ErrInvalidArgument)
+	}
+
+	log.Printf("Received invite for user %s", paramsRequest.Username)
+
+	registration := inviteRegistration{}
+	err := rpc.ReadMessage[*inviteRegistration](session, &registration)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading registration: %w", err)
+	}
+
+	if registration.PublicKey == nil {
+		return time.Time{}, fmt.Errorf("%w: registration is missing the public key", rpc.ErrInvalidArgument)
+	}
+
+	request := certificateRequest{}
+	err = pki.UnmarshalAndVerify(registration.CertificateRequest, &request, registration.PublicKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid certificate request", rpc.ErrInvalidArgument)
+	}
+
+	if request.Username != paramsRequest.Username || !bytes.Equal(request.Invite, paramsRequest.Invite) {
+		return time.Time{}, fmt.Errorf("%w: certificate request does not match the invite", rpc.ErrInvalidArgument)
+	}
+
+	if registration.ClientHashingParams == nil || registration.ClientHashingParams.IsInsecure() {
+		return time.Time{}, fmt.Errorf("%w: client hashing parameters are insecure", rpc.ErrInvalidArgument)
+	}
+
+	step, ok := util.ValidateTotpStep(registration.TotpSecret, registration.CurrentTotp, h.totpSkew)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: invalid TOTP code", rpc.ErrInvalidArgument)
+	}
+
+	if h.guard != nil {
+		err = h.guard.UseTotp(paramsRequest.Username, step)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: invalid TOTP code", rpc.ErrInvalidArgument)
+		}
+	}
+
+	serverHashingParams, err := util.GenerateArgonParameters(util.ArgonStrengthDefault)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to generate Argon Parameters: %w", err)
+	}
+
+	doubleHash, err := util.HashPassword(registration.PasswordHash, serverHashingParams)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	requestTime := time.Now()
+
+	err = h.register(paramsRequest.Invite, &PendingRegistration{
+		Registration: &Registration{
+			Username:    paramsRequest.Username,
+			PublicKey:   registration.PublicKey,
+			Invite:      paramsRequest.Invite,
+			RequestTime: requestTime,
+		},
+		EncryptedPrivateKey:  registration.EncryptedKey,
+		ClientHashingParams:  registration.ClientHashingParams,
+		ServerHashingParams:  &serverHashingParams,
+		DoubleHashedPassword: doubleHash,
+		TotpSecret:           registration.TotpSecret,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error registering user: %w", err)
+	}
+
+	return requestTime, nil
+}
+
+type inviteExecutor struct {
+	invite     []byte
+	username   string
+	password   []byte
+	totpSecret string
+	totpCode   string
+}
+
+// NewInviteExecutor registers a new user with an invite over the login protocol.
+// The user can log in once the admin who issued the invite approved the registration.
+func NewInviteExecutor(invite []byte, password []byte, totpSecret string, totpCode string) (*inviteExecutor, error) {
+	username, err := InviteUsername(invite)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inviteExecutor{
+		invite:     invite,
+		username:   username,
+		password:   password,
+		totpSecret: totpSecret,
+		totpCode:   totpCode,
+	}, nil
+}
+
+// Username returns the name the invite was issued for.
+func (e *inviteExecutor) Username() string {
+	return e.username
+}
+
+func (e *inviteExecutor) Register(session *rpc.RpcSession) error {
+	credentials, err := pki.GenerateCredentials()
+	if err != nil {
+		return fmt.Errorf("error generating credentials: %w", err)
+	}
+
+	certificateRequest, err := pki.MarshalAndSign(certificateRequest{
+		Username: e.username,
+		Invite:   e.invite,
+	}, credentials)
+	if err != nil {
+		return fmt.Errorf("error signing certificate request: %w", err)
+	}
+
+	hashingParams, err := util.GenerateArgonParameters(util.ArgonStrengthStrong)
+	if err != nil {
+		return fmt.Errorf("failed to generate hashing parameters: %w", err)
+	}
+
+	hashedPassword, err := util.HashPassword(e.password, hashingParams)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	encryptedKey, err := credentials.PrivateKey().PemEncode(e.password)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	err = rpc.WriteMessage[*loginParameterRequest](session, &loginParameterRequest{
+		Username: e.username,
+		Invite:   e.invite,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing params request: %w", err)
+	}
+
+	err = rpc.WriteMessage[*inviteRegistration](session, &inviteRegistration{
+		PublicKey:           credentials.PublicKey(),
+		CertificateRequest:  certificateRequest,
+		EncryptedKey:        encryptedKey,
+		ClientHashingParams: &hashingParams,
+		PasswordHash:        hashedPassword,
+		TotpSecret:          e.totpSecret,
+		CurrentTotp:         e.totpCode,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing registration: %w", err)
+	}
+
+	response := inviteRegistrationResponse{}
+	err = rpc.ReadMessage[*inviteRegistrationResponse](session, &response)
+	if err != nil {
+		return fmt.Errorf("error reading registration response: %w", err)
+	}
+
+	if response.Error != "" {
+		return fmt.Errorf("registration rejected: %s", response.Error)
+	}
+
+	log.Printf("Registration of %s is waiting for approval", e.username)
+
+	return nil
+}
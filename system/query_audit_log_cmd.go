@@ -0,0 +1,82 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*queryAuditLogCommand)(nil)
+
+func CreateQueryAuditLogCommandHandler(queryAuditLog func(partner *pki.Certificate, query AuditQuery) (*AuditLogPage, error)) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &queryAuditLogCommand{
+			queryAuditLog: queryAuditLog,
+		}
+	}
+}
+
+type queryAuditLogCommand struct {
+	Query         AuditQuery
+	queryAuditLog func(partner *pki.Certificate, query AuditQuery) (*AuditLogPage, error)
+	page          AuditLogPage
+}
+
+func NewQueryAuditLogCommand(query AuditQuery) *queryAuditLogCommand {
+	return &queryAuditLogCommand{
+		Query: query,
+	}
+}
+
+func (c *queryAuditLogCommand) GetKey() string {
+	return "query-audit-log"
+}
+
+func (c *queryAuditLogCommand) ExecuteServer(session *rpc.RpcSession) error {
+	page, err := c.queryAuditLog(session.Partner(), c.Query)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error querying audit log: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error querying audit log: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	err = rpc.WriteMessage[*AuditLogPage](session, page)
+	if err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+
+	return nil
+}
+
+func (c *queryAuditLogCommand) ExecuteClient(session *rpc.RpcSession) error {
+	err := rpc.ReadMessage[*AuditLogPage](session, &c.page)
+	if err != nil {
+		return fmt.Errorf("error reading audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Page returns the received entries.
+func (c *queryAuditLogCommand) Page() *AuditLogPage {
+	return &c.page
+}
@@ -0,0 +1,129 @@
+package system
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rahn-it/svalin/util"
+)
+
+// RecoveryCodeCount is how many recovery codes are generated at once.
+const RecoveryCodeCount = 10
+
+// ErrInvalidRecoveryCode is returned if a recovery code is unknown or was already used.
+var ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+
+// RecoveryCode is the server side hash of a code that can be used once instead of a TOTP code.
+// The ID is the first group of the code, so only a single hash has to be checked at login.
+type RecoveryCode struct {
+	ID     string
+	Hash   []byte
+	Params *util.ArgonParameters
+}
+
+// GenerateRecoveryCodes returns new codes of the form XXXX-XXXX-XXXX-XXXX.
+// They have to be shown to the user, the server only keeps their hashes.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	ids := make(map[string]bool)
+
+	for len(codes) < RecoveryCodeCount {
+		raw := make([]byte, 10)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		encoded := base32.StdEncoding.EncodeToString(raw)
+		code := fmt.Sprintf("%s-%s-%s-%s", encoded[0:4], encoded[4:8], encoded[8:12], encoded[12:16])
+
+		if ids[recoveryCodeID(code)] {
+			continue
+		}
+		ids[recoveryCodeID(code)] = true
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCodes hashes the codes so they can be stored.
+func HashRecoveryCodes(codes []string) ([]RecoveryCode, error) {
+	hashed := make([]RecoveryCode, 0, len(codes))
+
+	for _, code := range codes {
+		if !IsRecoveryCode(code) {
+			return nil, fmt.Errorf("%s is not a recovery code", code)
+		}
+
+		params, err := util.GenerateArgonParameters(util.ArgonStrengthDefault)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Argon Parameters: %w", err)
+		}
+
+		hash, err := util.HashPassword([]byte(normalizeRecoveryCode(code)), params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash recovery code: %w", err)
+		}
+
+		hashed = append(hashed, RecoveryCode{
+			ID:     recoveryCodeID(code),
+			Hash:   hash,
+			Params: &params,
+		})
+	}
+
+	return hashed, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// IsRecoveryCode checks if the code has the format of a recovery code, TOTP codes only contain digits.
+func IsRecoveryCode(code string) bool {
+	parts := strings.Split(normalizeRecoveryCode(code), "-")
+	if len(parts) != 4 {
+		return false
+	}
+
+	for _, part := range parts {
+		if len(part) != 4 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func recoveryCodeID(code string) string {
+	return strings.SplitN(normalizeRecoveryCode(code), "-", 2)[0]
+}
+
+// VerifyRecoveryCode returns the ID of the unused recovery code matching the given code.
+func (u *User) VerifyRecoveryCode(code string) (string, error) {
+	if !IsRecoveryCode(code) {
+		return "", ErrInvalidRecoveryCode
+	}
+
+	id := recoveryCodeID(code)
+
+	for _, recoveryCode := range u.RecoveryCodes {
+		if recoveryCode.ID != id || recoveryCode.Params == nil {
+			continue
+		}
+
+		err := util.VerifyPassword([]byte(normalizeRecoveryCode(code)), recoveryCode.Hash, *recoveryCode.Params)
+		if err != nil {
+			return "", ErrInvalidRecoveryCode
+		}
+
+		return id, nil
+	}
+
+	return "", ErrInvalidRecoveryCode
+}
@@ -0,0 +1,81 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*regenerateRecoveryCodesCommand)(nil)
+
+func CreateRegenerateRecoveryCodesCommandHandler(replaceCodes func(partner *pki.Certificate, totp string, codes []RecoveryCode) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &regenerateRecoveryCodesCommand{
+			replaceCodes: replaceCodes,
+		}
+	}
+}
+
+// regenerateRecoveryCodesCommand replaces all recovery codes of the requesting user.
+// Unused old codes stop working.
+type regenerateRecoveryCodesCommand struct {
+	RecoveryCodes []string
+	CurrentTotp   string
+	replaceCodes  func(partner *pki.Certificate, totp string, codes []RecoveryCode) error
+}
+
+func NewRegenerateRecoveryCodesCommand(currentTotp string) (*regenerateRecoveryCodesCommand, error) {
+	recoveryCodes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	return &regenerateRecoveryCodesCommand{
+		RecoveryCodes: recoveryCodes,
+		CurrentTotp:   currentTotp,
+	}, nil
+}
+
+func (c *regenerateRecoveryCodesCommand) GetKey() string {
+	return "regenerate-recovery-codes"
+}
+
+func (c *regenerateRecoveryCodesCommand) ExecuteServer(session *rpc.RpcSession) error {
+	codes, err := HashRecoveryCodes(c.RecoveryCodes)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid recovery codes",
+		})
+		return fmt.Errorf("failed to hash recovery codes: %w", err)
+	}
+
+	err = c.replaceCodes(session.Partner(), c.CurrentTotp, codes)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error replacing recovery codes: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error replacing recovery codes: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *regenerateRecoveryCodesCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
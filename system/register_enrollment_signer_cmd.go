@@ -0,0 +1,56 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+const registerEnrollmentSignerKey = "register-enrollment-signer"
+
+// EnrollmentSignerRequest hands the signer of an enrollment token to the server.
+// The connection to the server is encrypted, the password only protects the key the way it is stored.
+type EnrollmentSignerRequest struct {
+	Token       []byte
+	Credentials []byte
+	Password    []byte
+}
+
+// CreateRegisterEnrollmentSignerCommandHandler lets users delegate issuing agent certificates for their tokens to the server.
+func CreateRegisterEnrollmentSignerCommandHandler(onRegister func(partner *pki.Certificate, token []byte, signer *pki.PermanentCredentials) error) rpc.RpcCommandHandler {
+	return rpc.UnaryCommandHandler(registerEnrollmentSignerKey, func(session *rpc.RpcSession, request *EnrollmentSignerRequest) (*rpc.Empty, error) {
+		signer, err := pki.CredentialsFromPem(request.Credentials, request.Password)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid signer credentials: %v", rpc.ErrInvalidArgument, err)
+		}
+
+		err = onRegister(session.Partner(), request.Token, signer)
+		if err != nil {
+			return nil, fmt.Errorf("error registering enrollment signer: %w", err)
+		}
+
+		return &rpc.Empty{}, nil
+	})
+}
+
+func NewRegisterEnrollmentSignerCommand(token []byte, signer *pki.PermanentCredentials) (*rpc.UnaryCommand[EnrollmentSignerRequest, rpc.Empty], error) {
+	password, err := util.GeneratePassword()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	raw, err := signer.PemEncode(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signer credentials: %w", err)
+	}
+
+	request := &EnrollmentSignerRequest{
+		Token:       token,
+		Credentials: raw,
+		Password:    password,
+	}
+
+	return rpc.NewUnaryCommand[EnrollmentSignerRequest, rpc.Empty](registerEnrollmentSignerKey, request), nil
+}
@@ -12,6 +12,7 @@ var _ rpc.RpcCommand = (*registerUserCommand)(nil)
 
 func CreateRegisterUserCommandHandler(
 	verifier pki.Verifier,
+	guard LoginGuard,
 	acceptUser func(
 		Certificate *pki.Certificate,
 		EncryptedPrivateKey []byte,
@@ -19,10 +20,12 @@ func CreateRegisterUserCommandHandler(
 		ServerHashingParams *util.ArgonParameters,
 		DoubleHashedPassword []byte,
 		TotpSecret string,
+		RecoveryCodes []RecoveryCode,
 	) error) rpc.RpcCommandHandler {
 	return func() rpc.RpcCommand {
 		return &registerUserCommand{
 			verifier:   verifier,
+			guard:      guard,
 			acceptUser: acceptUser,
 		}
 	}
@@ -35,14 +38,19 @@ type registerUserCommand struct {
 	PasswordHash        []byte
 	TotpSecret          string
 	CurrentTotp         string
-	verifier            pki.Verifier
-	acceptUser          func(
+	// RecoveryCodes are generated by the client, they have to be shown to the user after registering.
+	RecoveryCodes []string
+	verifier      pki.Verifier
+	// guard remembers the TOTP code, so it can't be replayed to log in.
+	guard      LoginGuard
+	acceptUser func(
 		Certificate *pki.Certificate,
 		EncryptedPrivateKey []byte,
 		ClientHashingParams *util.ArgonParameters,
 		ServerHashingParams *util.ArgonParameters,
 		DoubleHashedPassword []byte,
 		TotpSecret string,
+		RecoveryCodes []RecoveryCode,
 	) error
 }
 
@@ -68,6 +76,11 @@ func NewRegisterUserCmd(
 		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
 	}
 
+	recoveryCodes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
 	return &registerUserCommand{
 		Certificate:         credentials.Certificate(),
 		EncryptedKey:        encryptedKey,
@@ -75,6 +88,7 @@ func NewRegisterUserCmd(
 		PasswordHash:        hashedPassword,
 		TotpSecret:          totpSecret,
 		CurrentTotp:         currentTotp,
+		RecoveryCodes:       recoveryCodes,
 	}, nil
 }
 
@@ -96,7 +110,8 @@ func (cmd *registerUserCommand) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("client hashing parameters are insecure")
 	}
 
-	if !util.ValidateTotp(cmd.TotpSecret, cmd.CurrentTotp) {
+	step, ok := util.ValidateTotpStep(cmd.TotpSecret, cmd.CurrentTotp, 0)
+	if !ok {
 		session.WriteResponseHeader(rpc.SessionResponseHeader{
 			Code: 400,
 			Msg:  "Invalid TOTP code",
@@ -123,6 +138,15 @@ func (cmd *registerUserCommand) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("invalid certificate type")
 	}
 
+	err = cmd.guard.UseTotp(cert.GetName(), step)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid TOTP code",
+		})
+		return fmt.Errorf("error using TOTP code: %w", err)
+	}
+
 	serverHashingParams, err := util.GenerateArgonParameters(util.ArgonStrengthDefault)
 	if err != nil {
 		session.WriteResponseHeader(rpc.SessionResponseHeader{
@@ -141,7 +165,16 @@ func (cmd *registerUserCommand) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	err = cmd.acceptUser(cert, cmd.EncryptedKey, cmd.ClientHashingParams, &serverHashingParams, double_hash, cmd.TotpSecret)
+	recoveryCodes, err := HashRecoveryCodes(cmd.RecoveryCodes)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid recovery codes",
+		})
+		return fmt.Errorf("failed to hash recovery codes: %w", err)
+	}
+
+	err = cmd.acceptUser(cert, cmd.EncryptedKey, cmd.ClientHashingParams, &serverHashingParams, double_hash, cmd.TotpSecret, recoveryCodes)
 	if err != nil {
 		session.WriteResponseHeader(rpc.SessionResponseHeader{
 			Code: 500,
@@ -0,0 +1,91 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*rejectEnrollmentCommand)(nil)
+var _ rpc.AuditableCommand = (*rejectEnrollmentCommand)(nil)
+
+func CreateRejectEnrollmentCommandHandler(reject func(key *pki.PublicKey, reason string, block bool) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &rejectEnrollmentCommand{
+			reject: reject,
+		}
+	}
+}
+
+// rejectEnrollmentCommand drops a waiting enrollment instead of letting it time out.
+// If Block is set, the key and address of the agent are not allowed to enroll again.
+type rejectEnrollmentCommand struct {
+	PublicKey *pki.PublicKey
+	Reason    string
+	Block     bool
+	reject    func(key *pki.PublicKey, reason string, block bool) error
+}
+
+func NewRejectEnrollmentCommand(key *pki.PublicKey, reason string, block bool) *rejectEnrollmentCommand {
+	return &rejectEnrollmentCommand{
+		PublicKey: key,
+		Reason:    reason,
+		Block:     block,
+	}
+}
+
+func (c *rejectEnrollmentCommand) GetKey() string {
+	return "reject-enrollment"
+}
+
+func (c *rejectEnrollmentCommand) ExecuteServer(session *rpc.RpcSession) error {
+	partner := session.Partner()
+	if partner == nil || (partner.Type() != pki.CertTypeUser && partner.Type() != pki.CertTypeRoot) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Only users may reject enrollments",
+		})
+		return fmt.Errorf("enrollment rejection not requested by a user")
+	}
+
+	if c.PublicKey == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Missing public key",
+		})
+		return fmt.Errorf("missing public key")
+	}
+
+	err := c.reject(c.PublicKey, c.Reason, c.Block)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error rejecting enrollment: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *rejectEnrollmentCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *rejectEnrollmentCommand) AuditTarget() string {
+	if c.PublicKey == nil {
+		return ""
+	}
+
+	return c.PublicKey.Base64Encode()
+}
+
+func (c *rejectEnrollmentCommand) AuditSummary() string {
+	return fmt.Sprintf("block=%t reason=%q", c.Block, c.Reason)
+}
@@ -0,0 +1,79 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*renameDeviceCommand)(nil)
+var _ rpc.AuditableCommand = (*renameDeviceCommand)(nil)
+
+func CreateRenameDeviceCommandHandler(renameDevice func(partner *pki.Certificate, cert *pki.Certificate) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &renameDeviceCommand{
+			renameDevice: renameDevice,
+		}
+	}
+}
+
+// renameDeviceCommand carries a re-issued agent certificate with the new name.
+// The server pushes it to the agent, replaces the stored one and revokes the old certificate.
+type renameDeviceCommand struct {
+	Cert         *pki.Certificate
+	renameDevice func(partner *pki.Certificate, cert *pki.Certificate) error
+}
+
+func NewRenameDeviceCommand(cert *pki.Certificate) *renameDeviceCommand {
+	return &renameDeviceCommand{
+		Cert: cert,
+	}
+}
+
+func (c *renameDeviceCommand) GetKey() string {
+	return "rename-device"
+}
+
+func (c *renameDeviceCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.Cert == nil || c.Cert.Type() != pki.CertTypeAgent {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid certificate",
+		})
+		return fmt.Errorf("invalid certificate: not an agent certificate")
+	}
+
+	err := c.renameDevice(session.Partner(), c.Cert)
+	if err != nil {
+		session.WriteError(err)
+		return fmt.Errorf("error renaming device: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *renameDeviceCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *renameDeviceCommand) AuditTarget() string {
+	if c.Cert == nil {
+		return ""
+	}
+
+	return c.Cert.PublicKey().Base64Encode()
+}
+
+func (c *renameDeviceCommand) AuditSummary() string {
+	if c.Cert == nil {
+		return ""
+	}
+
+	return "name=" + c.Cert.GetName()
+}
@@ -0,0 +1,80 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*renewDeviceCommand)(nil)
+var _ rpc.AuditableCommand = (*renewDeviceCommand)(nil)
+
+func CreateRenewDeviceCommandHandler(renewDevice func(partner *pki.Certificate, device *pki.PublicKey, cert *pki.Certificate) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &renewDeviceCommand{
+			renewDevice: renewDevice,
+		}
+	}
+}
+
+// renewDeviceCommand answers a pending renewal with a freshly signed certificate.
+type renewDeviceCommand struct {
+	Device      *pki.PublicKey
+	Cert        *pki.Certificate
+	renewDevice func(partner *pki.Certificate, device *pki.PublicKey, cert *pki.Certificate) error
+}
+
+func NewRenewDeviceCommand(device *pki.PublicKey, cert *pki.Certificate) *renewDeviceCommand {
+	return &renewDeviceCommand{
+		Device: device,
+		Cert:   cert,
+	}
+}
+
+func (c *renewDeviceCommand) GetKey() string {
+	return "renew-device"
+}
+
+func (c *renewDeviceCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.Device == nil || c.Cert == nil || c.Cert.Type() != pki.CertTypeAgent {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid certificate",
+		})
+		return fmt.Errorf("invalid renewal: missing device or agent certificate")
+	}
+
+	err := c.renewDevice(session.Partner(), c.Device, c.Cert)
+	if err != nil {
+		session.WriteError(err)
+		return fmt.Errorf("error renewing device: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *renewDeviceCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *renewDeviceCommand) AuditTarget() string {
+	if c.Device == nil {
+		return ""
+	}
+
+	return c.Device.Base64Encode()
+}
+
+func (c *renewDeviceCommand) AuditSummary() string {
+	if c.Cert == nil {
+		return ""
+	}
+
+	return "name=" + c.Cert.GetName()
+}
@@ -0,0 +1,139 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*requestRenewalCommand)(nil)
+
+// Renewal is a pending request of an agent to get a fresh certificate.
+type Renewal struct {
+	Device      *pki.Certificate
+	PublicKey   *pki.PublicKey
+	RequestTime time.Time
+	// Issuer is the user who issued the certificate of the device, possibly through an enrollment signer.
+	Issuer *pki.Certificate
+	// AnyAdmin is set by the renewal policy of the server if admins may renew the certificate,
+	// otherwise only the user who issued it does.
+	AnyAdmin bool
+}
+
+// The renewal policies of the server.
+//
+// The server cannot sign certificates itself, it queues a renewal until a client allowed to re-sign it is online.
+// Under either policy, an agent expires if no such client connects before its certificate runs out.
+const (
+	// RenewalPolicyIssuer lets only the client of the issuing user re-sign.
+	RenewalPolicyIssuer = "issuer"
+	// RenewalPolicyAdmins lets the issuing user or, if it isn't online, any admin re-sign.
+	// It still needs the client of one of them to be online.
+	RenewalPolicyAdmins = "admins"
+)
+
+func CreateRequestRenewalCommandHandler(onRequest func(renewal *Renewal) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &requestRenewalCommand{
+			onRequest: onRequest,
+		}
+	}
+}
+
+// requestRenewalCommand is sent by an agent nearing certificate expiry.
+// If the key is rotated, Proof contains the current certificate signed with the new key.
+type requestRenewalCommand struct {
+	PublicKey *pki.PublicKey
+	Proof     []byte
+	onRequest func(renewal *Renewal) error
+}
+
+func NewRequestRenewalCommand(current *pki.PermanentCredentials, next pki.Credentials) (*requestRenewalCommand, error) {
+	cmd := &requestRenewalCommand{
+		PublicKey: next.PublicKey(),
+	}
+
+	if !next.PublicKey().Equal(current.PublicKey()) {
+		proof, err := pki.MarshalAndSign(current.Certificate().BinaryEncode(), next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign proof with new key: %w", err)
+		}
+		cmd.Proof = proof
+	}
+
+	return cmd, nil
+}
+
+func (c *requestRenewalCommand) GetKey() string {
+	return "request-renewal"
+}
+
+func (c *requestRenewalCommand) ExecuteServer(session *rpc.RpcSession) error {
+	device := session.Partner()
+	if device == nil || device.Type() != pki.CertTypeAgent {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Only agents may request a renewal",
+		})
+		return fmt.Errorf("renewal not requested by an agent")
+	}
+
+	if time.Until(device.NotAfter()) > pki.AgentRenewBefore {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Certificate is not due for renewal",
+		})
+		return fmt.Errorf("certificate of %s is not due for renewal", device.GetName())
+	}
+
+	if c.PublicKey == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "No public key provided",
+		})
+		return fmt.Errorf("no public key provided")
+	}
+
+	if !c.PublicKey.Equal(device.PublicKey()) {
+		var signed []byte
+		err := pki.UnmarshalAndVerify(c.Proof, &signed, c.PublicKey)
+		if err == nil && !bytes.Equal(signed, device.BinaryEncode()) {
+			err = errors.New("proof was signed for a different certificate")
+		}
+		if err != nil {
+			session.WriteResponseHeader(rpc.SessionResponseHeader{
+				Code: 400,
+				Msg:  "Invalid proof of possession for new key",
+			})
+			return fmt.Errorf("invalid proof of possession for new key: %w", err)
+		}
+	}
+
+	err := c.onRequest(&Renewal{
+		Device:      device,
+		PublicKey:   c.PublicKey,
+		RequestTime: time.Now(),
+	})
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error registering renewal: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *requestRenewalCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
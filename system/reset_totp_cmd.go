@@ -0,0 +1,90 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*resetTotpCommand)(nil)
+var _ rpc.AuditableCommand = (*resetTotpCommand)(nil)
+
+// TotpResetWindow is how long a user has to confirm a new TOTP secret after an admin reset it.
+const TotpResetWindow = 24 * time.Hour
+
+func CreateResetTotpCommandHandler(resetTotp func(partner *pki.Certificate, key *pki.PublicKey) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &resetTotpCommand{
+			resetTotp: resetTotp,
+		}
+	}
+}
+
+// resetTotpCommand allows a user to replace a lost authenticator.
+// The old secret stays valid until the user confirms a code of the new one.
+type resetTotpCommand struct {
+	PublicKey *pki.PublicKey
+	resetTotp func(partner *pki.Certificate, key *pki.PublicKey) error
+}
+
+func NewResetTotpCommand(key *pki.PublicKey) *resetTotpCommand {
+	return &resetTotpCommand{
+		PublicKey: key,
+	}
+}
+
+func (c *resetTotpCommand) GetKey() string {
+	return "reset-totp"
+}
+
+func (c *resetTotpCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.PublicKey == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Missing public key",
+		})
+		return fmt.Errorf("missing public key")
+	}
+
+	err := c.resetTotp(session.Partner(), c.PublicKey)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error resetting totp: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error resetting totp: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *resetTotpCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *resetTotpCommand) AuditTarget() string {
+	if c.PublicKey == nil {
+		return ""
+	}
+
+	return c.PublicKey.Base64Encode()
+}
+
+func (c *resetTotpCommand) AuditSummary() string {
+	return ""
+}
@@ -1,7 +1,11 @@
 package system
 
 import (
+	"bytes"
 	"crypto"
+	"encoding/binary"
+	"fmt"
+	"time"
 
 	"github.com/rahn-it/svalin/db"
 	"github.com/rahn-it/svalin/pki"
@@ -18,6 +22,13 @@ type revocation struct {
 	date  int64
 }
 
+// Revocation is a revoked certificate as it is handed out to agents,
+// only the hash of the certificate is known to them.
+type Revocation struct {
+	Hash []byte
+	Date int64
+}
+
 func OpenRevocationStore(scope db.Scope, root *pki.Certificate) (*RevocationStore, error) {
 	return &RevocationStore{
 		scope: scope,
@@ -35,13 +46,26 @@ func (rs *RevocationStore) CheckCertificate(cert *pki.Certificate) error {
 	return rs.check(cert.BinaryEncode())
 }
 
-func (rs *RevocationStore) check(payload []byte) error {
+// RevokeCertificate marks the given certificate as revoked.
+// Any chain containing it will fail verification afterwards.
+func (rs *RevocationStore) RevokeCertificate(cert *pki.Certificate) error {
+	if cert.Equal(rs.root) {
+		return fmt.Errorf("the root certificate cannot be revoked")
+	}
+
+	return rs.revoke(cert.BinaryEncode())
+}
+
+func (rs *RevocationStore) hashKeys(payload []byte) [][]byte {
 	hashers := rs.getHashers()
 
 	hashKeys := make([][]byte, 0, len(hashers))
 	for hashPrefix, hashAlg := range hashers {
 
-		hash := hashAlg.New().Sum(payload)
+		hasher := hashAlg.New()
+		hasher.Write(payload)
+		hash := hasher.Sum(nil)
+
 		hashKey := make([]byte, len(hashPrefix)+len(hash))
 		copy(hashKey, hashPrefix)
 		copy(hashKey[len(hashPrefix):], hash)
@@ -49,7 +73,144 @@ func (rs *RevocationStore) check(payload []byte) error {
 		hashKeys = append(hashKeys, hashKey)
 	}
 
+	return hashKeys
+}
+
+func (rs *RevocationStore) check(payload []byte) error {
+	hashKeys := rs.hashKeys(payload)
+
+	return rs.scope.View(func(b db.Bucket) error {
+		for _, hashKey := range hashKeys {
+			raw := b.Get(hashKey)
+			if raw != nil {
+				date := time.Unix(int64(binary.BigEndian.Uint64(raw)), 0)
+				return fmt.Errorf("revoked on %s", date.Format(time.RFC3339))
+			}
+		}
+
+		return nil
+	})
+}
+
+func (rs *RevocationStore) revoke(payload []byte) error {
+	hashKeys := rs.hashKeys(payload)
+
+	date := make([]byte, 8)
+	binary.BigEndian.PutUint64(date, uint64(time.Now().Unix()))
+
+	err := rs.scope.Update(func(b db.Bucket) error {
+		for _, hashKey := range hashKeys {
+			if b.Get(hashKey) != nil {
+				continue
+			}
+
+			err := b.Put(hashKey, date)
+			if err != nil {
+				return fmt.Errorf("failed to save revocation: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
 	return nil
-	// TODO
+}
+
+// Revocations lists the revocations made since the given time.
+func (rs *RevocationStore) Revocations(since time.Time) ([]Revocation, error) {
+	revocations := make([]Revocation, 0)
 
+	err := rs.scope.View(func(b db.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if len(v) != 8 {
+				return nil
+			}
+
+			date := int64(binary.BigEndian.Uint64(v))
+			if date < since.Unix() {
+				return nil
+			}
+
+			revocations = append(revocations, Revocation{
+				Hash: append([]byte(nil), k...),
+				Date: date,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing revocations: %w", err)
+	}
+
+	return revocations, nil
+}
+
+// Latest returns the time of the newest revocation, it is the zero time if nothing was revoked.
+func (rs *RevocationStore) Latest() (time.Time, error) {
+	var latest int64
+
+	err := rs.scope.View(func(b db.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if len(v) == 8 {
+				date := int64(binary.BigEndian.Uint64(v))
+				if date > latest {
+					latest = date
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading revocations: %w", err)
+	}
+
+	if latest == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(latest, 0), nil
+}
+
+// AddRevocations stores revocations received from the upstream.
+func (rs *RevocationStore) AddRevocations(revocations []Revocation) error {
+	err := rs.scope.Update(func(b db.Bucket) error {
+		for _, r := range revocations {
+			if !rs.validHashKey(r.Hash) {
+				return fmt.Errorf("unknown hash in revocation")
+			}
+
+			if b.Get(r.Hash) != nil {
+				continue
+			}
+
+			date := make([]byte, 8)
+			binary.BigEndian.PutUint64(date, uint64(r.Date))
+
+			err := b.Put(r.Hash, date)
+			if err != nil {
+				return fmt.Errorf("failed to save revocation: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// validHashKey checks if key is a hash key of one of the supported hashes.
+func (rs *RevocationStore) validHashKey(key []byte) bool {
+	for hashPrefix, hashAlg := range rs.getHashers() {
+		if len(key) == len(hashPrefix)+hashAlg.Size() && bytes.HasPrefix(key, []byte(hashPrefix)) {
+			return true
+		}
+	}
+	return false
 }
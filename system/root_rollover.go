@@ -0,0 +1,63 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rahn-it/svalin/pki"
+)
+
+// DefaultRolloverWindow is how long both roots are trusted after a rollover was started.
+const DefaultRolloverWindow = 30 * 24 * time.Hour
+
+// RootRollover announces the root that replaces the current one.
+// Until RetireAt both roots are trusted, afterwards only Next.
+type RootRollover struct {
+	Next        *pki.Certificate
+	CrossSigned *pki.Certificate
+	Upstream    *pki.Certificate
+	RetireAt    time.Time
+}
+
+// Verify checks that the rollover was issued by the holder of the current root
+// and that the upstream was re-issued for the same key below the next root.
+func (r *RootRollover) Verify(current *pki.Certificate, upstream *pki.PublicKey) error {
+	if r.Next == nil || r.CrossSigned == nil || r.Upstream == nil {
+		return errors.New("incomplete root rollover")
+	}
+
+	if r.Next.Type() != pki.CertTypeRoot || !r.Next.IsIssuedBy(r.Next) {
+		return errors.New("next root is not a self signed root certificate")
+	}
+
+	if r.Next.PublicKey().Equal(current.PublicKey()) {
+		return errors.New("next root uses the current root key")
+	}
+
+	if !r.CrossSigned.PublicKey().Equal(r.Next.PublicKey()) || r.CrossSigned.GetName() != r.Next.GetName() {
+		return errors.New("cross signed certificate does not match next root")
+	}
+
+	if !r.CrossSigned.IsIssuedBy(current) {
+		return errors.New("next root was not cross signed by the current root")
+	}
+
+	if r.Upstream.Type() != pki.CertTypeServer || !r.Upstream.IsIssuedBy(r.Next) {
+		return errors.New("upstream was not re-issued by the next root")
+	}
+
+	if upstream != nil && !r.Upstream.PublicKey().Equal(upstream) {
+		return errors.New("re-issued upstream certificate has a different key")
+	}
+
+	if !r.RetireAt.After(time.Now()) {
+		return fmt.Errorf("retirement date %s lies in the past", r.RetireAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func (r *RootRollover) Retired() bool {
+	return !time.Now().Before(r.RetireAt)
+}
@@ -1,40 +1,36 @@
 package server
 
 import (
-	"crypto/x509"
 	"errors"
 	"fmt"
 
 	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
 )
 
 var _ pki.Verifier = (*chainVerifier)(nil)
 
 type chainVerifier struct {
-	root          *pki.Certificate
-	rootPool      *x509.CertPool
-	intermediates *x509.CertPool
+	anchors *system.TrustAnchors
 }
 
-func newChainVerifier(root *pki.Certificate) (*chainVerifier, error) {
-	rootPool := x509.NewCertPool()
-	rootPool.AddCert(root.ToX509())
-
-	intermediates := x509.NewCertPool()
+func newChainVerifier(anchors *system.TrustAnchors) (*chainVerifier, error) {
+	if anchors == nil {
+		return nil, fmt.Errorf("trust anchors cannot be nil")
+	}
 
 	return &chainVerifier{
-		root:          root,
-		rootPool:      rootPool,
-		intermediates: intermediates,
+		anchors: anchors,
 	}, nil
 }
 
 func (v *chainVerifier) Verify(cert *pki.Certificate) ([]*pki.Certificate, error) {
-	if cert.Equal(v.root) {
-		return []*pki.Certificate{v.root}, nil
+	root := v.anchors.RootFor(cert.PublicKey())
+	if root != nil && cert.Equal(root) {
+		return []*pki.Certificate{root}, nil
 	}
 
-	chain, err := cert.VerifyChain(v.rootPool, v.intermediates)
+	chain, err := cert.VerifyChain(v.anchors.Roots(), v.anchors.Intermediates())
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify certificate: %w", err)
 	}
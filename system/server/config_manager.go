@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 
 	"github.com/rahn-it/svalin/db"
 	"github.com/rahn-it/svalin/pki"
@@ -57,7 +58,8 @@ func (h *HostConfigHandler[T]) Get(key string) (*pki.SignedArtifact[T], bool) {
 		return nil
 	})
 	if err != nil {
-		panic(err)
+		log.Printf("error reading host config %s: %v", key, err)
+		return nil, false
 	}
 
 	if raw == nil {
@@ -66,7 +68,8 @@ func (h *HostConfigHandler[T]) Get(key string) (*pki.SignedArtifact[T], bool) {
 
 	artifact, err := pki.LoadSignedArtifact[T](raw, h.verifier)
 	if err != nil {
-		panic(err)
+		log.Printf("error loading host config %s: %v", key, err)
+		return nil, false
 	}
 
 	return artifact, true
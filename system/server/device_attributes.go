@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/system"
+)
+
+type deviceAttributeStore struct {
+	scope db.Scope
+}
+
+func openDeviceAttributeStore(scope db.Scope) (*deviceAttributeStore, error) {
+	return &deviceAttributeStore{
+		scope: scope,
+	}, nil
+}
+
+// get returns the attributes of the device with the given key.
+// If none were set, empty attributes are returned.
+func (s *deviceAttributeStore) get(key string) (system.DeviceAttributes, error) {
+	attributes := system.DeviceAttributes{}
+
+	err := s.scope.View(func(b db.Bucket) error {
+		raw := b.Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+
+		return json.Unmarshal(raw, &attributes)
+	})
+	if err != nil {
+		return system.DeviceAttributes{}, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return attributes, nil
+}
+
+func (s *deviceAttributeStore) set(key string, attributes system.DeviceAttributes) error {
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attributes: %w", err)
+	}
+
+	err = s.scope.Update(func(b db.Bucket) error {
+		return b.Put([]byte(key), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// move hands the attributes of a device over to its new key.
+func (s *deviceAttributeStore) move(oldKey string, newKey string) error {
+	err := s.scope.Update(func(b db.Bucket) error {
+		raw := b.Get([]byte(oldKey))
+		if raw == nil {
+			return nil
+		}
+
+		err := b.Put([]byte(newKey), append([]byte(nil), raw...))
+		if err != nil {
+			return err
+		}
+
+		return b.Delete([]byte(oldKey))
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
+)
+
+// deviceAuditStore keeps the audit trails uploaded by devices.
+// A trail is named after the key the device had when it uploaded first and follows the device when it is rekeyed.
+type deviceAuditStore struct {
+	scope db.Scope
+	// keys maps the current key of a rekeyed device to its trail.
+	keys db.Scope
+}
+
+// deviceAuditKeys points a rekeyed device to its trail.
+type deviceAuditKeys struct {
+	Trail string
+	// Previous are the keys the device had before, oldest first.
+	Previous []*pki.PublicKey
+}
+
+func openDeviceAuditStore(scope db.Scope) (*deviceAuditStore, error) {
+	return &deviceAuditStore{
+		scope: scope,
+		keys:  scope.Scope("keys"),
+	}, nil
+}
+
+func (s *deviceAuditStore) getKeys(key *pki.PublicKey) (deviceAuditKeys, error) {
+	keys := deviceAuditKeys{
+		Trail: key.Base64Encode(),
+	}
+
+	err := s.keys.View(func(b db.Bucket) error {
+		raw := b.Get([]byte(key.Base64Encode()))
+		if raw == nil {
+			return nil
+		}
+
+		return json.Unmarshal(raw, &keys)
+	})
+	if err != nil {
+		return deviceAuditKeys{}, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return keys, nil
+}
+
+// trail opens the audit trail of the device with the given key.
+// It also returns the previous keys of the device, the older entries are signed with them.
+func (s *deviceAuditStore) trail(key *pki.PublicKey) (*system.AuditLog, []*pki.PublicKey, error) {
+	keys, err := s.getKeys(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trail, err := system.OpenAuditLog(s.scope.Scope(keys.Trail), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return trail, keys.Previous, nil
+}
+
+// rekey hands the trail of a device over to its new key.
+func (s *deviceAuditStore) rekey(oldKey *pki.PublicKey, newKey *pki.PublicKey) error {
+	keys, err := s.getKeys(oldKey)
+	if err != nil {
+		return err
+	}
+
+	keys.Previous = append(keys.Previous, oldKey)
+
+	raw, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit trail keys: %w", err)
+	}
+
+	err = s.keys.Update(func(b db.Bucket) error {
+		err := b.Put([]byte(newKey.Base64Encode()), raw)
+		if err != nil {
+			return err
+		}
+
+		return b.Delete([]byte(oldKey.Base64Encode()))
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
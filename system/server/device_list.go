@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
 	"github.com/rahn-it/svalin/system"
 	"github.com/rahn-it/svalin/util"
 )
@@ -13,15 +14,32 @@ var _ util.ObservableMap[string, *system.DeviceInfo] = (*DeviceList)(nil)
 type DeviceList struct {
 	observerHandler *util.MapObserverHandler[string, *system.DeviceInfo]
 	deviceStore     *deviceStore
+	attributes      *deviceAttributeStore
 	online          map[string]bool
+	connections     map[string]*rpc.RpcConnection
 }
 
-func newDeviceList(deviceStore *deviceStore) *DeviceList {
-	return &DeviceList{
+func newDeviceList(deviceStore *deviceStore, attributes *deviceAttributeStore) *DeviceList {
+	d := &DeviceList{
 		observerHandler: util.NewMapObserverHandler[string, *system.DeviceInfo](),
 		deviceStore:     deviceStore,
+		attributes:      attributes,
 		online:          make(map[string]bool),
+		connections:     make(map[string]*rpc.RpcConnection),
 	}
+
+	deviceStore.Subscribe(
+		func(key string, cert *pki.Certificate) {
+			d.observerHandler.NotifyUpdate(key, d.deviceInfo(key, cert))
+		},
+		func(key string, cert *pki.Certificate) {
+			d.observerHandler.NotifyDelete(key, &system.DeviceInfo{
+				Certificate: cert,
+			})
+		},
+	)
+
+	return d
 }
 
 func (d *DeviceList) isOnline(key string) bool {
@@ -36,24 +54,52 @@ func (d *DeviceList) isOnline(key string) bool {
 	return online
 }
 
-func (d *DeviceList) ForEach(fn func(key string, value *system.DeviceInfo) error) error {
-	return d.deviceStore.ForEach(func(key string, cert *pki.Certificate) error {
-		di := &system.DeviceInfo{
-			Certificate: cert,
-			LiveInfo: system.LiveDeviceInfo{
-				Online: d.isOnline(key),
-			},
+func (d *DeviceList) deviceInfo(key string, cert *pki.Certificate) *system.DeviceInfo {
+	attributes, err := d.attributes.get(key)
+	if err != nil {
+		log.Printf("Error getting device attributes: %v", err)
+	}
+
+	live := system.LiveDeviceInfo{}
+	if d.isOnline(key) {
+		live.Online = true
+		if conn, ok := d.connections[key]; ok {
+			live = system.NewLiveDeviceInfo(conn)
 		}
+	}
+
+	return &system.DeviceInfo{
+		Certificate: cert,
+		Attributes:  attributes,
+		LiveInfo:    live,
+	}
+}
 
-		return fn(key, di)
+func (d *DeviceList) ForEach(fn func(key string, value *system.DeviceInfo) error) error {
+	certs := make(map[string]*pki.Certificate)
+	err := d.deviceStore.ForEach(func(key string, cert *pki.Certificate) error {
+		certs[key] = cert
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	for key, cert := range certs {
+		err := fn(key, d.deviceInfo(key, cert))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (d *DeviceList) Subscribe(onUpdate func(string, *system.DeviceInfo), onRemove func(string, *system.DeviceInfo)) func() {
 	return d.observerHandler.Subscribe(onUpdate, onRemove)
 }
 
-func (d *DeviceList) setOnlineStatus(key string, online bool) {
+func (d *DeviceList) setOnlineStatus(key string, online bool, conn *rpc.RpcConnection) {
 	pubKey, err := pki.PublicKeyFromBase64(key)
 	if err != nil {
 		log.Printf("Error parsing public key: %v", err)
@@ -72,16 +118,11 @@ func (d *DeviceList) setOnlineStatus(key string, online bool) {
 
 	if online {
 		d.online[key] = true
+		d.connections[key] = conn
 	} else {
 		delete(d.online, key)
+		delete(d.connections, key)
 	}
 
-	di := &system.DeviceInfo{
-		Certificate: cert,
-		LiveInfo: system.LiveDeviceInfo{
-			Online: d.isOnline(key),
-		},
-	}
-
-	d.observerHandler.NotifyUpdate(key, di)
+	d.observerHandler.NotifyUpdate(key, d.deviceInfo(key, cert))
 }
@@ -61,14 +61,81 @@ func (s *deviceStore) GetDevice(key *pki.PublicKey) (*pki.Certificate, error) {
 }
 
 func (s *deviceStore) AddDevice(cert *pki.Certificate) error {
-	byteKey := []byte(cert.PublicKey().Base64Encode())
-	return s.scope.Update(func(b db.Bucket) error {
+	key := cert.PublicKey().Base64Encode()
+	byteKey := []byte(key)
+	err := s.scope.Update(func(b db.Bucket) error {
 		// check if the certificate already exists
 		if b.Get(byteKey) != nil {
 			return errors.New("certificate already exists")
 		}
 		return b.Put(byteKey, cert.PemEncode())
 	})
+
+	if err != nil {
+		return err
+	}
+
+	s.observableHandler.NotifyUpdate(key, cert)
+	return nil
+}
+
+// ReplaceDevice swaps the stored certificate for one issued to the same public key.
+// It returns the certificate that was replaced.
+func (s *deviceStore) ReplaceDevice(cert *pki.Certificate) (*pki.Certificate, error) {
+	key := cert.PublicKey().Base64Encode()
+	byteKey := []byte(key)
+	var old *pki.Certificate
+	err := s.scope.Update(func(b db.Bucket) error {
+		raw := b.Get(byteKey)
+		if raw == nil {
+			return errors.New("device not found")
+		}
+
+		current, err := pki.CertificateFromPem(raw)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal certificate: %w", err)
+		}
+
+		old = current
+		return b.Put(byteKey, cert.PemEncode())
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	s.observableHandler.NotifyUpdate(key, cert)
+	return old, nil
+}
+
+// RekeyDevice moves a device to a certificate issued for a different public key.
+func (s *deviceStore) RekeyDevice(old *pki.Certificate, cert *pki.Certificate) error {
+	oldKey := old.PublicKey().Base64Encode()
+	key := cert.PublicKey().Base64Encode()
+	err := s.scope.Update(func(b db.Bucket) error {
+		if b.Get([]byte(oldKey)) == nil {
+			return errors.New("device not found")
+		}
+
+		if b.Get([]byte(key)) != nil {
+			return errors.New("certificate already exists")
+		}
+
+		err := b.Delete([]byte(oldKey))
+		if err != nil {
+			return fmt.Errorf("failed to remove old certificate: %w", err)
+		}
+
+		return b.Put([]byte(key), cert.PemEncode())
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	s.observableHandler.NotifyDelete(oldKey, old)
+	s.observableHandler.NotifyUpdate(key, cert)
+	return nil
 }
 
 func (s *deviceStore) ForEach(fn func(key string, value *pki.Certificate) error) error {
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// enrollmentBlocklist keeps agent keys and addresses that may no longer enroll.
+// Agents generate a new key for every attempt, so blocking the address is what keeps them out.
+type enrollmentBlocklist struct {
+	scope db.Scope
+}
+
+type blockedEnrollment struct {
+	Reason string
+	Time   time.Time
+}
+
+func openEnrollmentBlocklist(scope db.Scope) (*enrollmentBlocklist, error) {
+	return &enrollmentBlocklist{
+		scope: scope,
+	}, nil
+}
+
+func blockedKeyEntry(key *pki.PublicKey) []byte {
+	return []byte("key:" + key.Base64Encode())
+}
+
+func blockedAddrEntry(addr string) []byte {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return []byte("ip:" + host)
+}
+
+func (b *enrollmentBlocklist) block(enrollment *rpc.Enrollment, reason string) error {
+	raw, err := json.Marshal(blockedEnrollment{
+		Reason: reason,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal blocklist entry: %w", err)
+	}
+
+	err = b.scope.Update(func(bucket db.Bucket) error {
+		err := bucket.Put(blockedKeyEntry(enrollment.PublicKey), raw)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(blockedAddrEntry(enrollment.Addr), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// check fails if the key or the address of an enrolling agent was blocked.
+func (b *enrollmentBlocklist) check(key *pki.PublicKey, addr net.Addr) error {
+	return b.scope.View(func(bucket db.Bucket) error {
+		for _, entry := range [][]byte{blockedKeyEntry(key), blockedAddrEntry(addr.String())} {
+			raw := bucket.Get(entry)
+			if raw == nil {
+				continue
+			}
+
+			blocked := blockedEnrollment{}
+			err := json.Unmarshal(raw, &blocked)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal blocklist entry: %w", err)
+			}
+
+			return fmt.Errorf("%s blocked since %s: %s", entry, blocked.Time.Format(time.RFC3339), blocked.Reason)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+	"github.com/rahn-it/svalin/util"
+)
+
+// signerDropInterval is how often the keys of expired signers are looked for.
+const signerDropInterval = 10 * time.Minute
+
+// enrollmentTokenStore counts how often each enrollment token was redeemed
+// and keeps the signers the issuing users delegated the tokens to.
+type enrollmentTokenStore struct {
+	scope    db.Scope
+	signers  db.Scope
+	verifier pki.Verifier
+}
+
+// enrollmentSigner is stored for every token with a signer.
+// The key is dropped once the token expired, the certificate is kept to verify the agents it issued.
+type enrollmentSigner struct {
+	Certificate *pki.Certificate
+	Expires     time.Time
+	Password    []byte `json:",omitempty"`
+	Key         []byte `json:",omitempty"`
+}
+
+func openEnrollmentTokenStore(scope db.Scope, verifier pki.Verifier) (*enrollmentTokenStore, error) {
+	s := &enrollmentTokenStore{
+		scope:    scope,
+		signers:  scope.Scope("signers"),
+		verifier: verifier,
+	}
+
+	err := s.dropExpiredSigners()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// addSigner stores the signer of a token, so agents presenting it get their certificate from the server.
+// Only the user who issued the token may register its signer.
+func (s *enrollmentTokenStore) addSigner(partner *pki.Certificate, raw []byte, signer *pki.PermanentCredentials) error {
+	token, issuer, err := rpc.LoadEnrollmentToken(raw, s.verifier)
+	if err != nil {
+		return fmt.Errorf("%w: %v", rpc.ErrInvalidArgument, err)
+	}
+
+	if partner == nil || !issuer.Equal(partner) {
+		return fmt.Errorf("%w: token was not issued by the registering user", rpc.ErrPermissionDenied)
+	}
+
+	if token.Signer == nil || !token.Signer.Equal(signer.Certificate()) || !signer.PrivateKey().PublicKey().Equal(signer.PublicKey()) {
+		return fmt.Errorf("%w: credentials do not belong to the signer of the token", rpc.ErrInvalidArgument)
+	}
+
+	password, err := util.GeneratePassword()
+	if err != nil {
+		return fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	key, err := signer.PrivateKey().PemEncode(password)
+	if err != nil {
+		return fmt.Errorf("failed to encode signer key: %w", err)
+	}
+
+	value, err := json.Marshal(&enrollmentSigner{
+		Certificate: signer.Certificate(),
+		Expires:     token.Expires,
+		Password:    password,
+		Key:         key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal signer: %w", err)
+	}
+
+	err = s.signers.Update(func(b db.Bucket) error {
+		return b.Put([]byte(token.ID), value)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	log.Printf("enrollment token %s of %s delegated to the server", token.ID, issuer.GetName())
+
+	return nil
+}
+
+// signer returns the credentials to issue agent certificates for the token, or nil if it has no signer.
+func (s *enrollmentTokenStore) signer(tokenID string) (*pki.PermanentCredentials, error) {
+	var credentials *pki.PermanentCredentials
+
+	err := s.signers.View(func(b db.Bucket) error {
+		raw := b.Get([]byte(tokenID))
+		if raw == nil {
+			return nil
+		}
+
+		signer := &enrollmentSigner{}
+		err := json.Unmarshal(raw, signer)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal signer: %w", err)
+		}
+
+		if signer.Key == nil || time.Now().After(signer.Expires) {
+			return nil
+		}
+
+		key, err := pki.PrivateKeyFromPem(signer.Key, signer.Password)
+		if err != nil {
+			return fmt.Errorf("failed to decode signer key: %w", err)
+		}
+
+		credentials = pki.CredentialsFromCertAndKey(signer.Certificate, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return credentials, nil
+}
+
+// certificates returns the certificates of all signers, they are intermediates of the agents they issued.
+func (s *enrollmentTokenStore) certificates() ([]*pki.Certificate, error) {
+	certs := make([]*pki.Certificate, 0)
+
+	err := s.signers.View(func(b db.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			signer := &enrollmentSigner{}
+			err := json.Unmarshal(v, signer)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal signer %s: %w", string(k), err)
+			}
+
+			certs = append(certs, signer.Certificate)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return certs, nil
+}
+
+// dropLoop keeps deleting the keys of expired signers while the server runs.
+func (s *enrollmentTokenStore) dropLoop() {
+	for {
+		time.Sleep(signerDropInterval)
+
+		err := s.dropExpiredSigners()
+		if err != nil {
+			log.Printf("error dropping expired enrollment signers: %v", err)
+		}
+	}
+}
+
+// dropExpiredSigners deletes the keys of signers whose token expired.
+func (s *enrollmentTokenStore) dropExpiredSigners() error {
+	err := s.signers.Update(func(b db.Bucket) error {
+		expired := make(map[string]*enrollmentSigner)
+
+		err := b.ForEach(func(k, v []byte) error {
+			signer := &enrollmentSigner{}
+			err := json.Unmarshal(v, signer)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal signer %s: %w", string(k), err)
+			}
+
+			if signer.Key != nil && time.Now().After(signer.Expires) {
+				expired[string(k)] = signer
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for id, signer := range expired {
+			signer.Key = nil
+			signer.Password = nil
+
+			value, err := json.Marshal(signer)
+			if err != nil {
+				return fmt.Errorf("failed to marshal signer: %w", err)
+			}
+
+			err = b.Put([]byte(id), value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// redeem checks a token presented by an enrolling agent and counts its use.
+// Single use tokens are spent here, even if the enrollment does not complete.
+func (s *enrollmentTokenStore) redeem(raw []byte) (*rpc.EnrollmentPreset, time.Time, error) {
+	token, issuer, err := rpc.LoadEnrollmentToken(raw, s.verifier)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var n uint64
+	err = s.scope.Update(func(b db.Bucket) error {
+		key := []byte(token.ID)
+
+		var used uint64
+		current := b.Get(key)
+		if current != nil {
+			used = binary.BigEndian.Uint64(current)
+		}
+
+		if token.SingleUse && used > 0 {
+			return errors.New("token was already used")
+		}
+
+		n = used + 1
+
+		count := make([]byte, 8)
+		binary.BigEndian.PutUint64(count, n)
+
+		return b.Put(key, count)
+	})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error redeeming token: %w", err)
+	}
+
+	preset := &rpc.EnrollmentPreset{
+		TokenID: token.ID,
+		Issuer:  issuer,
+		Name:    token.DeviceName(n),
+		Tags:    token.Tags,
+		Group:   token.Group,
+	}
+
+	log.Printf("enrollment token %s of %s redeemed for %s", token.ID, issuer.GetName(), preset.Name)
+
+	return preset, token.Expires, nil
+}
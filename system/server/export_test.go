@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/rahn-it/svalin/config"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
+)
+
+// Hooks for the tests in server_test, they are only compiled with the tests.
+
+// OpenTestServer sets the profile up for the server credentials, unless it was before, and opens the server.
+func OpenTestServer(profile *config.Profile, credentials *pki.PermanentCredentials, root *pki.Certificate) (*Server, error) {
+	scope := profile.Scope().Scope("server")
+
+	found, err := checkForServerConfig(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		err = initServerConfig(scope, credentials, root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	profile.Config().Save("server.address", "127.0.0.1:0")
+	profile.Config().Save("server.persist-nonces", "false")
+
+	return Open(profile)
+}
+
+// AddTestUser stores a user for the certificate, without any login data.
+func AddTestUser(s *Server, cert *pki.Certificate) error {
+	err := s.userStore.newUser(cert, nil, nil, nil, nil, "", nil)
+	if err != nil {
+		return err
+	}
+
+	return s.verifier.loadIntermediates()
+}
+
+// AddTestDevice stores a device as if it had been enrolled.
+func AddTestDevice(s *Server, cert *pki.Certificate) error {
+	return s.deviceStore.AddDevice(cert)
+}
+
+// RequestTestRenewal handles a renewal request of an agent.
+func RequestTestRenewal(s *Server, renewal *system.Renewal) error {
+	return s.requestRenewal(renewal)
+}
+
+// RenewTestDevice handles a renewed certificate sent by a user.
+func RenewTestDevice(s *Server, partner *pki.Certificate, device *pki.PublicKey, cert *pki.Certificate) error {
+	return s.renewDevice(partner, device, cert)
+}
+
+// PendingRenewal returns the renewal queued for the device.
+func PendingRenewal(s *Server, device *pki.PublicKey) (*system.Renewal, bool) {
+	return s.renewals.get(device.Base64Encode())
+}
@@ -2,8 +2,8 @@ package server
 
 import (
 	"bytes"
-	"crypto/x509"
 	"fmt"
+	"sync"
 
 	"github.com/rahn-it/svalin/pki"
 	"github.com/rahn-it/svalin/system"
@@ -12,17 +12,19 @@ import (
 var _ pki.Verifier = (*LocalCertificateVerifier)(nil)
 
 type LocalCertificateVerifier struct {
-	rootPool        *x509.CertPool
-	root            *pki.Certificate
-	intermediates   *x509.CertPool
+	anchors         *system.TrustAnchors
+	intermediates   []*pki.Certificate
+	mutex           sync.Mutex
 	userStore       *userStore
 	deviceStore     *deviceStore
 	revocationStore *system.RevocationStore
+	// signers returns the certificates enrollment tokens were delegated to.
+	signers func() ([]*pki.Certificate, error)
 }
 
-func newLocalCertificateVerifier(root *pki.Certificate, userStore *userStore, deviceStore *deviceStore, revocationStore *system.RevocationStore) (*LocalCertificateVerifier, error) {
-	if root == nil {
-		return nil, fmt.Errorf("root certificate cannot be nil")
+func newLocalCertificateVerifier(anchors *system.TrustAnchors, userStore *userStore, deviceStore *deviceStore, revocationStore *system.RevocationStore) (*LocalCertificateVerifier, error) {
+	if anchors == nil {
+		return nil, fmt.Errorf("trust anchors cannot be nil")
 	}
 
 	if userStore == nil {
@@ -37,32 +39,60 @@ func newLocalCertificateVerifier(root *pki.Certificate, userStore *userStore, de
 		return nil, fmt.Errorf("revocation store cannot be nil")
 	}
 
-	rootPool := x509.NewCertPool()
-	rootPool.AddCert(root.ToX509())
+	v := &LocalCertificateVerifier{
+		anchors:         anchors,
+		userStore:       userStore,
+		deviceStore:     deviceStore,
+		revocationStore: revocationStore,
+	}
 
-	intermediates := x509.NewCertPool()
+	err := v.loadIntermediates()
+	if err != nil {
+		return nil, err
+	}
 
-	err := userStore.forEach(func(user *system.User) error {
-		intermediates.AddCert(user.Certificate.ToX509())
+	return v, nil
+}
+
+// loadIntermediates collects the user certificates from the user store and the enrollment signers.
+// It needs to be called again whenever they are re-issued or a signer is added.
+func (v *LocalCertificateVerifier) loadIntermediates() error {
+	intermediates := make([]*pki.Certificate, 0)
+
+	err := v.userStore.forEach(func(user *system.User) error {
+		intermediates = append(intermediates, user.Certificate)
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to add intermediates: %w", err)
+		return fmt.Errorf("failed to add intermediates: %w", err)
 	}
 
-	return &LocalCertificateVerifier{
-		rootPool:        rootPool,
-		root:            root,
-		intermediates:   intermediates,
-		userStore:       userStore,
-		deviceStore:     deviceStore,
-		revocationStore: revocationStore,
-	}, nil
+	if v.signers != nil {
+		signers, err := v.signers()
+		if err != nil {
+			return fmt.Errorf("failed to add signers: %w", err)
+		}
+
+		intermediates = append(intermediates, signers...)
+	}
+
+	v.mutex.Lock()
+	v.intermediates = intermediates
+	v.mutex.Unlock()
+
+	return nil
+}
+
+// trustSigners adds the enrollment signers to the intermediates.
+func (v *LocalCertificateVerifier) trustSigners(signers func() ([]*pki.Certificate, error)) error {
+	v.signers = signers
+	return v.loadIntermediates()
 }
 
 func (v *LocalCertificateVerifier) Verify(cert *pki.Certificate) ([]*pki.Certificate, error) {
-	if cert.Equal(v.root) {
-		return []*pki.Certificate{v.root}, nil
+	root := v.anchors.RootFor(cert.PublicKey())
+	if root != nil && cert.Equal(root) {
+		return []*pki.Certificate{root}, nil
 	}
 
 	chain, err := v.verifyChain(cert)
@@ -83,8 +113,9 @@ func (v *LocalCertificateVerifier) Verify(cert *pki.Certificate) ([]*pki.Certifi
 }
 
 func (v *LocalCertificateVerifier) VerifyPublicKey(pub *pki.PublicKey) ([]*pki.Certificate, error) {
-	if pub.Equal(v.root.PublicKey()) {
-		return []*pki.Certificate{v.root}, nil
+	root := v.anchors.RootFor(pub)
+	if root != nil {
+		return []*pki.Certificate{root}, nil
 	}
 
 	cert, err := v.findCertificate(pub)
@@ -102,6 +133,10 @@ func (v *LocalCertificateVerifier) findCertificate(pub *pki.PublicKey) (*pki.Cer
 	}
 
 	if user != nil {
+		if user.Disabled {
+			return nil, fmt.Errorf("user %s is disabled", user.Certificate.GetName())
+		}
+
 		return user.Certificate, nil
 	}
 
@@ -118,7 +153,15 @@ func (v *LocalCertificateVerifier) findCertificate(pub *pki.PublicKey) (*pki.Cer
 }
 
 func (v *LocalCertificateVerifier) verifyChain(cert *pki.Certificate) ([]*pki.Certificate, error) {
-	chain, err := cert.VerifyChain(v.rootPool, v.intermediates)
+	intermediates := v.anchors.Intermediates()
+
+	v.mutex.Lock()
+	for _, c := range v.intermediates {
+		intermediates.AddCert(c.ToX509())
+	}
+	v.mutex.Unlock()
+
+	chain, err := cert.VerifyChain(v.anchors.Roots(), intermediates)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify certificate: %w", err)
 	}
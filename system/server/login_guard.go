@@ -0,0 +1,267 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/system"
+)
+
+var _ system.LoginGuard = (*loginGuard)(nil)
+
+const (
+	// loginBaseDelay is the delay after the first failure, it doubles with every further one.
+	loginBaseDelay = time.Second
+	loginMaxDelay  = 30 * time.Second
+	// failures are forgotten once no attempt failed for this long.
+	loginFailureWindow = time.Hour
+	loginLockout       = 15 * time.Minute
+	// an address gets more attempts than a user, several users can share it.
+	maxUserLoginFailures = 5
+	maxAddrLoginFailures = 20
+	// forgotten failures are removed this often, failed logins for unknown users would pile up otherwise.
+	loginPruneInterval = 10 * time.Minute
+)
+
+// loginGuard counts failed logins per user and per source address.
+// Failures slow down further attempts and eventually lock them out for a while.
+type loginGuard struct {
+	scope db.Scope
+	audit *system.AuditLog
+}
+
+type loginFailures struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+func openLoginGuard(scope db.Scope, audit *system.AuditLog) (*loginGuard, error) {
+	return &loginGuard{
+		scope: scope,
+		audit: audit,
+	}, nil
+}
+
+func loginUserEntry(username string) []byte {
+	return []byte("user:" + username)
+}
+
+func loginAddrEntry(addr net.Addr) []byte {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	return []byte("addr:" + host)
+}
+
+func loginTotpEntry(username string) []byte {
+	return []byte("totp:" + username)
+}
+
+func getLoginFailures(b db.Bucket, entry []byte) (loginFailures, error) {
+	failures := loginFailures{}
+
+	raw := b.Get(entry)
+	if raw == nil {
+		return failures, nil
+	}
+
+	err := json.Unmarshal(raw, &failures)
+	if err != nil {
+		return failures, fmt.Errorf("failed to unmarshal login failures: %w", err)
+	}
+
+	if time.Since(failures.LastFailure) > loginFailureWindow && time.Now().After(failures.LockedUntil) {
+		return loginFailures{}, nil
+	}
+
+	return failures, nil
+}
+
+func (f loginFailures) delay() time.Duration {
+	if f.Failures == 0 {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := 1; i < f.Failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+
+	return delay
+}
+
+// Check returns how long the attempt has to be delayed, it fails while the user or address is locked out.
+func (g *loginGuard) Check(username string, addr net.Addr) (time.Duration, error) {
+	var delay time.Duration
+
+	err := g.scope.View(func(b db.Bucket) error {
+		for _, entry := range [][]byte{loginUserEntry(username), loginAddrEntry(addr)} {
+			failures, err := getLoginFailures(b, entry)
+			if err != nil {
+				return err
+			}
+
+			if time.Now().Before(failures.LockedUntil) {
+				return fmt.Errorf("%s is locked out until %s", entry, failures.LockedUntil.Format(time.RFC3339))
+			}
+
+			if d := failures.delay(); d > delay {
+				delay = d
+			}
+		}
+
+		return nil
+	})
+
+	return delay, err
+}
+
+// Failed counts a failed attempt and locks out the user or address once too many failed.
+func (g *loginGuard) Failed(username string, addr net.Addr) error {
+	locked := make([]string, 0, 2)
+
+	err := g.scope.Update(func(b db.Bucket) error {
+		limits := map[string]int{
+			string(loginUserEntry(username)): maxUserLoginFailures,
+			string(loginAddrEntry(addr)):     maxAddrLoginFailures,
+		}
+
+		for entry, limit := range limits {
+			failures, err := getLoginFailures(b, []byte(entry))
+			if err != nil {
+				return err
+			}
+
+			failures.Failures++
+			failures.LastFailure = time.Now()
+
+			if failures.Failures >= limit {
+				failures.LockedUntil = time.Now().Add(loginLockout)
+				locked = append(locked, entry)
+			}
+
+			raw, err := json.Marshal(failures)
+			if err != nil {
+				return fmt.Errorf("failed to marshal login failures: %w", err)
+			}
+
+			err = b.Put([]byte(entry), raw)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	for _, entry := range locked {
+		g.audit.Record(system.AuditEvent{
+			Type:    system.AuditLoginLockedOut,
+			Subject: username,
+			Addr:    addr.String(),
+			Detail:  fmt.Sprintf("%s locked out for %s", entry, loginLockout),
+		})
+	}
+
+	return nil
+}
+
+func (g *loginGuard) pruneLoop() {
+	for {
+		time.Sleep(loginPruneInterval)
+
+		err := g.prune()
+		if err != nil {
+			log.Printf("error pruning login failures: %v", err)
+		}
+	}
+}
+
+// prune deletes the failures of users and addresses that are forgotten anyway.
+func (g *loginGuard) prune() error {
+	err := g.scope.Update(func(b db.Bucket) error {
+		expired := make([][]byte, 0)
+
+		for _, prefix := range []string{"user:", "addr:"} {
+			err := b.ForPrefix([]byte(prefix), func(k, v []byte) error {
+				failures, err := getLoginFailures(b, k)
+				if err != nil {
+					return err
+				}
+
+				if failures.Failures == 0 {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range expired {
+			err := b.Delete(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Succeeded forgets the failures of the user.
+// The address keeps its failures, a single valid account must not unlock guessing others.
+func (g *loginGuard) Succeeded(username string, addr net.Addr) error {
+	err := g.scope.Update(func(b db.Bucket) error {
+		return b.Delete(loginUserEntry(username))
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseTotp remembers the time step of the last accepted TOTP code of a user.
+// It fails for codes of that step or an earlier one, so every code is only accepted once.
+func (g *loginGuard) UseTotp(username string, step uint64) error {
+	err := g.scope.Update(func(b db.Bucket) error {
+		entry := loginTotpEntry(username)
+
+		raw := b.Get(entry)
+		if raw != nil && binary.BigEndian.Uint64(raw) >= step {
+			return system.ErrTotpReplay
+		}
+
+		raw = make([]byte, 8)
+		binary.BigEndian.PutUint64(raw, step)
+
+		return b.Put(entry, raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
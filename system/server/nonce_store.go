@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/util"
+)
+
+// nonceSaveInterval is the replay window a crash can reopen, a regular shutdown doesn't lose any nonces.
+const nonceSaveInterval = 5 * time.Second
+
+var recentNoncesKey = []byte("recent")
+
+// nonceStore persists the nonces the RPC server saw recently,
+// so messages from before a restart can't be replayed while they are not expired yet.
+type nonceStore struct {
+	scope  db.Scope
+	nonces *util.NonceStorage
+}
+
+func openNonceStore(scope db.Scope, nonces *util.NonceStorage) (*nonceStore, error) {
+	n := &nonceStore{
+		scope:  scope,
+		nonces: nonces,
+	}
+
+	err := n.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (n *nonceStore) load() error {
+	var recent []util.SeenNonce
+
+	err := n.scope.View(func(b db.Bucket) error {
+		raw := b.Get(recentNoncesKey)
+		if raw == nil {
+			return nil
+		}
+
+		return json.Unmarshal(raw, &recent)
+	})
+	if err != nil {
+		return fmt.Errorf("error loading recent nonces: %w", err)
+	}
+
+	n.nonces.Import(recent)
+
+	return nil
+}
+
+func (n *nonceStore) save() error {
+	raw, err := json.Marshal(n.nonces.Export())
+	if err != nil {
+		return fmt.Errorf("failed to marshal nonces: %w", err)
+	}
+
+	return n.scope.Update(func(b db.Bucket) error {
+		return b.Put(recentNoncesKey, raw)
+	})
+}
+
+func (n *nonceStore) saveLoop() {
+	for {
+		time.Sleep(nonceSaveInterval)
+
+		err := n.save()
+		if err != nil {
+			log.Printf("error saving recent nonces: %v", err)
+		}
+	}
+}
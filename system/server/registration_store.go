@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/util"
+)
+
+const registrationPrefix = "registration_"
+const invitePrefix = "invite_"
+
+// registrationStore keeps redeemed invites until their user certificate is issued.
+// The registrations without secrets are published to the admins.
+type registrationStore struct {
+	scope         db.Scope
+	registrations util.UpdateableMap[string, *system.Registration]
+}
+
+func openRegistrationStore(scope db.Scope) (*registrationStore, error) {
+	registrations := util.NewObservableMap[string, *system.Registration]()
+
+	err := scope.View(func(b db.Bucket) error {
+		return b.ForPrefix([]byte(registrationPrefix), func(k, v []byte) error {
+			pending := &system.PendingRegistration{}
+			err := json.Unmarshal(v, pending)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal registration %s: %w", string(k), err)
+			}
+
+			registrations.Set(pending.Registration.PublicKey.Base64Encode(), pending.Registration)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading registrations: %w", err)
+	}
+
+	return &registrationStore{
+		scope:         scope,
+		registrations: registrations,
+	}, nil
+}
+
+// add stores a registration and marks its invite as used.
+func (s *registrationStore) add(inviteID string, pending *system.PendingRegistration) error {
+	key := pending.Registration.PublicKey.Base64Encode()
+
+	raw, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("failed to marshal registration: %w", err)
+	}
+
+	err = s.scope.Update(func(b db.Bucket) error {
+		if b.Get([]byte(invitePrefix+inviteID)) != nil {
+			return errors.New("invite was already used")
+		}
+
+		if b.Get([]byte(registrationPrefix+key)) != nil {
+			return errors.New("public key already in use")
+		}
+
+		err := b.Put([]byte(invitePrefix+inviteID), []byte(key))
+		if err != nil {
+			return fmt.Errorf("failed to mark invite as used: %w", err)
+		}
+
+		return b.Put([]byte(registrationPrefix+key), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	s.registrations.Set(key, pending.Registration)
+
+	return nil
+}
+
+// get returns the pending registration for the key, or nil if there is none.
+func (s *registrationStore) get(publicKey *pki.PublicKey) (*system.PendingRegistration, error) {
+	var pending *system.PendingRegistration
+
+	err := s.scope.View(func(b db.Bucket) error {
+		raw := b.Get([]byte(registrationPrefix + publicKey.Base64Encode()))
+		if raw == nil {
+			return nil
+		}
+
+		pending = &system.PendingRegistration{}
+		return json.Unmarshal(raw, pending)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return pending, nil
+}
+
+func (s *registrationStore) remove(publicKey *pki.PublicKey) error {
+	key := publicKey.Base64Encode()
+
+	err := s.scope.Update(func(b db.Bucket) error {
+		return b.Delete([]byte(registrationPrefix + key))
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	s.registrations.Delete(key)
+
+	return nil
+}
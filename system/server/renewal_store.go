@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/util"
+)
+
+// renewalStore keeps the pending renewals of agents, so they survive a restart of the server.
+// The renewals are published to the clients that re-sign them.
+type renewalStore struct {
+	scope    db.Scope
+	renewals util.UpdateableMap[string, *system.Renewal]
+}
+
+func openRenewalStore(scope db.Scope) (*renewalStore, error) {
+	renewals := util.NewObservableMap[string, *system.Renewal]()
+
+	err := scope.View(func(b db.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			renewal := &system.Renewal{}
+			err := json.Unmarshal(v, renewal)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal renewal %s: %w", string(k), err)
+			}
+
+			renewals.Set(string(k), renewal)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading renewals: %w", err)
+	}
+
+	return &renewalStore{
+		scope:    scope,
+		renewals: renewals,
+	}, nil
+}
+
+// set stores the renewal for the device with the given key, replacing a previous one.
+func (s *renewalStore) set(key string, renewal *system.Renewal) error {
+	raw, err := json.Marshal(renewal)
+	if err != nil {
+		return fmt.Errorf("failed to marshal renewal: %w", err)
+	}
+
+	err = s.scope.Update(func(b db.Bucket) error {
+		return b.Put([]byte(key), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	s.renewals.Set(key, renewal)
+
+	return nil
+}
+
+func (s *renewalStore) get(key string) (*system.Renewal, bool) {
+	return s.renewals.Get(key)
+}
+
+func (s *renewalStore) remove(key string) error {
+	err := s.scope.Update(func(b db.Bucket) error {
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	s.renewals.Delete(key)
+
+	return nil
+}
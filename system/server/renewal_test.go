@@ -0,0 +1,158 @@
+package server_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/config"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/system/server"
+)
+
+func issueTestCredentials(t *testing.T, issue func(pub *pki.PublicKey) (*pki.Certificate, error)) *pki.PermanentCredentials {
+	temp, err := pki.GenerateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := issue(temp.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := temp.ToPermanentCredentials(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentials
+}
+
+type testServer struct {
+	root    *pki.PermanentCredentials
+	server  *pki.PermanentCredentials
+	profile *config.Profile
+	*server.Server
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	root, err := pki.GenerateRootCredentials("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		root: root,
+		server: issueTestCredentials(t, func(pub *pki.PublicKey) (*pki.Certificate, error) {
+			return pki.CreateServerCert("server", pub, root)
+		}),
+	}
+
+	s.open(t)
+
+	return s
+}
+
+// open opens the server, after closing it if it is running.
+func (s *testServer) open(t *testing.T) {
+	s.close()
+
+	profile, err := config.OpenProfile("test", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := server.OpenTestServer(profile, s.server, s.root.Certificate())
+	if err != nil {
+		profile.DB().Close()
+		t.Fatal(err)
+	}
+
+	s.profile = profile
+	s.Server = srv
+	t.Cleanup(s.close)
+}
+
+func (s *testServer) close() {
+	if s.Server == nil {
+		return
+	}
+
+	s.Server.Close(0, "test done")
+	s.profile.DB().Close()
+	s.Server = nil
+}
+
+func (s *testServer) addUser(t *testing.T, name string) *pki.PermanentCredentials {
+	user := issueTestCredentials(t, func(pub *pki.PublicKey) (*pki.Certificate, error) {
+		return pki.CreateUserCert(name, pub, s.root)
+	})
+
+	err := server.AddTestUser(s.Server, user.Certificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestRenewalWaitsWithoutAdmin(t *testing.T) {
+	s := newTestServer(t)
+
+	issuer := s.addUser(t, "issuer")
+	operator := s.addUser(t, "operator")
+
+	agent := issueTestCredentials(t, func(pub *pki.PublicKey) (*pki.Certificate, error) {
+		return pki.CreateAgentCert("agent", pub, issuer)
+	})
+
+	err := server.AddTestDevice(s.Server, agent.Certificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the issuer nor an admin is connected
+	err = server.RequestTestRenewal(s.Server, &system.Renewal{
+		Device:      agent.Certificate(),
+		PublicKey:   agent.PublicKey(),
+		RequestTime: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("failed to request renewal: %v", err)
+	}
+
+	renewal, ok := server.PendingRenewal(s.Server, agent.PublicKey())
+	if !ok {
+		t.Fatal("renewal is not pending")
+	}
+
+	if !renewal.AnyAdmin {
+		t.Error("renewal is not left to admins under the admins policy")
+	}
+
+	if !renewal.Issuer.Equal(issuer.Certificate()) {
+		t.Error("renewal does not name the issuing user")
+	}
+
+	cert, err := pki.CreateAgentCert("agent", agent.PublicKey(), operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.RenewTestDevice(s.Server, operator.Certificate(), agent.PublicKey(), cert)
+	if !errors.Is(err, system.ErrPermissionDenied) {
+		t.Errorf("expected a user who is not an admin to be denied, got %v", err)
+	}
+
+	// the renewal waits for an admin or the issuer, even across a restart
+	s.open(t)
+
+	_, ok = server.PendingRenewal(s.Server, agent.PublicKey())
+	if !ok {
+		t.Error("renewal got lost on restart")
+	}
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/quic-go/quic-go"
 	"github.com/rahn-it/svalin/config"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rmm"
 	"github.com/rahn-it/svalin/rpc"
 	"github.com/rahn-it/svalin/system"
 	"github.com/rahn-it/svalin/util"
@@ -13,14 +19,31 @@ import (
 
 type Server struct {
 	*rpc.RpcServer
-	serverConfig    *serverConfig
-	profile         *config.Profile
-	userStore       *userStore
-	deviceStore     *deviceStore
-	revocationStore *system.RevocationStore
-	verifier        *LocalCertificateVerifier
-	devices         util.ObservableMap[string, *system.DeviceInfo]
-	configManager   *ConfigManager
+	serverConfig     *serverConfig
+	profile          *config.Profile
+	userStore        *userStore
+	deviceStore      *deviceStore
+	deviceAttributes *deviceAttributeStore
+	blocklist        *enrollmentBlocklist
+	tokenStore       *enrollmentTokenStore
+	registrations    *registrationStore
+	audit            *system.AuditLog
+	deviceAudit      *deviceAuditStore
+	shellRecordings  *shellRecordingStore
+	revocationStore  *system.RevocationStore
+	verifier         *LocalCertificateVerifier
+	devices          util.ObservableMap[string, *system.DeviceInfo]
+	renewals         *renewalStore
+	renewalPolicy    string
+	loginGuard       *loginGuard
+	totpSkew         uint
+	configManager    *ConfigManager
+	commandMetrics   *rpc.CommandMetrics
+	// nonces is nil if persisting them is disabled.
+	nonces       *nonceStore
+	loginHandler interface {
+		UpdateRoot(root *pki.Certificate, upstream *pki.Certificate)
+	}
 }
 
 func Open(profile *config.Profile) (*Server, error) {
@@ -28,6 +51,15 @@ func Open(profile *config.Profile) (*Server, error) {
 
 	config := profile.Config()
 	config.Default("server.address", "localhost:1234")
+	config.Default("server.totp-skew", "0")
+	config.Default("server.persist-nonces", "true")
+	config.Default("server.renewal-policy", system.RenewalPolicyAdmins)
+	config.Default("server.limits.max-streams", "100")
+	config.Default("server.limits.sessions-per-peer", "64")
+	config.Default("server.limits.sessions-per-ip", "256")
+	config.Default("server.limits.session-rate", "20")
+	config.Default("server.limits.session-burst", "50")
+	config.Default("server.limits.bandwidth", "0")
 
 	scope := profile.Scope()
 
@@ -41,7 +73,9 @@ func Open(profile *config.Profile) (*Server, error) {
 		return nil, fmt.Errorf("error opening user store: %w", err)
 	}
 
-	chainVerifier, error := newChainVerifier(serverConfig.Root())
+	anchors := serverConfig.Anchors()
+
+	chainVerifier, error := newChainVerifier(anchors)
 	if error != nil {
 		return nil, fmt.Errorf("error creating new user verifier: %w", error)
 	}
@@ -51,16 +85,81 @@ func Open(profile *config.Profile) (*Server, error) {
 		return nil, fmt.Errorf("error opening device store: %w", err)
 	}
 
+	deviceAttributes, err := openDeviceAttributeStore(scope.Scope("device-attributes"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening device attribute store: %w", err)
+	}
+
+	deviceAudit, err := openDeviceAuditStore(scope.Scope("device-audit"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening device audit store: %w", err)
+	}
+
 	revocationStore, err := system.OpenRevocationStore(scope.Scope("revocation"), serverConfig.Root())
 	if err != nil {
 		return nil, fmt.Errorf("error opening revocation store: %w", err)
 	}
 
-	verifier, err := newLocalCertificateVerifier(serverConfig.Root(), userStore, deviceStore, revocationStore)
+	verifier, err := newLocalCertificateVerifier(anchors, userStore, deviceStore, revocationStore)
 	if err != nil {
 		return nil, fmt.Errorf("error creating local certificate verifier: %w", err)
 	}
 
+	tokenStore, err := openEnrollmentTokenStore(scope.Scope("enrollment-tokens"), verifier)
+	if err != nil {
+		return nil, fmt.Errorf("error opening enrollment token store: %w", err)
+	}
+
+	go tokenStore.dropLoop()
+
+	err = verifier.trustSigners(tokenStore.certificates)
+	if err != nil {
+		return nil, fmt.Errorf("error loading enrollment signers: %w", err)
+	}
+
+	blocklist, err := openEnrollmentBlocklist(scope.Scope("enrollment-blocklist"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening enrollment blocklist: %w", err)
+	}
+
+	registrations, err := openRegistrationStore(scope.Scope("registrations"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening registration store: %w", err)
+	}
+
+	audit, err := system.OpenAuditLog(scope.Scope("audit"), func() pki.Credentials {
+		return serverConfig.Credentials()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	err = audit.VerifyHead()
+	if err != nil {
+		log.Printf("WARNING: audit log is broken: %v", err)
+	}
+
+	loginGuard, err := openLoginGuard(scope.Scope("login-guard"), audit)
+	if err != nil {
+		return nil, fmt.Errorf("error opening login guard: %w", err)
+	}
+
+	go loginGuard.pruneLoop()
+
+	renewals, err := openRenewalStore(scope.Scope("renewals"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening renewal store: %w", err)
+	}
+
+	renewalPolicy := config.String("server.renewal-policy")
+	if renewalPolicy != system.RenewalPolicyIssuer && renewalPolicy != system.RenewalPolicyAdmins {
+		return nil, fmt.Errorf("unknown renewal policy %q", renewalPolicy)
+	}
+
+	shellRecordings, err := openShellRecordingStore(scope.Scope("shell-recordings"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening shell recording store: %w", err)
+	}
+
 	// ConfigManager := NewConfigManager(verifier, nil)
 
 	// devices := newDeviceList(deviceStore)
@@ -70,32 +169,54 @@ func Open(profile *config.Profile) (*Server, error) {
 		// rmm.CreateGetDevicesCommandHandler(devices),
 		rpc.ForwardCommandHandler,
 		system.CreateUpstreamVerificationCommandHandler(verifier),
-		system.CreateRegisterUserCommandHandler(chainVerifier, userStore.newUser),
+		system.CreateRegisterUserCommandHandler(chainVerifier, loginGuard, userStore.newUser),
 	)
 
+	cmds.RecordSessions(audit.RecordSession)
+
 	listenAddr := config.String("server.address")
 
-	rpcS, err := rpc.NewRpcServer(listenAddr, cmds, verifier, serverConfig.Credentials(), serverConfig.Root())
+	limits := rpc.Limits{
+		MaxIncomingStreams: int64(config.Int("server.limits.max-streams")),
+		MaxSessionsPerPeer: config.Int("server.limits.sessions-per-peer"),
+		MaxSessionsPerIP:   config.Int("server.limits.sessions-per-ip"),
+		SessionRate:        config.Int("server.limits.session-rate"),
+		SessionBurst:       config.Int("server.limits.session-burst"),
+		Bandwidth:          config.Int("server.limits.bandwidth"),
+	}
+
+	rpcS, err := rpc.NewRpcServer(listenAddr, cmds, verifier, serverConfig.Credentials(), serverConfig.Root(), limits)
 	if err != nil {
 		return nil, fmt.Errorf("error creating rpc server: %w", err)
 	}
 
 	cmds.Add(system.CreateGetEnrollmentsCommandHandler(rpcS.Enrollments()))
 
-	cmds.Add(system.CreateEnrollDeviceCommandHandler(rpcS.Enrollments(), chainVerifier, deviceStore.AddDevice))
+	rpcS.EnrollmentTokenHandler(tokenStore.redeem)
+	rpcS.EnrollmentFilter(blocklist.check)
+
+	var nonces *nonceStore
+	if config.Bool("server.persist-nonces") {
+		nonces, err = openNonceStore(scope.Scope("nonces"), rpcS.Nonces())
+		if err != nil {
+			return nil, fmt.Errorf("error opening nonce store: %w", err)
+		}
+
+		go nonces.saveLoop()
+	}
 
-	devices := newDeviceList(deviceStore)
+	devices := newDeviceList(deviceStore, deviceAttributes)
 	rpcS.Connections().Subscribe(
 		func(u uuid.UUID, rc *rpc.RpcConnection) {
 			partner := rc.Partner()
 			if partner != nil {
-				devices.setOnlineStatus(partner.PublicKey().Base64Encode(), true)
+				devices.setOnlineStatus(partner.PublicKey().Base64Encode(), true, rc)
 			}
 		},
 		func(u uuid.UUID, rc *rpc.RpcConnection) {
 			partner := rc.Partner()
 			if partner != nil {
-				devices.setOnlineStatus(partner.PublicKey().Base64Encode(), false)
+				devices.setOnlineStatus(partner.PublicKey().Base64Encode(), false, nil)
 			}
 		},
 	)
@@ -114,27 +235,1026 @@ func Open(profile *config.Profile) (*Server, error) {
 	// )
 
 	s := &Server{
-		RpcServer:       rpcS,
-		profile:         profile,
-		userStore:       userStore,
-		deviceStore:     deviceStore,
-		revocationStore: revocationStore,
-		verifier:        verifier,
-		devices:         devices,
-		serverConfig:    serverConfig,
+		RpcServer:        rpcS,
+		profile:          profile,
+		userStore:        userStore,
+		deviceStore:      deviceStore,
+		deviceAttributes: deviceAttributes,
+		blocklist:        blocklist,
+		tokenStore:       tokenStore,
+		registrations:    registrations,
+		audit:            audit,
+		deviceAudit:      deviceAudit,
+		shellRecordings:  shellRecordings,
+		revocationStore:  revocationStore,
+		verifier:         verifier,
+		devices:          devices,
+		renewals:         renewals,
+		renewalPolicy:    renewalPolicy,
+		loginGuard:       loginGuard,
+		totpSkew:         uint(config.Int("server.totp-skew")),
+		serverConfig:     serverConfig,
+		nonces:           nonces,
+		commandMetrics:   cmds.Metrics(),
 		// configManager:   ConfigManager,
 	}
 
+	cmds.Add(system.CreateEnrollDeviceCommandHandler(rpcS.Enrollments(), chainVerifier, s.addDevice))
+	cmds.Add(system.CreateRejectEnrollmentCommandHandler(s.rejectEnrollment))
+	cmds.Add(system.CreateRegisterEnrollmentSignerCommandHandler(s.addEnrollmentSigner))
+	cmds.Add(system.CreateListUsersCommandHandler(s.listUsers))
+	cmds.Add(system.CreateUpdateUserCommandHandler(s.updateUser))
+	cmds.Add(system.CreateDeleteUserCommandHandler(s.deleteUser))
+	cmds.Add(system.CreateChangePasswordCommandHandler(s.changePassword))
+	cmds.Add(system.CreateResetTotpCommandHandler(s.resetTotp))
+	cmds.Add(system.CreateConfirmTotpCommandHandler(s.confirmTotp))
+	cmds.Add(system.CreateRegenerateRecoveryCodesCommandHandler(s.replaceRecoveryCodes))
+	cmds.Add(system.CreateQueryAuditLogCommandHandler(s.queryAuditLog))
+	cmds.Add(system.CreateGetCommandMetricsCommandHandler(s.getCommandMetrics))
+	cmds.Add(system.CreateUploadAuditTrailCommandHandler(s.importAuditTrail))
+	cmds.Add(rmm.CreateUploadShellRecordingCommandHandler(s.storeShellRecording))
+	cmds.Add(rmm.CreateGetShellRecordingsCommandHandler(s.getShellRecordings))
+	cmds.Add(system.CreateGetPendingRegistrationsCommandHandler(registrations.registrations))
+	cmds.Add(system.CreateCompleteRegistrationCommandHandler(s.completeRegistration))
+	cmds.Add(system.CreateRenameDeviceCommandHandler(s.renameDevice))
+	cmds.Add(system.CreateRequestRenewalCommandHandler(s.requestRenewal))
+	cmds.Add(system.CreateGetPendingRenewalsCommandHandler(renewals.renewals))
+	cmds.Add(system.CreateRenewDeviceCommandHandler(s.renewDevice))
+	cmds.Add(system.CreateGetRolloverUsersCommandHandler(anchors, userStore.certificates))
+	cmds.Add(system.CreateBeginRootRolloverCommandHandler(anchors, s.beginRootRollover))
+	cmds.Add(system.CreateGetRootRolloverCommandHandler(s.getRootRollover))
+	cmds.Add(system.CreateGetRevocationsCommandHandler(s.getRevocations))
+
+	rpcS.AutoEnrollment(s.autoEnroll)
+	cmds.Intercept(s.checkRole)
+
+	anchors.OnRetire(s.retireRoot)
+
 	loginHandler := system.NewLoginHandler(userStore.getUserByName, serverConfig.Seed(), serverConfig.Root(), serverConfig.Credentials().Certificate())
+	loginHandler.HandleInvites(s.registerInvite)
+	loginHandler.Guard(loginGuard, audit.Record)
+	loginHandler.HandleRecoveryCodes(s.useRecoveryCode)
+	loginHandler.TotpSkew(s.totpSkew)
 	rpcS.LoginHandler(loginHandler.HandleLoginRequest)
+	s.loginHandler = loginHandler
 
 	return s, nil
 }
 
+// addDevice stores an enrolled device together with the attributes preset by its enrollment token.
+func (s *Server) addDevice(cert *pki.Certificate, enrollment *rpc.Enrollment) error {
+	if enrollment.Preset != nil {
+		err := s.deviceAttributes.set(cert.PublicKey().Base64Encode(), system.DeviceAttributes{
+			Tags:  enrollment.Preset.Tags,
+			Group: enrollment.Preset.Group,
+		})
+		if err != nil {
+			return fmt.Errorf("error saving device attributes: %w", err)
+		}
+	}
+
+	return s.deviceStore.AddDevice(cert)
+}
+
+// addEnrollmentSigner stores the signer a user delegated a token to and trusts the agents it issues.
+func (s *Server) addEnrollmentSigner(partner *pki.Certificate, token []byte, signer *pki.PermanentCredentials) error {
+	err := s.tokenStore.addSigner(partner, token, signer)
+	if err != nil {
+		return err
+	}
+
+	err = s.verifier.loadIntermediates()
+	if err != nil {
+		return fmt.Errorf("error reloading intermediates: %w", err)
+	}
+
+	return nil
+}
+
+// autoEnroll issues the certificate for an enrollment that presented a token delegated to the server.
+// Enrollments with tokens that were not delegated wait for a user to accept them.
+func (s *Server) autoEnroll(enrollment *rpc.Enrollment) {
+	preset := enrollment.Preset
+
+	signer, err := s.tokenStore.signer(preset.TokenID)
+	if err != nil {
+		log.Printf("error loading signer of enrollment token %s: %v", preset.TokenID, err)
+		return
+	}
+
+	if signer == nil {
+		return
+	}
+
+	cert, err := pki.CreateAgentCert(preset.Name, enrollment.PublicKey, signer)
+	if err != nil {
+		log.Printf("error creating agent certificate for %s: %v", preset.Name, err)
+		return
+	}
+
+	accepted, err := s.RpcServer.Enrollments().AcceptEnrollment(cert, "")
+	if err != nil {
+		log.Printf("error accepting enrollment of %s: %v", preset.Name, err)
+		return
+	}
+
+	err = s.addDevice(cert, accepted)
+	if err != nil {
+		log.Printf("error adding device %s: %v", preset.Name, err)
+		return
+	}
+
+	log.Printf("enrolled device %s with token %s", preset.Name, preset.TokenID)
+}
+
+// deviceIssuer returns the user who issued the certificate of a device.
+// Devices enrolled with a token are issued by its signer, they belong to the user who created the token.
+func (s *Server) deviceIssuer(device *pki.Certificate) (*pki.Certificate, error) {
+	chain, err := s.verifier.verifyChain(device)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying device certificate: %w", err)
+	}
+
+	for _, cert := range chain[1:] {
+		if cert.Type() == pki.CertTypeUser || cert.Type() == pki.CertTypeRoot {
+			return cert, nil
+		}
+	}
+
+	return nil, fmt.Errorf("device certificate was not issued by a user")
+}
+
+func (s *Server) rejectEnrollment(key *pki.PublicKey, reason string, block bool) error {
+	enrollment, err := s.RpcServer.Enrollments().RejectEnrollment(key, reason)
+	if err != nil {
+		return err
+	}
+
+	if !block {
+		return nil
+	}
+
+	err = s.blocklist.block(enrollment, reason)
+	if err != nil {
+		return fmt.Errorf("error blocking enrollment: %w", err)
+	}
+
+	log.Printf("blocked enrollments from %s", enrollment.Addr)
+
+	return nil
+}
+
+// requireAdmin fails unless the partner is the root or a user with the admin role.
+func (s *Server) requireAdmin(partner *pki.Certificate) error {
+	return s.requireRole(partner, system.RoleAdmin)
+}
+
+// requireRole fails unless the partner is the root or a user permitted to act in the role.
+func (s *Server) requireRole(partner *pki.Certificate, role string) error {
+	if partner == nil {
+		return system.ErrPermissionDenied
+	}
+
+	if partner.Type() == pki.CertTypeRoot {
+		return nil
+	}
+
+	if partner.Type() != pki.CertTypeUser {
+		return system.ErrPermissionDenied
+	}
+
+	user, err := s.userStore.getUser(partner.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	if user == nil || user.Disabled || !user.Permits(role) {
+		return system.ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// commandRoles maps the commands on devices to the role they require.
+// Commands missing here are not restricted by roles, user administration requires admins on its own.
+// Sessions on agents are end to end encrypted, so the server can only restrict forwarding as a whole.
+var commandRoles = map[string]string{
+	"get-devices":                system.RoleViewer,
+	"get-pending-enrollments":    system.RoleViewer,
+	"get-pending-renewals":       system.RoleViewer,
+	"forward":                    system.RoleOperator,
+	"enroll-device":              system.RoleOperator,
+	"reject-enrollment":          system.RoleOperator,
+	"rename-device":              system.RoleOperator,
+	"renew-device":               system.RoleOperator,
+	"register-enrollment-signer": system.RoleOperator,
+	"get-shell-recordings":       system.RoleOperator,
+}
+
+// checkRole rejects commands the partner lacks the role for.
+func (s *Server) checkRole(session *rpc.RpcSession, cmd rpc.RpcCommand, next func() error) error {
+	role, ok := commandRoles[cmd.GetKey()]
+	if !ok {
+		return next()
+	}
+
+	err := s.requireRole(session.Partner(), role)
+	if err != nil {
+		return fmt.Errorf("%s requires the %s role: %w", cmd.GetKey(), role, err)
+	}
+
+	return next()
+}
+
+// checkUserTarget fails if the partner tries to administer itself or the root user.
+func checkUserTarget(partner *pki.Certificate, user *system.User) error {
+	if user.Certificate.Type() == pki.CertTypeRoot {
+		return fmt.Errorf("%w: the root user can't be changed", system.ErrPermissionDenied)
+	}
+
+	if user.Certificate.PublicKey().Equal(partner.PublicKey()) {
+		return fmt.Errorf("%w: users can't change themselves", system.ErrPermissionDenied)
+	}
+
+	return nil
+}
+
+func (s *Server) listUsers(partner *pki.Certificate) ([]*system.UserInfo, error) {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*system.UserInfo, 0)
+	err = s.userStore.forEach(func(user *system.User) error {
+		users = append(users, &system.UserInfo{
+			Certificate: user.Certificate,
+			Disabled:    user.Disabled,
+			Roles:       user.Roles,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+
+	return users, nil
+}
+
+// updateUser disables or re-enables a user and sets its roles.
+// Connections of a disabled user are closed right away.
+func (s *Server) updateUser(partner *pki.Certificate, key *pki.PublicKey, disabled bool, roles []string) error {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return err
+	}
+
+	err = s.userStore.updateUser(key, func(user *system.User) error {
+		err := checkUserTarget(partner, user)
+		if err != nil {
+			return err
+		}
+
+		user.Disabled = disabled
+		user.Roles = roles
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		s.closeConnectionsOf(key, "user disabled")
+	}
+
+	return nil
+}
+
+// deleteUser removes a user and revokes its certificate.
+func (s *Server) deleteUser(partner *pki.Certificate, key *pki.PublicKey) error {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userStore.getUser(key)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+
+	err = checkUserTarget(partner, user)
+	if err != nil {
+		return err
+	}
+
+	err = s.revocationStore.RevokeCertificate(user.Certificate)
+	if err != nil {
+		return fmt.Errorf("error revoking user certificate: %w", err)
+	}
+
+	_, err = s.userStore.deleteUser(key)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	err = s.verifier.loadIntermediates()
+	if err != nil {
+		return fmt.Errorf("error reloading user certificates: %w", err)
+	}
+
+	s.closeConnectionsOf(key, "user deleted")
+
+	log.Printf("user %s deleted", user.Certificate.GetName())
+
+	return nil
+}
+
+// checkTotp checks a TOTP code the partner proves itself with before changing its account.
+func (s *Server) checkTotp(partner *pki.Certificate, code string) error {
+	user, err := s.userStore.getUser(partner.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	if user == nil {
+		return system.ErrPermissionDenied
+	}
+
+	return s.useTotp(partner.GetName(), user.TotpSecret, code)
+}
+
+// useTotp validates a TOTP code and remembers its time step like the login does, so a captured code can't be replayed.
+func (s *Server) useTotp(username string, secret string, code string) error {
+	step, ok := util.ValidateTotpStep(secret, code, s.totpSkew)
+	if !ok {
+		return fmt.Errorf("%w: invalid TOTP code", system.ErrPermissionDenied)
+	}
+
+	err := s.loginGuard.UseTotp(username, step)
+	if errors.Is(err, system.ErrTotpReplay) {
+		s.audit.Record(system.AuditEvent{
+			Type:    system.AuditTotpReplay,
+			Subject: username,
+		})
+		return fmt.Errorf("%w: TOTP code was already used", system.ErrPermissionDenied)
+	}
+	if err != nil {
+		return fmt.Errorf("error using TOTP code: %w", err)
+	}
+
+	return nil
+}
+
+// changePassword replaces the password of the partner, if it proves its second factor.
+func (s *Server) changePassword(partner *pki.Certificate, totp string, change *system.PasswordChange) error {
+	if partner == nil || (partner.Type() != pki.CertTypeUser && partner.Type() != pki.CertTypeRoot) {
+		return system.ErrPermissionDenied
+	}
+
+	err := s.checkTotp(partner, totp)
+	if err != nil {
+		return err
+	}
+
+	err = s.userStore.updateUser(partner.PublicKey(), func(user *system.User) error {
+		user.EncryptedPrivateKey = change.EncryptedPrivateKey
+		user.ClientHashingParams = change.ClientHashingParams
+		user.ServerHashingParams = change.ServerHashingParams
+		user.DoubleHashedPassword = change.DoubleHashedPassword
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("password of user %s changed", partner.GetName())
+
+	return nil
+}
+
+// resetTotp lets a user set a new TOTP secret within the reset window.
+func (s *Server) resetTotp(partner *pki.Certificate, key *pki.PublicKey) error {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return err
+	}
+
+	return s.userStore.updateUser(key, func(user *system.User) error {
+		err := checkUserTarget(partner, user)
+		if err != nil {
+			return err
+		}
+
+		user.TotpResetUntil = time.Now().Add(system.TotpResetWindow)
+		return nil
+	})
+}
+
+// confirmTotp replaces the TOTP secret of the partner, the code has to match the new secret.
+func (s *Server) confirmTotp(partner *pki.Certificate, secret string, code string) error {
+	if partner == nil || (partner.Type() != pki.CertTypeUser && partner.Type() != pki.CertTypeRoot) {
+		return system.ErrPermissionDenied
+	}
+
+	user, err := s.userStore.getUser(partner.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	if user == nil || time.Now().After(user.TotpResetUntil) {
+		return fmt.Errorf("%w: no TOTP reset pending", system.ErrPermissionDenied)
+	}
+
+	err = s.useTotp(partner.GetName(), secret, code)
+	if err != nil {
+		return err
+	}
+
+	err = s.userStore.updateUser(partner.PublicKey(), func(user *system.User) error {
+		if time.Now().After(user.TotpResetUntil) {
+			return fmt.Errorf("%w: no TOTP reset pending", system.ErrPermissionDenied)
+		}
+
+		user.TotpSecret = secret
+		user.TotpResetUntil = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("TOTP of user %s replaced", partner.GetName())
+
+	return nil
+}
+
+func (s *Server) queryAuditLog(partner *pki.Certificate, query system.AuditQuery) (*system.AuditLogPage, error) {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Device != nil {
+		trail, _, err := s.deviceAudit.trail(query.Device)
+		if err != nil {
+			return nil, err
+		}
+
+		return trail.Query(query)
+	}
+
+	return s.audit.Query(query)
+}
+
+// getCommandMetrics returns the counters of the sessions handled by the server, e.g. to spot panicking commands.
+func (s *Server) getCommandMetrics(partner *pki.Certificate) (*system.CommandMetrics, error) {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &system.CommandMetrics{
+		Commands: s.commandMetrics.Stats(),
+	}, nil
+}
+
+// requireKnownDevice fails unless the partner is an agent with its current certificate.
+func (s *Server) requireKnownDevice(partner *pki.Certificate) error {
+	if partner == nil || partner.Type() != pki.CertTypeAgent {
+		return system.ErrPermissionDenied
+	}
+
+	known, err := s.deviceStore.GetDevice(partner.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error getting device: %w", err)
+	}
+
+	if known == nil || !known.Equal(partner) {
+		return fmt.Errorf("%w: device not found", system.ErrPermissionDenied)
+	}
+
+	return nil
+}
+
+func (s *Server) storeShellRecording(partner *pki.Certificate, recording *rmm.ShellRecording) error {
+	err := s.requireKnownDevice(partner)
+	if err != nil {
+		return err
+	}
+
+	if recording.Device == nil || !recording.Device.PublicKey().Equal(partner.PublicKey()) {
+		return fmt.Errorf("recording was not made by the uploading device")
+	}
+
+	if recording.ID == "" || len(recording.Data) > rmm.MaxShellRecordingSize {
+		return fmt.Errorf("invalid shell recording")
+	}
+
+	err = recording.Verify()
+	if err != nil {
+		return err
+	}
+
+	return s.shellRecordings.add(partner.PublicKey(), recording)
+}
+
+func (s *Server) getShellRecordings(partner *pki.Certificate, device *pki.PublicKey, id string) ([]*rmm.ShellRecording, error) {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		return s.shellRecordings.list(device)
+	}
+
+	recording, err := s.shellRecordings.get(device, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if recording == nil {
+		return []*rmm.ShellRecording{}, nil
+	}
+
+	return []*rmm.ShellRecording{recording}, nil
+}
+
+func (s *Server) importAuditTrail(partner *pki.Certificate, entries []*system.AuditEntry) (uint64, error) {
+	err := s.requireKnownDevice(partner)
+	if err != nil {
+		return 0, err
+	}
+
+	// The entries are signed by the device, the server can't add any.
+	trail, previous, err := s.deviceAudit.trail(partner.PublicKey())
+	if err != nil {
+		return 0, err
+	}
+
+	err = trail.Import(entries, partner.PublicKey(), previous...)
+	if err != nil {
+		return 0, err
+	}
+
+	return trail.Head()
+}
+
+// replaceRecoveryCodes lets a user replace its recovery codes, the old ones stop working.
+func (s *Server) replaceRecoveryCodes(partner *pki.Certificate, totp string, codes []system.RecoveryCode) error {
+	if partner == nil || (partner.Type() != pki.CertTypeUser && partner.Type() != pki.CertTypeRoot) {
+		return system.ErrPermissionDenied
+	}
+
+	err := s.checkTotp(partner, totp)
+	if err != nil {
+		return err
+	}
+
+	err = s.userStore.updateUser(partner.PublicKey(), func(user *system.User) error {
+		user.RecoveryCodes = codes
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("recovery codes of user %s replaced", partner.GetName())
+
+	return nil
+}
+
+// useRecoveryCode removes a recovery code after it was used to log in.
+func (s *Server) useRecoveryCode(username string, id string) error {
+	user, err := s.userStore.getUserByName(username)
+	if err != nil {
+		return err
+	}
+
+	return s.userStore.updateUser(user.Certificate.PublicKey(), func(user *system.User) error {
+		for i, code := range user.RecoveryCodes {
+			if code.ID == id {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+
+		return system.ErrInvalidRecoveryCode
+	})
+}
+
+// registerInvite keeps the registration of a new user until an admin issues its certificate.
+func (s *Server) registerInvite(raw []byte, pending *system.PendingRegistration) error {
+	invite, issuer, err := system.LoadInvite(raw, s.verifier)
+	if err != nil {
+		return fmt.Errorf("%w: %v", rpc.ErrInvalidArgument, err)
+	}
+
+	err = s.requireAdmin(issuer)
+	if err != nil {
+		return fmt.Errorf("invite was not issued by an admin: %w", err)
+	}
+
+	registration := pending.Registration
+	if invite.Username != registration.Username {
+		return fmt.Errorf("%w: invite was issued for a different username", rpc.ErrInvalidArgument)
+	}
+
+	_, err = s.userStore.getUserByName(registration.Username)
+	if err == nil {
+		return fmt.Errorf("%w: username already in use", rpc.ErrInvalidArgument)
+	}
+
+	registration.Issuer = issuer
+	registration.AutoApprove = invite.AutoApprove
+	pending.Roles = invite.Roles
+
+	err = s.registrations.add(invite.ID, pending)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("user %s registered with invite of %s, waiting for approval", registration.Username, issuer.GetName())
+
+	return nil
+}
+
+// completeRegistration turns a registration into a user once an admin issued the certificate.
+// Without a certificate, the registration is dropped.
+func (s *Server) completeRegistration(partner *pki.Certificate, key *pki.PublicKey, cert *pki.Certificate) error {
+	err := s.requireAdmin(partner)
+	if err != nil {
+		return err
+	}
+
+	pending, err := s.registrations.get(key)
+	if err != nil {
+		return err
+	}
+
+	if pending == nil {
+		return fmt.Errorf("no registration pending for this key")
+	}
+
+	if cert == nil {
+		log.Printf("registration of %s rejected by %s", pending.Registration.Username, partner.GetName())
+		return s.registrations.remove(key)
+	}
+
+	if cert.Type() != pki.CertTypeUser || cert.GetName() != pending.Registration.Username {
+		return fmt.Errorf("certificate does not match the registration")
+	}
+
+	if !cert.IsIssuedBy(partner) {
+		return fmt.Errorf("%w: certificate was not issued by the approving admin", system.ErrPermissionDenied)
+	}
+
+	_, err = s.verifier.verifyChain(cert)
+	if err != nil {
+		return fmt.Errorf("error verifying certificate: %w", err)
+	}
+
+	err = s.userStore.newUser(
+		cert,
+		pending.EncryptedPrivateKey,
+		pending.ClientHashingParams,
+		pending.ServerHashingParams,
+		pending.DoubleHashedPassword,
+		pending.TotpSecret,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating user: %w", err)
+	}
+
+	if len(pending.Roles) > 0 {
+		err = s.userStore.updateUser(key, func(user *system.User) error {
+			user.Roles = pending.Roles
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error setting roles: %w", err)
+		}
+	}
+
+	err = s.registrations.remove(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.verifier.loadIntermediates()
+	if err != nil {
+		return fmt.Errorf("error reloading user certificates: %w", err)
+	}
+
+	log.Printf("registration of %s approved by %s", cert.GetName(), partner.GetName())
+
+	return nil
+}
+
+func (s *Server) closeConnectionsOf(key *pki.PublicKey, reason string) {
+	connections := make([]*rpc.RpcConnection, 0)
+	s.RpcServer.Connections().ForEach(func(_ uuid.UUID, rc *rpc.RpcConnection) error {
+		partner := rc.Partner()
+		if partner != nil && partner.PublicKey().Equal(key) {
+			connections = append(connections, rc)
+		}
+		return nil
+	})
+
+	for _, rc := range connections {
+		err := rc.Close(403, reason)
+		if err != nil {
+			log.Printf("error closing connection: %v", err)
+		}
+	}
+}
+
+// renameDevice pushes a re-issued certificate to the connected agent,
+// replaces it in the device store and revokes the previous one.
+// Only admins and the user who issued the current certificate may rename a device.
+func (s *Server) renameDevice(partner *pki.Certificate, cert *pki.Certificate) error {
+	if partner == nil || !cert.IsIssuedBy(partner) {
+		return fmt.Errorf("%w: certificate was not issued by the renaming user", system.ErrPermissionDenied)
+	}
+
+	chain, err := s.verifier.verifyChain(cert)
+	if err != nil {
+		return fmt.Errorf("error verifying new certificate: %w", err)
+	}
+
+	old, err := s.deviceStore.GetDevice(cert.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error getting device: %w", err)
+	}
+
+	if old == nil {
+		return fmt.Errorf("%w: device not found", rpc.ErrNotFound)
+	}
+
+	issuer, err := s.deviceIssuer(old)
+	if err != nil {
+		return err
+	}
+
+	if !issuer.PublicKey().Equal(partner.PublicKey()) {
+		err = s.requireAdmin(partner)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd := system.NewUpdateHostCertificateCommand(cert, chain[1:])
+	err = s.RpcServer.SendSyncCommandTo(context.Background(), old, cmd)
+	if err != nil {
+		return fmt.Errorf("error pushing certificate to device: %w", err)
+	}
+
+	_, err = s.deviceStore.ReplaceDevice(cert)
+	if err != nil {
+		return fmt.Errorf("error replacing device certificate: %w", err)
+	}
+
+	err = s.revocationStore.RevokeCertificate(old)
+	if err != nil {
+		return fmt.Errorf("error revoking old certificate: %w", err)
+	}
+
+	log.Printf("device %s renamed to %s", old.GetName(), cert.GetName())
+
+	return nil
+}
+
+// getRevocations hands out the revoked certificates to any authenticated host.
+// Only hashes are stored, so nothing about the certificates is disclosed.
+func (s *Server) getRevocations(partner *pki.Certificate, since time.Time) ([]system.Revocation, error) {
+	if partner == nil {
+		return nil, system.ErrPermissionDenied
+	}
+
+	return s.revocationStore.Revocations(since)
+}
+
+// requestRenewal queues the renewal of a device.
+// It stays pending, also across restarts, until a client permitted by the renewal policy re-signs it.
+func (s *Server) requestRenewal(renewal *system.Renewal) error {
+	known, err := s.deviceStore.GetDevice(renewal.Device.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error getting device: %w", err)
+	}
+
+	if known == nil || !known.Equal(renewal.Device) {
+		return fmt.Errorf("device not found")
+	}
+
+	issuer, err := s.deviceIssuer(known)
+	if err != nil {
+		return err
+	}
+
+	renewal.Issuer = issuer
+	renewal.AnyAdmin = s.renewalPolicy == system.RenewalPolicyAdmins
+
+	err = s.renewals.set(renewal.Device.PublicKey().Base64Encode(), renewal)
+	if err != nil {
+		return fmt.Errorf("error saving renewal: %w", err)
+	}
+
+	log.Printf("renewal requested for device %s", renewal.Device.GetName())
+
+	return nil
+}
+
+// renewDevice completes a pending renewal with a certificate signed by a user.
+// If the agent rotated its key, the device is moved over to the new key.
+func (s *Server) renewDevice(partner *pki.Certificate, device *pki.PublicKey, cert *pki.Certificate) error {
+	key := device.Base64Encode()
+
+	renewal, ok := s.renewals.get(key)
+	if !ok {
+		return fmt.Errorf("%w: no renewal pending for device", rpc.ErrNotFound)
+	}
+
+	if partner == nil || !cert.IsIssuedBy(partner) {
+		return fmt.Errorf("%w: certificate was not issued by the renewing user", system.ErrPermissionDenied)
+	}
+
+	issuer, err := s.deviceIssuer(renewal.Device)
+	if err != nil {
+		return err
+	}
+
+	if !issuer.PublicKey().Equal(partner.PublicKey()) {
+		if !renewal.AnyAdmin {
+			return fmt.Errorf("%w: only the issuing user may renew the device", system.ErrPermissionDenied)
+		}
+
+		err := s.requireAdmin(partner)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !cert.PublicKey().Equal(renewal.PublicKey) {
+		return fmt.Errorf("certificate was issued for the wrong public key")
+	}
+
+	if cert.GetName() != renewal.Device.GetName() {
+		return fmt.Errorf("certificate name does not match device name")
+	}
+
+	chain, err := s.verifier.verifyChain(cert)
+	if err != nil {
+		return fmt.Errorf("error verifying new certificate: %w", err)
+	}
+
+	cmd := system.NewUpdateHostCertificateCommand(cert, chain[1:])
+	err = s.RpcServer.SendSyncCommandTo(context.Background(), renewal.Device, cmd)
+	if err != nil {
+		return fmt.Errorf("error pushing certificate to device: %w", err)
+	}
+
+	if cert.PublicKey().Equal(device) {
+		_, err = s.deviceStore.ReplaceDevice(cert)
+	} else {
+		err = s.rekeyDevice(renewal.Device, cert)
+	}
+	if err != nil {
+		return fmt.Errorf("error replacing device certificate: %w", err)
+	}
+
+	err = s.renewals.remove(key)
+	if err != nil {
+		return fmt.Errorf("error removing renewal: %w", err)
+	}
+
+	err = s.revocationStore.RevokeCertificate(renewal.Device)
+	if err != nil {
+		return fmt.Errorf("error revoking old certificate: %w", err)
+	}
+
+	log.Printf("certificate for device %s renewed until %s", cert.GetName(), cert.NotAfter())
+
+	return nil
+}
+
+// rekeyDevice moves a device and the data kept for it over to a certificate for a new key.
+func (s *Server) rekeyDevice(old *pki.Certificate, cert *pki.Certificate) error {
+	err := s.deviceStore.RekeyDevice(old, cert)
+	if err != nil {
+		return err
+	}
+
+	err = s.deviceAttributes.move(old.PublicKey().Base64Encode(), cert.PublicKey().Base64Encode())
+	if err != nil {
+		return fmt.Errorf("error moving device attributes: %w", err)
+	}
+
+	err = s.deviceAudit.rekey(old.PublicKey(), cert.PublicKey())
+	if err != nil {
+		return fmt.Errorf("error moving device audit trail: %w", err)
+	}
+
+	return nil
+}
+
+// beginRootRollover stores the re-issued user certificates, moves the root user to the next root
+// and starts trusting it. The old root stays trusted until the rollover is due.
+func (s *Server) beginRootRollover(request *system.RootRolloverRequest) error {
+	anchors := s.serverConfig.Anchors()
+	current := anchors.Root()
+
+	err := request.Rollover.Verify(current, s.serverConfig.Credentials().PublicKey())
+	if err != nil {
+		return fmt.Errorf("%w: invalid root rollover: %w", rpc.ErrInvalidArgument, err)
+	}
+
+	for _, cert := range request.Users {
+		if !cert.IsIssuedBy(request.Rollover.Next) {
+			return fmt.Errorf("%w: certificate of %s was not issued by the next root", rpc.ErrInvalidArgument, cert.GetName())
+		}
+	}
+
+	err = s.userStore.setNextCertificates(request.Users)
+	if err != nil {
+		return fmt.Errorf("error saving re-issued user certificates: %w", err)
+	}
+
+	err = s.userStore.rekeyUser(current.PublicKey(), request.Rollover.Next, request.EncryptedRootKey)
+	if err != nil {
+		return fmt.Errorf("error moving root user to next root: %w", err)
+	}
+
+	err = anchors.BeginRollover(request.Rollover)
+	if err != nil {
+		return fmt.Errorf("error beginning root rollover: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) getRootRollover(partner *pki.Certificate) (*system.RootRollover, *pki.Certificate, error) {
+	rollover := s.serverConfig.Anchors().Rollover()
+	if rollover == nil || partner == nil || partner.Type() != pki.CertTypeUser {
+		return rollover, nil, nil
+	}
+
+	user, err := s.userStore.getUser(partner.PublicKey())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	if user == nil {
+		return rollover, nil, nil
+	}
+
+	return rollover, user.NextCertificate, nil
+}
+
+// retireRoot switches the server and the users over to their certificates issued by the next root.
+// Hosts connecting afterwards get the new certificates right away.
+func (s *Server) retireRoot(rollover *system.RootRollover) {
+	err := s.serverConfig.updateCertificate(rollover.Upstream)
+	if err != nil {
+		log.Printf("error updating server certificate: %v", err)
+	} else {
+		credentials := s.serverConfig.Credentials()
+		s.RpcServer.UpdateCredentials(credentials, rollover.Next)
+		s.loginHandler.UpdateRoot(rollover.Next, credentials.Certificate())
+	}
+
+	err = s.userStore.applyNextCertificates()
+	if err != nil {
+		log.Printf("error updating user certificates: %v", err)
+	}
+
+	err = s.verifier.loadIntermediates()
+	if err != nil {
+		log.Printf("error reloading user certificates: %v", err)
+	}
+
+	log.Printf("old root retired")
+}
+
 func (s *Server) Run() error {
 	return s.RpcServer.Run()
 }
 
+func (s *Server) Close(code quic.ApplicationErrorCode, msg string) error {
+	if s.nonces != nil {
+		err := s.nonces.save()
+		if err != nil {
+			log.Printf("error saving recent nonces: %v", err)
+		}
+	}
+
+	return s.RpcServer.Close(code, msg)
+}
+
 func Init(profile *config.Profile) error {
 	scope := profile.Scope().Scope("server")
 
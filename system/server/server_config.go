@@ -15,7 +15,7 @@ type serverConfig struct {
 	scope       db.Scope
 	seed        []byte
 	credentials *pki.PermanentCredentials
-	root        *pki.Certificate
+	anchors     *system.TrustAnchors
 }
 
 func openServerConfig(scope db.Scope) (*serverConfig, error) {
@@ -28,10 +28,11 @@ func openServerConfig(scope db.Scope) (*serverConfig, error) {
 		return nil, fmt.Errorf("failed to initialize seed: %w", err)
 	}
 
-	err = sc.loadRoot()
+	anchors, err := system.OpenTrustAnchors(scope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load root: %w", err)
 	}
+	sc.anchors = anchors
 
 	err = sc.loadCredentials()
 	if err != nil {
@@ -82,25 +83,31 @@ func (sc *serverConfig) Credentials() *pki.PermanentCredentials {
 	return sc.credentials
 }
 
-func (sc *serverConfig) loadRoot() error {
-	return sc.scope.View(func(b db.Bucket) error {
-		raw := b.Get([]byte("root"))
+func (sc *serverConfig) Root() *pki.Certificate {
+	return sc.anchors.Root()
+}
 
-		if raw == nil {
-			return errors.New("root certificate not found")
-		}
-		root, err := pki.CertificateFromPem(raw)
-		if err != nil {
-			return fmt.Errorf("failed to load root certificate: %w", err)
-		}
+func (sc *serverConfig) Anchors() *system.TrustAnchors {
+	return sc.anchors
+}
 
-		sc.root = root
-		return nil
+// updateCertificate swaps the server certificate, keeping the private key.
+func (sc *serverConfig) updateCertificate(cert *pki.Certificate) error {
+	if !cert.PublicKey().Equal(sc.credentials.PublicKey()) {
+		return errors.New("certificate does not match the host key")
+	}
+
+	credentials := pki.CredentialsFromCertAndKey(cert, sc.credentials.PrivateKey())
+
+	err := sc.scope.Update(func(b db.Bucket) error {
+		return system.SaveHostCredentials(b, credentials)
 	})
-}
+	if err != nil {
+		return fmt.Errorf("failed to save host credentials: %w", err)
+	}
 
-func (sc *serverConfig) Root() *pki.Certificate {
-	return sc.root
+	sc.credentials = credentials
+	return nil
 }
 
 func checkForServerConfig(scope db.Scope) (bool, error) {
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rmm"
+)
+
+// shellRecordingStore keeps the shell recordings uploaded by agents, keyed by device and recording ID.
+type shellRecordingStore struct {
+	scope db.Scope
+}
+
+func openShellRecordingStore(scope db.Scope) (*shellRecordingStore, error) {
+	return &shellRecordingStore{
+		scope: scope,
+	}, nil
+}
+
+func shellRecordingPrefix(device *pki.PublicKey) string {
+	return device.Base64Encode() + "_"
+}
+
+// add stores the recording, uploading the same recording again has no effect.
+func (s *shellRecordingStore) add(device *pki.PublicKey, recording *rmm.ShellRecording) error {
+	raw, err := json.Marshal(recording)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shell recording: %w", err)
+	}
+
+	err = s.scope.Update(func(b db.Bucket) error {
+		return b.Put([]byte(shellRecordingPrefix(device)+recording.ID), raw)
+	})
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *shellRecordingStore) get(device *pki.PublicKey, id string) (*rmm.ShellRecording, error) {
+	var recording *rmm.ShellRecording
+
+	err := s.scope.View(func(b db.Bucket) error {
+		raw := b.Get([]byte(shellRecordingPrefix(device) + id))
+		if raw == nil {
+			return nil
+		}
+
+		recording = &rmm.ShellRecording{}
+		return json.Unmarshal(raw, recording)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return recording, nil
+}
+
+// list returns the recordings of a device without their data.
+func (s *shellRecordingStore) list(device *pki.PublicKey) ([]*rmm.ShellRecording, error) {
+	recordings := make([]*rmm.ShellRecording, 0)
+
+	err := s.scope.View(func(b db.Bucket) error {
+		return b.ForPrefix([]byte(shellRecordingPrefix(device)), func(k, v []byte) error {
+			recording := &rmm.ShellRecording{}
+			err := json.Unmarshal(v, recording)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal shell recording: %w", err)
+			}
+
+			recording.Data = nil
+			recordings = append(recordings, recording)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return recordings, nil
+}
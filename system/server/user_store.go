@@ -16,14 +16,68 @@ type userStore struct {
 }
 
 func openUserStore(scope db.Scope) (*userStore, error) {
-	return &userStore{
+	us := &userStore{
 		scope: scope,
-	}, nil
+	}
+
+	err := us.migrateRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	return us, nil
+}
+
+// migrateRoles gives the users created before roles existed the operator role, they acted as operators until then.
+func (u *userStore) migrateRoles() error {
+	err := u.scope.Update(func(b db.Bucket) error {
+		updated := make(map[string][]byte)
+
+		err := b.ForPrefix([]byte(userPrefix), func(k, v []byte) error {
+			user, err := unmarshalUser(v)
+			if err != nil {
+				return fmt.Errorf("failed to load user %s: %w", string(k), err)
+			}
+
+			if len(user.Roles) > 0 {
+				return nil
+			}
+
+			user.Roles = []string{system.RoleOperator}
+
+			raw, err := json.Marshal(user)
+			if err != nil {
+				return fmt.Errorf("failed to marshal user: %w", err)
+			}
+
+			updated[string(k)] = raw
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, raw := range updated {
+			err := b.Put([]byte(k), raw)
+			if err != nil {
+				return fmt.Errorf("failed to set user: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
 }
 
 const userPrefix = "user_"
 const usernamePrefix = "username_"
 
+// newUser stores a new user, who is a viewer until an admin grants more roles.
 func (us *userStore) newUser(
 	Certificate *pki.Certificate,
 	EncryptedPrivateKey []byte,
@@ -31,6 +85,7 @@ func (us *userStore) newUser(
 	ServerHashingParams *util.ArgonParameters,
 	DoubleHashedPassword []byte,
 	TotpSecret string,
+	RecoveryCodes []system.RecoveryCode,
 ) error {
 	username := Certificate.GetName()
 	publicKey := Certificate.PublicKey().Base64Encode()
@@ -42,6 +97,8 @@ func (us *userStore) newUser(
 		ServerHashingParams:  ServerHashingParams,
 		DoubleHashedPassword: DoubleHashedPassword,
 		TotpSecret:           TotpSecret,
+		RecoveryCodes:        RecoveryCodes,
+		Roles:                []string{system.RoleViewer},
 	}
 
 	raw, err := json.Marshal(user)
@@ -97,7 +154,7 @@ func (u *userStore) getUser(publicKey *pki.PublicKey) (*system.User, error) {
 			return nil
 		}
 
-		raw := make([]byte, len(userData))
+		raw = make([]byte, len(userData))
 		copy(raw, userData)
 		return nil
 	})
@@ -169,3 +226,230 @@ func (u *userStore) forEach(fn func(*system.User) error) error {
 		return nil
 	})
 }
+
+// certificates returns the certificates of all users.
+func (u *userStore) certificates() ([]*pki.Certificate, error) {
+	certs := make([]*pki.Certificate, 0)
+	err := u.forEach(func(user *system.User) error {
+		certs = append(certs, user.Certificate)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+// setNextCertificates stores certificates re-issued during a root rollover with their users.
+// They replace the current certificates once the old root is retired.
+func (u *userStore) setNextCertificates(certs []*pki.Certificate) error {
+	err := u.scope.Update(func(b db.Bucket) error {
+		for _, cert := range certs {
+			key := []byte(userPrefix + cert.PublicKey().Base64Encode())
+
+			user, err := unmarshalUser(b.Get(key))
+			if err != nil {
+				return fmt.Errorf("failed to load user %s: %w", cert.GetName(), err)
+			}
+
+			if user.Certificate.GetName() != cert.GetName() {
+				return fmt.Errorf("re-issued certificate for %s has a different name", user.Certificate.GetName())
+			}
+
+			user.NextCertificate = cert
+
+			raw, err := json.Marshal(user)
+			if err != nil {
+				return fmt.Errorf("failed to marshal user: %w", err)
+			}
+
+			err = b.Put(key, raw)
+			if err != nil {
+				return fmt.Errorf("failed to set user: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// applyNextCertificates replaces the certificates of all users that have a re-issued one.
+func (u *userStore) applyNextCertificates() error {
+	err := u.scope.Update(func(b db.Bucket) error {
+		updated := make(map[string][]byte)
+
+		err := b.ForPrefix([]byte(userPrefix), func(k, v []byte) error {
+			user, err := unmarshalUser(v)
+			if err != nil {
+				return fmt.Errorf("failed to load user %s: %w", string(k), err)
+			}
+
+			if user.NextCertificate == nil {
+				return nil
+			}
+
+			user.Certificate = user.NextCertificate
+			user.NextCertificate = nil
+
+			raw, err := json.Marshal(user)
+			if err != nil {
+				return fmt.Errorf("failed to marshal user: %w", err)
+			}
+
+			updated[string(k)] = raw
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, raw := range updated {
+			err := b.Put([]byte(k), raw)
+			if err != nil {
+				return fmt.Errorf("failed to set user: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// rekeyUser moves a user to a new key and certificate, keeping password and TOTP.
+func (u *userStore) rekeyUser(old *pki.PublicKey, cert *pki.Certificate, encryptedPrivateKey []byte) error {
+	oldKey := []byte(userPrefix + old.Base64Encode())
+	publicKey := cert.PublicKey().Base64Encode()
+
+	err := u.scope.Update(func(b db.Bucket) error {
+		user, err := unmarshalUser(b.Get(oldKey))
+		if err != nil {
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+
+		if user.Certificate.GetName() != cert.GetName() {
+			return fmt.Errorf("new certificate for %s has a different name", user.Certificate.GetName())
+		}
+
+		if b.Get([]byte(userPrefix+publicKey)) != nil {
+			return errors.New("public key already in use")
+		}
+
+		user.Certificate = cert
+		user.EncryptedPrivateKey = encryptedPrivateKey
+		user.NextCertificate = nil
+
+		raw, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("failed to marshal user: %w", err)
+		}
+
+		err = b.Delete(oldKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete old user: %w", err)
+		}
+
+		err = b.Put([]byte(usernamePrefix+cert.GetName()), []byte(publicKey))
+		if err != nil {
+			return fmt.Errorf("failed to set username index: %w", err)
+		}
+
+		err = b.Put([]byte(userPrefix+publicKey), raw)
+		if err != nil {
+			return fmt.Errorf("failed to set user: %w", err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// updateUser loads the user with the given key, lets fn change it and saves it again.
+func (u *userStore) updateUser(publicKey *pki.PublicKey, fn func(user *system.User) error) error {
+	key := []byte(userPrefix + publicKey.Base64Encode())
+
+	err := u.scope.Update(func(b db.Bucket) error {
+		user, err := unmarshalUser(b.Get(key))
+		if err != nil {
+			return err
+		}
+
+		err = fn(user)
+		if err != nil {
+			return err
+		}
+
+		raw, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("failed to marshal user: %w", err)
+		}
+
+		return b.Put(key, raw)
+	})
+
+	if err != nil {
+		return fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return nil
+}
+
+// deleteUser removes the user with the given key and its username index and returns it.
+func (u *userStore) deleteUser(publicKey *pki.PublicKey) (*system.User, error) {
+	key := []byte(userPrefix + publicKey.Base64Encode())
+
+	var user *system.User
+	err := u.scope.Update(func(b db.Bucket) error {
+		var err error
+		user, err = unmarshalUser(b.Get(key))
+		if err != nil {
+			return err
+		}
+
+		nameKey := []byte(usernamePrefix + user.Certificate.GetName())
+		if string(b.Get(nameKey)) == publicKey.Base64Encode() {
+			err = b.Delete(nameKey)
+			if err != nil {
+				return fmt.Errorf("failed to delete username index: %w", err)
+			}
+		}
+
+		return b.Delete(key)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error during transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+func unmarshalUser(raw []byte) (*system.User, error) {
+	if raw == nil {
+		return nil, errors.New("user not found")
+	}
+
+	user := &system.User{}
+	err := json.Unmarshal(raw, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return user, nil
+}
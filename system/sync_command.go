@@ -67,7 +67,8 @@ func (s *SyncDownCommand[K, T]) ExecuteServer(session *rpc.RpcSession) error {
 		return fmt.Errorf("error writing message: %w", err)
 	}
 
-	var updateErrChan = make(chan error)
+	// buffered, the handler may have stopped waiting when an update fails
+	var updateErrChan = make(chan error, 1)
 
 	unsubscribe := s.sourceMap.Subscribe(
 		func(key K, value T) {
@@ -77,7 +78,10 @@ func (s *SyncDownCommand[K, T]) ExecuteServer(session *rpc.RpcSession) error {
 				Value:  value,
 			})
 			if err != nil {
-				updateErrChan <- fmt.Errorf("error writing update message: %w", err)
+				select {
+				case updateErrChan <- fmt.Errorf("error writing update message: %w", err):
+				default:
+				}
 			}
 		},
 		func(key K, _ T) {
@@ -86,15 +90,21 @@ func (s *SyncDownCommand[K, T]) ExecuteServer(session *rpc.RpcSession) error {
 				Key:    key,
 			})
 			if err != nil {
-				updateErrChan <- fmt.Errorf("error writing update message: %w", err)
+				select {
+				case updateErrChan <- fmt.Errorf("error writing update message: %w", err):
+				default:
+				}
 			}
 		},
 	)
 	defer unsubscribe()
 
-	err = <-updateErrChan
-
-	return err
+	select {
+	case err = <-updateErrChan:
+		return err
+	case <-session.Context().Done():
+		return nil
+	}
 }
 
 func (s *SyncDownCommand[K, T]) SetSourceMap(m util.ObservableMap[K, T]) {
@@ -0,0 +1,256 @@
+package system
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/rahn-it/svalin/db"
+	"github.com/rahn-it/svalin/pki"
+)
+
+var rootKey = []byte("root")
+var upstreamKey = []byte("upstream")
+var rootRolloverKey = []byte("root-rollover")
+
+// TrustAnchors holds the root an endpoint trusts and, if present, its upstream.
+// While a root rollover is in progress the next root is trusted as well,
+// once it is due the old root is retired and replaced in storage.
+type TrustAnchors struct {
+	scope    db.Scope
+	root     *pki.Certificate
+	upstream *pki.Certificate
+	rollover *RootRollover
+	onRetire []func(rollover *RootRollover)
+	mutex    sync.Mutex
+}
+
+func OpenTrustAnchors(scope db.Scope) (*TrustAnchors, error) {
+	t := &TrustAnchors{
+		scope: scope,
+	}
+
+	err := scope.View(func(b db.Bucket) error {
+		raw := b.Get(rootKey)
+		if raw == nil {
+			return errors.New("root certificate not found")
+		}
+
+		root, err := pki.CertificateFromPem(raw)
+		if err != nil {
+			return fmt.Errorf("failed to load root certificate: %w", err)
+		}
+		t.root = root
+
+		raw = b.Get(upstreamKey)
+		if raw != nil {
+			upstream, err := pki.CertificateFromPem(raw)
+			if err != nil {
+				return fmt.Errorf("failed to load upstream certificate: %w", err)
+			}
+			t.upstream = upstream
+		}
+
+		raw = b.Get(rootRolloverKey)
+		if raw != nil {
+			rollover := &RootRollover{}
+			err := json.Unmarshal(raw, rollover)
+			if err != nil {
+				return fmt.Errorf("failed to load root rollover: %w", err)
+			}
+			t.rollover = rollover
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// OnRetire registers a function that is called once the old root was retired.
+func (t *TrustAnchors) OnRetire(fn func(rollover *RootRollover)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.onRetire = append(t.onRetire, fn)
+}
+
+// Root returns the current root.
+func (t *TrustAnchors) Root() *pki.Certificate {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.root
+}
+
+// Upstream returns the current upstream, or nil if the endpoint has none.
+func (t *TrustAnchors) Upstream() *pki.Certificate {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.upstream
+}
+
+// Rollover returns the rollover in progress, or nil if there is none.
+func (t *TrustAnchors) Rollover() *RootRollover {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.rollover
+}
+
+// RootFor returns the trusted root with the given key, or nil if there is none.
+func (t *TrustAnchors) RootFor(pub *pki.PublicKey) *pki.Certificate {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.root.PublicKey().Equal(pub) {
+		return t.root
+	}
+
+	if t.rollover != nil && t.rollover.Next.PublicKey().Equal(pub) {
+		return t.rollover.Next
+	}
+
+	return nil
+}
+
+// Roots returns a pool of all currently trusted roots.
+func (t *TrustAnchors) Roots() *x509.CertPool {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(t.root.ToX509())
+
+	if t.rollover != nil {
+		roots.AddCert(t.rollover.Next.ToX509())
+	}
+
+	return roots
+}
+
+// Intermediates returns a new pool containing the cross signed next root, if any.
+// Callers may add further intermediates to it.
+func (t *TrustAnchors) Intermediates() *x509.CertPool {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	intermediates := x509.NewCertPool()
+
+	if t.rollover != nil {
+		intermediates.AddCert(t.rollover.CrossSigned.ToX509())
+	}
+
+	return intermediates
+}
+
+// BeginRollover verifies and stores the given rollover.
+// Receiving the rollover that is already in progress is not an error.
+func (t *TrustAnchors) BeginRollover(rollover *RootRollover) error {
+	t.retireIfDue()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.rollover != nil {
+		if t.rollover.Next.Equal(rollover.Next) {
+			return nil
+		}
+		return errors.New("another root rollover is already in progress")
+	}
+
+	var upstream *pki.PublicKey
+	if t.upstream != nil {
+		upstream = t.upstream.PublicKey()
+	}
+
+	err := rollover.Verify(t.root, upstream)
+	if err != nil {
+		return fmt.Errorf("invalid root rollover: %w", err)
+	}
+
+	raw, err := json.Marshal(rollover)
+	if err != nil {
+		return fmt.Errorf("failed to marshal root rollover: %w", err)
+	}
+
+	err = t.scope.Update(func(b db.Bucket) error {
+		return b.Put(rootRolloverKey, raw)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save root rollover: %w", err)
+	}
+
+	t.rollover = rollover
+
+	log.Printf("root rollover to %s started, old root will be retired at %s", rollover.Next.GetName(), rollover.RetireAt)
+
+	return nil
+}
+
+func (t *TrustAnchors) retireIfDue() {
+	t.mutex.Lock()
+
+	rollover := t.rollover
+	if rollover == nil || !rollover.Retired() {
+		t.mutex.Unlock()
+		return
+	}
+
+	err := t.scope.Update(func(b db.Bucket) error {
+		err := b.Put(rootKey, rollover.Next.PemEncode())
+		if err != nil {
+			return fmt.Errorf("failed to save root certificate: %w", err)
+		}
+
+		if t.upstream != nil {
+			err = b.Put(upstreamKey, rollover.Upstream.PemEncode())
+			if err != nil {
+				return fmt.Errorf("failed to save upstream certificate: %w", err)
+			}
+		}
+
+		return b.Delete(rootRolloverKey)
+	})
+	if err != nil {
+		// keep trusting both roots and try again on the next access
+		t.mutex.Unlock()
+		log.Printf("error retiring old root: %v", err)
+		return
+	}
+
+	t.root = rollover.Next
+	if t.upstream != nil {
+		t.upstream = rollover.Upstream
+	}
+	t.rollover = nil
+
+	onRetire := t.onRetire
+
+	t.mutex.Unlock()
+
+	log.Printf("old root retired, now trusting %s", rollover.Next.GetName())
+
+	for _, fn := range onRetire {
+		fn(rollover)
+	}
+}
@@ -0,0 +1,95 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*updateHostCertificateCommand)(nil)
+
+// CreateUpdateHostCertificateCommandHandler accepts a re-issued certificate for the own key.
+// Only the upstream may push new certificates, and they need to chain up to the root.
+func CreateUpdateHostCertificateCommandHandler(anchors *TrustAnchors, onUpdate func(cert *pki.Certificate) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &updateHostCertificateCommand{
+			anchors:  anchors,
+			onUpdate: onUpdate,
+		}
+	}
+}
+
+type updateHostCertificateCommand struct {
+	Cert     *pki.Certificate
+	Chain    []*pki.Certificate
+	anchors  *TrustAnchors
+	onUpdate func(cert *pki.Certificate) error
+}
+
+func NewUpdateHostCertificateCommand(cert *pki.Certificate, chain []*pki.Certificate) *updateHostCertificateCommand {
+	return &updateHostCertificateCommand{
+		Cert:  cert,
+		Chain: chain,
+	}
+}
+
+func (c *updateHostCertificateCommand) GetKey() string {
+	return "update-host-certificate"
+}
+
+func (c *updateHostCertificateCommand) ExecuteServer(session *rpc.RpcSession) error {
+	partner := session.Partner()
+	if partner == nil || !partner.Equal(c.anchors.Upstream()) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Only the upstream may update the host certificate",
+		})
+		return fmt.Errorf("host certificate update not sent by upstream")
+	}
+
+	if c.Cert == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "No certificate provided",
+		})
+		return fmt.Errorf("no certificate provided")
+	}
+
+	intermediates := c.anchors.Intermediates()
+	for _, cert := range c.Chain {
+		intermediates.AddCert(cert.ToX509())
+	}
+
+	_, err := c.Cert.VerifyChain(c.anchors.Roots(), intermediates)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid certificate",
+		})
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	err = c.onUpdate(c.Cert)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Error saving certificate",
+		})
+		return fmt.Errorf("error saving certificate: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	return nil
+}
+
+func (c *updateHostCertificateCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
@@ -0,0 +1,99 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*updateUserCommand)(nil)
+var _ rpc.AuditableCommand = (*updateUserCommand)(nil)
+
+func CreateUpdateUserCommandHandler(updateUser func(partner *pki.Certificate, key *pki.PublicKey, disabled bool, roles []string) error) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &updateUserCommand{
+			updateUser: updateUser,
+		}
+	}
+}
+
+// updateUserCommand disables or re-enables a user and sets its roles.
+type updateUserCommand struct {
+	PublicKey  *pki.PublicKey
+	Disabled   bool
+	Roles      []string
+	updateUser func(partner *pki.Certificate, key *pki.PublicKey, disabled bool, roles []string) error
+}
+
+func NewUpdateUserCommand(key *pki.PublicKey, disabled bool, roles []string) *updateUserCommand {
+	return &updateUserCommand{
+		PublicKey: key,
+		Disabled:  disabled,
+		Roles:     roles,
+	}
+}
+
+func (c *updateUserCommand) GetKey() string {
+	return "update-user"
+}
+
+func (c *updateUserCommand) ExecuteServer(session *rpc.RpcSession) error {
+	if c.PublicKey == nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Missing public key",
+		})
+		return fmt.Errorf("missing public key")
+	}
+
+	err := CheckRoles(c.Roles)
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid roles",
+		})
+		return err
+	}
+
+	err = c.updateUser(session.Partner(), c.PublicKey, c.Disabled, c.Roles)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error updating user: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 500,
+			Msg:  "Internal Server Error",
+		})
+		return fmt.Errorf("error updating user: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+
+	return err
+}
+
+func (c *updateUserCommand) ExecuteClient(session *rpc.RpcSession) error {
+	return nil
+}
+
+func (c *updateUserCommand) AuditTarget() string {
+	if c.PublicKey == nil {
+		return ""
+	}
+
+	return c.PublicKey.Base64Encode()
+}
+
+func (c *updateUserCommand) AuditSummary() string {
+	return fmt.Sprintf("disabled=%t roles=%s", c.Disabled, strings.Join(c.Roles, ","))
+}
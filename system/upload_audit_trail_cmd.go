@@ -0,0 +1,86 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+var _ rpc.RpcCommand = (*uploadAuditTrailCommand)(nil)
+
+// CreateUploadAuditTrailCommandHandler accepts the audit trail of agents.
+// importTrail returns the sequence number of the last entry the upstream has of the partner.
+func CreateUploadAuditTrailCommandHandler(importTrail func(partner *pki.Certificate, entries []*AuditEntry) (uint64, error)) rpc.RpcCommandHandler {
+	return func() rpc.RpcCommand {
+		return &uploadAuditTrailCommand{
+			importTrail: importTrail,
+		}
+	}
+}
+
+// uploadAuditTrailCommand sends entries of the local audit trail of an agent to its upstream.
+// The entries are signed by the agent, so the upstream can store but not change them.
+type uploadAuditTrailCommand struct {
+	Entries     []*AuditEntry
+	importTrail func(partner *pki.Certificate, entries []*AuditEntry) (uint64, error)
+	head        uint64
+}
+
+func NewUploadAuditTrailCommand(entries []*AuditEntry) *uploadAuditTrailCommand {
+	return &uploadAuditTrailCommand{
+		Entries: entries,
+	}
+}
+
+func (c *uploadAuditTrailCommand) GetKey() string {
+	return "upload-audit-trail"
+}
+
+func (c *uploadAuditTrailCommand) ExecuteServer(session *rpc.RpcSession) error {
+	head, err := c.importTrail(session.Partner(), c.Entries)
+	if errors.Is(err, ErrPermissionDenied) {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 403,
+			Msg:  "Forbidden",
+		})
+		return fmt.Errorf("error importing audit trail: %w", err)
+	}
+	if err != nil {
+		session.WriteResponseHeader(rpc.SessionResponseHeader{
+			Code: 400,
+			Msg:  "Invalid audit trail",
+		})
+		return fmt.Errorf("error importing audit trail: %w", err)
+	}
+
+	err = session.WriteResponseHeader(rpc.SessionResponseHeader{
+		Code: 200,
+		Msg:  "OK",
+	})
+	if err != nil {
+		return fmt.Errorf("error writing response header: %w", err)
+	}
+
+	err = rpc.WriteMessage[uint64](session, head)
+	if err != nil {
+		return fmt.Errorf("error writing audit trail head: %w", err)
+	}
+
+	return nil
+}
+
+func (c *uploadAuditTrailCommand) ExecuteClient(session *rpc.RpcSession) error {
+	err := rpc.ReadMessage[*uint64](session, &c.head)
+	if err != nil {
+		return fmt.Errorf("error reading audit trail head: %w", err)
+	}
+
+	return nil
+}
+
+// Head returns the sequence number of the last entry the upstream has stored.
+func (c *uploadAuditTrailCommand) Head() uint64 {
+	return c.head
+}
@@ -2,7 +2,6 @@ package system
 
 import (
 	"context"
-	"crypto/x509"
 	"fmt"
 
 	"github.com/rahn-it/svalin/pki"
@@ -21,21 +20,13 @@ var _ pki.Verifier = (*upstreamVerifier)(nil)
 
 type upstreamVerifier struct {
 	ep              *rpc.RpcEndpoint
-	upstream        *pki.Certificate
-	root            *pki.Certificate
-	rootPool        *x509.CertPool
+	anchors         *TrustAnchors
 	revocationStore *RevocationStore
 }
 
-func NewUpstreamVerifier(upstream *pki.Certificate, root *pki.Certificate, revocationStore *RevocationStore) *upstreamVerifier {
-
-	rootPool := x509.NewCertPool()
-	rootPool.AddCert(root.ToX509())
-
+func NewUpstreamVerifier(anchors *TrustAnchors, revocationStore *RevocationStore) *upstreamVerifier {
 	return &upstreamVerifier{
-		upstream:        upstream,
-		root:            root,
-		rootPool:        rootPool,
+		anchors:         anchors,
 		revocationStore: revocationStore,
 	}
 }
@@ -58,12 +49,14 @@ func (v *upstreamVerifier) Verify(cert *pki.Certificate) ([]*pki.Certificate, er
 }
 
 func (v *upstreamVerifier) VerifyPublicKey(pub *pki.PublicKey) ([]*pki.Certificate, error) {
-	if v.root.PublicKey().Equal(pub) {
-		return []*pki.Certificate{v.root}, nil
+	root := v.anchors.RootFor(pub)
+	if root != nil {
+		return []*pki.Certificate{root}, nil
 	}
 
-	if v.upstream.PublicKey().Equal(pub) {
-		return []*pki.Certificate{v.upstream, v.root}, nil
+	upstream := v.anchors.Upstream()
+	if upstream.PublicKey().Equal(pub) {
+		return []*pki.Certificate{upstream, v.anchors.Root()}, nil
 	}
 
 	cmd := &requestKeyVerificationChainCommand{
@@ -81,12 +74,12 @@ func (v *upstreamVerifier) VerifyPublicKey(pub *pki.PublicKey) ([]*pki.Certifica
 		return nil, fmt.Errorf("server returned chain for wrong key")
 	}
 
-	intermediates := x509.NewCertPool()
+	intermediates := v.anchors.Intermediates()
 	for _, cert := range chain[1 : len(chain)-1] {
 		intermediates.AddCert(cert.ToX509())
 	}
 
-	verifiedChain, err := chain[0].VerifyChain(v.rootPool, intermediates)
+	verifiedChain, err := chain[0].VerifyChain(v.anchors.Roots(), intermediates)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify certificate chain: %w", err)
 	}
@@ -0,0 +1,82 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/rahn-it/svalin/pki"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// ErrPermissionDenied is returned by server side handlers if the partner may not perform an action.
+// It is the kind of the rpc package, so clients can match it on failed commands as well.
+var ErrPermissionDenied = rpc.ErrPermissionDenied
+
+const (
+	// RoleAdmin may manage users. The root user always has it.
+	RoleAdmin = "admin"
+	// RoleOperator may manage devices and open sessions on them.
+	RoleOperator = "operator"
+	// RoleViewer may only look at the device list, enrollments and renewals.
+	RoleViewer = "viewer"
+)
+
+// Roles contains all roles that can be assigned to users.
+var Roles = []string{RoleAdmin, RoleOperator, RoleViewer}
+
+// UserInfo is what user administration sees of a user, it leaves out all secrets.
+type UserInfo struct {
+	Certificate *pki.Certificate
+	Disabled    bool
+	Roles       []string
+}
+
+// HasRole checks if the user was assigned the given role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Permits checks if the user may act in the given role.
+// Admins may act as operators and operators as viewers, a user without any role may do nothing.
+func (u *User) Permits(role string) bool {
+	if u.HasRole(RoleAdmin) {
+		return true
+	}
+
+	switch role {
+	case RoleOperator:
+		return u.HasRole(RoleOperator)
+	case RoleViewer:
+		return u.HasRole(RoleOperator) || u.HasRole(RoleViewer)
+	default:
+		return false
+	}
+}
+
+// CheckRoles fails if any of the roles is unknown or there is none.
+func CheckRoles(roles []string) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("at least one role is required")
+	}
+
+	for _, role := range roles {
+		known := false
+		for _, r := range Roles {
+			if r == role {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("unknown role: %s", role)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package system_test
+
+import (
+	"testing"
+
+	"github.com/rahn-it/svalin/system"
+)
+
+func TestPermits(t *testing.T) {
+	tests := []struct {
+		roles   []string
+		role    string
+		permits bool
+	}{
+		{nil, system.RoleViewer, false},
+		{nil, system.RoleOperator, false},
+		{[]string{system.RoleViewer}, system.RoleViewer, true},
+		{[]string{system.RoleViewer}, system.RoleOperator, false},
+		{[]string{system.RoleOperator}, system.RoleViewer, true},
+		{[]string{system.RoleOperator}, system.RoleAdmin, false},
+		{[]string{system.RoleAdmin}, system.RoleOperator, true},
+	}
+
+	for _, test := range tests {
+		user := &system.User{Roles: test.roles}
+		if user.Permits(test.role) != test.permits {
+			t.Errorf("user with roles %v permits %s: expected %t", test.roles, test.role, test.permits)
+		}
+	}
+}
+
+func TestCheckRoles(t *testing.T) {
+	err := system.CheckRoles([]string{system.RoleViewer, system.RoleAdmin})
+	if err != nil {
+		t.Errorf("known roles rejected: %v", err)
+	}
+
+	err = system.CheckRoles([]string{"superuser"})
+	if err == nil {
+		t.Error("unknown role accepted")
+	}
+
+	err = system.CheckRoles(nil)
+	if err == nil {
+		t.Error("empty roles accepted")
+	}
+}
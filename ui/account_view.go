@@ -0,0 +1,171 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/mainview.go"
+)
+
+var _ mainview.MenuView = (*accountView)(nil)
+
+type accountView struct {
+	widget.BaseWidget
+	window fyne.Window
+	cli    *client.Client
+}
+
+func newAccountView(window fyne.Window, cli *client.Client) *accountView {
+	av := &accountView{
+		window: window,
+		cli:    cli,
+	}
+
+	av.ExtendBaseWidget(av)
+
+	return av
+}
+
+func (av *accountView) Icon() fyne.Resource {
+	return theme.AccountIcon()
+}
+
+func (av *accountView) Name() string {
+	return "Account"
+}
+
+func (av *accountView) CreateRenderer() fyne.WidgetRenderer {
+	statusLabel := widget.NewLabel("")
+
+	oldPasswordInput := widget.NewPasswordEntry()
+
+	newPasswordInput := widget.NewPasswordEntry()
+	newPasswordInput.Validator = func(s string) error {
+		if len(s) < 8 {
+			return fmt.Errorf("password must be at least 8 characters")
+		}
+
+		return nil
+	}
+
+	repeatPasswordInput := widget.NewPasswordEntry()
+	repeatPasswordInput.Validator = func(s string) error {
+		if newPasswordInput.Text != s {
+			return fmt.Errorf("passwords do not match")
+		}
+
+		return nil
+	}
+
+	totpInput := widget.NewEntry()
+	totpInput.PlaceHolder = "00000000"
+
+	passwordForm := widget.NewForm(
+		widget.NewFormItem("Current Password", oldPasswordInput),
+		widget.NewFormItem("New Password", newPasswordInput),
+		widget.NewFormItem("Repeat Password", repeatPasswordInput),
+		widget.NewFormItem("TOTP", totpInput),
+	)
+	passwordForm.SubmitText = "Change Password"
+	passwordForm.OnSubmit = func() {
+		go func() {
+			err := av.cli.ChangePassword([]byte(oldPasswordInput.Text), []byte(newPasswordInput.Text), totpInput.Text)
+			if err != nil {
+				log.Printf("error changing password: %v", err)
+				statusLabel.SetText("Changing the password failed")
+				return
+			}
+
+			oldPasswordInput.SetText("")
+			newPasswordInput.SetText("")
+			repeatPasswordInput.SetText("")
+			totpInput.SetText("")
+			statusLabel.SetText("Password changed")
+		}()
+	}
+
+	totpButton := widget.NewButton("Set Up New Authenticator", func() {
+		go func() {
+			code, secret, err := askForNewTotp(av.cli.Username(), av.window.Canvas())
+			if err != nil {
+				log.Printf("error generating totp: %v", err)
+				return
+			}
+
+			err = av.cli.ConfirmTotp(secret, code)
+			if err != nil {
+				log.Printf("error confirming totp: %v", err)
+				statusLabel.SetText("Setting up the authenticator failed, an admin has to reset it first")
+				return
+			}
+
+			statusLabel.SetText("Authenticator replaced")
+		}()
+	})
+
+	recoveryTotpInput := widget.NewEntry()
+	recoveryTotpInput.PlaceHolder = "00000000"
+
+	recoveryForm := widget.NewForm(
+		widget.NewFormItem("TOTP", recoveryTotpInput),
+	)
+	recoveryForm.SubmitText = "Regenerate Recovery Codes"
+	recoveryForm.OnSubmit = func() {
+		go func() {
+			codes, err := av.cli.RegenerateRecoveryCodes(recoveryTotpInput.Text)
+			if err != nil {
+				log.Printf("error regenerating recovery codes: %v", err)
+				statusLabel.SetText("Regenerating the recovery codes failed")
+				return
+			}
+
+			recoveryTotpInput.SetText("")
+			statusLabel.SetText("Recovery codes replaced, the old ones no longer work")
+
+			showRecoveryCodes(codes, av.window.Canvas())
+		}()
+	}
+
+	return &accountViewRenderer{
+		container: container.NewVBox(
+			widget.NewLabel("Account"),
+			layout.NewSpacer(),
+			passwordForm,
+			widget.NewSeparator(),
+			totpButton,
+			widget.NewSeparator(),
+			recoveryForm,
+			statusLabel,
+			layout.NewSpacer(),
+		),
+	}
+}
+
+type accountViewRenderer struct {
+	container *fyne.Container
+}
+
+func (r *accountViewRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *accountViewRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *accountViewRenderer) Destroy() {
+}
+
+func (r *accountViewRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *accountViewRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
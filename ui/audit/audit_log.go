@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/components"
+	"github.com/rahn-it/svalin/ui/mainview.go"
+	"github.com/rahn-it/svalin/util"
+)
+
+var _ mainview.MenuView = (*auditLogView)(nil)
+
+type auditLogView struct {
+	widget.BaseWidget
+	cli     *client.Client
+	entries util.UpdateableMap[uint64, *system.AuditEntry]
+	query   system.AuditQuery
+	status  *widget.Label
+}
+
+func NewAuditLogView(cli *client.Client) *auditLogView {
+	a := &auditLogView{
+		cli:     cli,
+		entries: util.NewObservableMap[uint64, *system.AuditEntry](),
+		status:  widget.NewLabel(""),
+	}
+
+	a.ExtendBaseWidget(a)
+
+	return a
+}
+
+func (a *auditLogView) Icon() fyne.Resource {
+	return theme.HistoryIcon()
+}
+
+func (a *auditLogView) Name() string {
+	return "Audit Log"
+}
+
+// load replaces the displayed entries with the ones matching the current query.
+func (a *auditLogView) load() {
+	page, err := a.cli.QueryAuditLog(a.query)
+	if err != nil {
+		log.Printf("Error querying audit log: %v", err)
+		a.status.SetText("Querying the audit log failed")
+		return
+	}
+
+	if page.ChainError != "" {
+		a.status.SetText(fmt.Sprintf("The audit log was tampered with: %s", page.ChainError))
+	} else {
+		a.status.SetText(fmt.Sprintf("%d entries, verified", len(page.Entries)))
+	}
+
+	current := make(map[uint64]bool)
+	for _, entry := range page.Entries {
+		current[entry.Seq] = true
+		a.entries.Set(entry.Seq, entry)
+	}
+
+	stale := make([]uint64, 0)
+	a.entries.ForEach(func(seq uint64, _ *system.AuditEntry) error {
+		if !current[seq] {
+			stale = append(stale, seq)
+		}
+		return nil
+	})
+
+	for _, seq := range stale {
+		a.entries.Delete(seq)
+	}
+}
+
+func (a *auditLogView) CreateRenderer() fyne.WidgetRenderer {
+	table := components.NewTable[uint64, *system.AuditEntry](
+		a.entries,
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Time")
+			},
+			func(entry *system.AuditEntry, label *widget.Label) {
+				label.SetText(entry.Event.Time.Local().Format(time.DateTime))
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Event")
+			},
+			func(entry *system.AuditEntry, label *widget.Label) {
+				if entry.Event.Command != "" {
+					label.SetText(entry.Event.Command)
+				} else {
+					label.SetText(entry.Event.Type)
+				}
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Subject")
+			},
+			func(entry *system.AuditEntry, label *widget.Label) {
+				label.SetText(entry.Event.Subject)
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Target")
+			},
+			func(entry *system.AuditEntry, label *widget.Label) {
+				label.SetText(entry.Event.Target)
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Result")
+			},
+			func(entry *system.AuditEntry, label *widget.Label) {
+				if entry.Event.Code != 0 {
+					label.SetText(fmt.Sprintf("%d in %s", entry.Event.Code, entry.Event.Duration.Round(time.Millisecond)))
+				} else {
+					label.SetText(entry.Event.Addr)
+				}
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Detail")
+			},
+			func(entry *system.AuditEntry, label *widget.Label) {
+				label.SetText(entry.Event.Detail)
+			},
+		),
+	)
+
+	typeInput := widget.NewSelect(append([]string{""}, auditTypes...), nil)
+	typeInput.PlaceHolder = "Any Event"
+
+	subjectInput := widget.NewEntry()
+	subjectInput.PlaceHolder = "Subject"
+
+	targetInput := widget.NewEntry()
+	targetInput.PlaceHolder = "Target"
+
+	refreshButton := widget.NewButtonWithIcon("Refresh", theme.ViewRefreshIcon(), func() {
+		a.query = system.AuditQuery{
+			Type:    typeInput.Selected,
+			Subject: subjectInput.Text,
+			Target:  targetInput.Text,
+		}
+		go a.load()
+	})
+
+	go a.load()
+
+	return &auditLogViewRenderer{
+		container: container.NewBorder(
+			container.NewGridWithColumns(4, typeInput, subjectInput, targetInput, refreshButton),
+			a.status,
+			nil, nil,
+			container.NewVScroll(table),
+		),
+	}
+}
+
+var auditTypes = []string{
+	system.AuditRpcSession,
+	system.AuditLoginSucceeded,
+	system.AuditLoginFailed,
+	system.AuditLoginLockedOut,
+	system.AuditTotpReplay,
+	system.AuditRecoveryCodeUsed,
+}
+
+type auditLogViewRenderer struct {
+	container *fyne.Container
+}
+
+func (r *auditLogViewRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *auditLogViewRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *auditLogViewRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *auditLogViewRenderer) Destroy() {
+}
+
+func (r *auditLogViewRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
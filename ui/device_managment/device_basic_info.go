@@ -1,6 +1,7 @@
 package managment
 
 import (
+	"fmt"
 	"io"
 	"log"
 
@@ -9,61 +10,93 @@ import (
 	"fyne.io/fyne/v2/widget"
 	"github.com/fyne-io/terminal"
 	"github.com/rahn-it/svalin/rmm"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/mainview.go"
 )
 
 type deviceBasicInfo struct {
 	widget.BaseWidget
+	main   *mainview.MainView
+	cli    *client.Client
 	device *rmm.Device
 }
 
-func newDeviceBasicInfo(device *rmm.Device) *deviceBasicInfo {
+func newDeviceBasicInfo(main *mainview.MainView, cli *client.Client, device *rmm.Device) *deviceBasicInfo {
 	return &deviceBasicInfo{
+		main:   main,
+		cli:    cli,
 		device: device,
 	}
 }
 
 func (d *deviceBasicInfo) CreateRenderer() fyne.WidgetRenderer {
 
+	version := "Agent version: unknown"
+	if peer := d.device.LiveInfo.Peer; peer != nil {
+		version = fmt.Sprintf("Agent version: %s (protocol %d)", peer.BuildVersion, peer.ProtocolVersion)
+	}
+
+	terminalButton := widget.NewButton("Terminal", func() {
+		term := terminal.New()
+		window := fyne.CurrentApp().NewWindow("Terminal for " + d.device.Name())
+		window.Resize(fyne.NewSize(800, 600))
+		window.SetContent(term)
+
+		readStdin, writeStdin := io.Pipe()
+		readStdout, writeStdout := io.Pipe()
+
+		async, err := d.device.OpenShell(readStdin, writeStdout)
+		if err != nil {
+			log.Printf("error opening shell: %v", err)
+			return
+		}
+
+		window.SetOnClosed(func() {
+			async.Close()
+		})
+
+		go func() {
+			err := term.RunWithConnection(writeStdin, readStdout)
+			if err != nil {
+				log.Printf("error running shell: %v", err)
+			}
+		}()
+
+		go func() {
+			async.Wait()
+			window.Close()
+		}()
+
+		go func() {
+			window.Show()
+		}()
+	})
+
+	clockWarning := widget.NewLabel(d.device.LiveInfo.ClockWarning)
+	clockWarning.Importance = widget.WarningImportance
+	if d.device.LiveInfo.ClockWarning == "" {
+		clockWarning.Hide()
+	}
+
+	if !d.device.CanOpenShell() {
+		terminalButton.SetText("Terminal (not supported by agent)")
+		terminalButton.Disable()
+	}
+
 	return &deviceBasicInfoRenderer{
 		widget: d,
 		container: container.NewVBox(
 			widget.NewLabel(d.device.Name()),
+			widget.NewLabel(version),
+			clockWarning,
 			container.NewGridWithColumns(2),
-			widget.NewButton("Terminal", func() {
-				term := terminal.New()
-				window := fyne.CurrentApp().NewWindow("Terminal for " + d.device.Name())
-				window.Resize(fyne.NewSize(800, 600))
-				window.SetContent(term)
-
-				readStdin, writeStdin := io.Pipe()
-				readStdout, writeStdout := io.Pipe()
-
-				async, err := d.device.OpenShell(readStdin, writeStdout)
-				if err != nil {
-					log.Printf("error opening shell: %v", err)
-					return
-				}
-
-				window.SetOnClosed(func() {
-					async.Close()
-				})
-
-				go func() {
-					err := term.RunWithConnection(writeStdin, readStdout)
-					if err != nil {
-						log.Printf("error running shell: %v", err)
-					}
-				}()
-
-				go func() {
-					async.Wait()
-					window.Close()
-				}()
-
-				go func() {
-					window.Show()
-				}()
+			widget.NewButton("Rename", func() {
+				d.main.PushView(newRenameDeviceView(d.main, d.cli, d.device))
+			}),
+			widget.NewButton("Shell Recordings", func() {
+				d.main.PushView(newShellRecordingList(d.cli, d.device))
 			}),
+			terminalButton,
 		),
 	}
 }
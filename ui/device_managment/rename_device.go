@@ -0,0 +1,80 @@
+package managment
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/rmm"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/mainview.go"
+)
+
+type renameDeviceView struct {
+	widget.BaseWidget
+	main   *mainview.MainView
+	cli    *client.Client
+	device *rmm.Device
+}
+
+func newRenameDeviceView(main *mainview.MainView, cli *client.Client, device *rmm.Device) *renameDeviceView {
+	rdv := &renameDeviceView{
+		main:   main,
+		cli:    cli,
+		device: device,
+	}
+
+	rdv.ExtendBaseWidget(rdv)
+
+	return rdv
+}
+
+func (rdv *renameDeviceView) CreateRenderer() fyne.WidgetRenderer {
+	nameInput := widget.NewEntry()
+	nameInput.SetText(rdv.device.Name())
+
+	renameButton := widget.NewButton("Rename", func() {
+		err := rdv.cli.RenameDevice(rdv.device.Certificate, nameInput.Text)
+		if err != nil {
+			rdv.main.ShowError(err)
+			return
+		}
+		rdv.main.PopView()
+	})
+
+	return &renameDeviceViewRenderer{
+		widget: rdv,
+		container: container.NewVBox(
+			widget.NewLabel("Rename Device"),
+			layout.NewSpacer(),
+			widget.NewLabel("Device Name"),
+			nameInput,
+			renameButton,
+			layout.NewSpacer(),
+		),
+	}
+}
+
+type renameDeviceViewRenderer struct {
+	widget    *renameDeviceView
+	container *fyne.Container
+}
+
+func (r *renameDeviceViewRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *renameDeviceViewRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *renameDeviceViewRenderer) Destroy() {
+}
+
+func (r *renameDeviceViewRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *renameDeviceViewRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
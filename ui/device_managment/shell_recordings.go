@@ -0,0 +1,179 @@
+package managment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/fyne-io/terminal"
+	"github.com/rahn-it/svalin/rmm"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/components"
+	"github.com/rahn-it/svalin/util"
+)
+
+type shellRecordingList struct {
+	widget.BaseWidget
+	cli        *client.Client
+	device     *rmm.Device
+	recordings util.UpdateableMap[string, *rmm.ShellRecording]
+}
+
+func newShellRecordingList(cli *client.Client, device *rmm.Device) *shellRecordingList {
+	s := &shellRecordingList{
+		cli:        cli,
+		device:     device,
+		recordings: util.NewObservableMap[string, *rmm.ShellRecording](),
+	}
+
+	s.ExtendBaseWidget(s)
+
+	go s.load()
+
+	return s
+}
+
+func (s *shellRecordingList) load() {
+	recordings, err := s.cli.ShellRecordings(s.device.Certificate.PublicKey())
+	if err != nil {
+		log.Printf("Error listing shell recordings: %v", err)
+		return
+	}
+
+	for _, recording := range recordings {
+		s.recordings.Set(recording.ID, recording)
+	}
+}
+
+func (s *shellRecordingList) CreateRenderer() fyne.WidgetRenderer {
+	table := components.NewTable[string, *rmm.ShellRecording](
+		s.recordings,
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Started")
+			},
+			func(recording *rmm.ShellRecording, label *widget.Label) {
+				label.SetText(recording.Start.Local().Format(time.DateTime))
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Duration")
+			},
+			func(recording *rmm.ShellRecording, label *widget.Label) {
+				label.SetText(recording.Duration.Round(time.Second).String())
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("User")
+			},
+			func(recording *rmm.ShellRecording, label *widget.Label) {
+				if recording.User != nil {
+					label.SetText(recording.User.GetName())
+				}
+			},
+		),
+		components.Column(
+			func() *widget.Button {
+				return widget.NewButton("Play", func() {
+
+				})
+			},
+			func(recording *rmm.ShellRecording, button *widget.Button) {
+				button.OnTapped = func() {
+					go s.play(recording.ID)
+				}
+			},
+		),
+	)
+
+	refreshButton := widget.NewButtonWithIcon("Refresh", theme.ViewRefreshIcon(), func() {
+		go s.load()
+	})
+
+	return &shellRecordingListRenderer{
+		container: container.NewBorder(
+			container.NewHBox(widget.NewLabel("Shell Recordings of "+s.device.Name()), refreshButton),
+			nil, nil, nil,
+			table,
+		),
+	}
+}
+
+// play replays the recording in a terminal window.
+func (s *shellRecordingList) play(id string) {
+	recording, err := s.cli.ShellRecording(s.device.Certificate.PublicKey(), id)
+	if err != nil {
+		log.Printf("error getting shell recording: %v", err)
+		return
+	}
+
+	term := terminal.New()
+	title := fmt.Sprintf("Recording of %s on %s", recording.User.GetName(), s.device.Name())
+	window := fyne.CurrentApp().NewWindow(title)
+	window.Resize(fyne.NewSize(800, 600))
+	window.SetContent(term)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	window.SetOnClosed(cancel)
+
+	readOutput, writeOutput := io.Pipe()
+
+	go func() {
+		err := rmm.PlayShellRecording(ctx, recording.Data, writeOutput)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error playing shell recording: %v", err)
+		}
+		writeOutput.Close()
+	}()
+
+	go func() {
+		err := term.RunWithConnection(discardInput{}, readOutput)
+		if err != nil {
+			log.Printf("error running terminal: %v", err)
+		}
+	}()
+
+	window.Show()
+}
+
+// discardInput drops the keystrokes typed into the playback terminal.
+type discardInput struct{}
+
+func (discardInput) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardInput) Close() error {
+	return nil
+}
+
+type shellRecordingListRenderer struct {
+	container *fyne.Container
+}
+
+func (r *shellRecordingListRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *shellRecordingListRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *shellRecordingListRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *shellRecordingListRenderer) Destroy() {
+}
+
+func (r *shellRecordingListRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
@@ -0,0 +1,125 @@
+package enrollment
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/mainview.go"
+)
+
+var tokenValidities = map[string]time.Duration{
+	"1 hour":  time.Hour,
+	"1 day":   24 * time.Hour,
+	"7 days":  7 * 24 * time.Hour,
+	"30 days": 30 * 24 * time.Hour,
+}
+
+type createTokenView struct {
+	widget.BaseWidget
+	main *mainview.MainView
+	cli  *client.Client
+}
+
+func NewCreateTokenView(main *mainview.MainView, cli *client.Client) *createTokenView {
+	ctv := &createTokenView{
+		main: main,
+		cli:  cli,
+	}
+
+	ctv.ExtendBaseWidget(ctv)
+
+	return ctv
+}
+
+func (ctv *createTokenView) CreateRenderer() fyne.WidgetRenderer {
+	nameInput := widget.NewEntry()
+	nameInput.SetPlaceHolder("device-{n}")
+
+	tagsInput := widget.NewEntry()
+	tagsInput.SetPlaceHolder("tag1, tag2")
+
+	groupInput := widget.NewEntry()
+
+	validityInput := widget.NewSelect([]string{"1 hour", "1 day", "7 days", "30 days"}, nil)
+	validityInput.SetSelected("1 day")
+
+	singleUseInput := widget.NewCheck("Single use", nil)
+
+	tokenOutput := widget.NewMultiLineEntry()
+	tokenOutput.Wrapping = fyne.TextWrapBreak
+
+	createButton := widget.NewButton("Create", func() {
+		tags := make([]string, 0)
+		for _, tag := range strings.Split(tagsInput.Text, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+
+		token, err := ctv.cli.CreateEnrollmentToken(
+			nameInput.Text,
+			tags,
+			groupInput.Text,
+			tokenValidities[validityInput.Selected],
+			singleUseInput.Checked,
+		)
+		if err != nil {
+			log.Printf("Error creating enrollment token: %v", err)
+			return
+		}
+
+		tokenOutput.SetText(token)
+	})
+
+	return &createTokenViewRenderer{
+		widget: ctv,
+		container: container.NewVBox(
+			widget.NewLabel("Create Enrollment Token"),
+			layout.NewSpacer(),
+			widget.NewLabel("Device Name Pattern"),
+			nameInput,
+			widget.NewLabel("Tags"),
+			tagsInput,
+			widget.NewLabel("Group"),
+			groupInput,
+			widget.NewLabel("Valid For"),
+			validityInput,
+			singleUseInput,
+			createButton,
+			widget.NewLabel("Token"),
+			tokenOutput,
+			layout.NewSpacer(),
+		),
+	}
+}
+
+type createTokenViewRenderer struct {
+	widget    *createTokenView
+	container *fyne.Container
+}
+
+func (ctvr *createTokenViewRenderer) MinSize() fyne.Size {
+	return ctvr.container.MinSize()
+}
+
+func (ctvr *createTokenViewRenderer) Layout(size fyne.Size) {
+	ctvr.container.Resize(size)
+}
+
+func (ctvr *createTokenViewRenderer) Destroy() {
+}
+
+func (ctvr *createTokenViewRenderer) Refresh() {
+	ctvr.container.Refresh()
+}
+
+func (ctvr *createTokenViewRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{ctvr.container}
+}
@@ -1,7 +1,9 @@
 package enrollment
 
 import (
+	"errors"
 	"log"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -33,22 +35,62 @@ func NewEnrollDeviceView(main *mainview.MainView, cli *client.Client, enrollment
 
 func (edv *enrollDeviceView) CreateRenderer() fyne.WidgetRenderer {
 	nameInput := widget.NewEntry()
+	codeInput := widget.NewEntry()
+	codeInput.SetPlaceHolder("0000 0000")
+	errorLabel := widget.NewLabel("")
+	errorLabel.Hide()
+
 	enrollButton := widget.NewButton("Enroll", func() {
-		err := edv.cli.EnrollDevice(edv.enrollment.PublicKey, nameInput.Text)
+		err := edv.cli.EnrollDevice(edv.enrollment.PublicKey, nameInput.Text, codeInput.Text)
 		if err != nil {
 			log.Printf("Error enrolling device: %v", err)
+			if errors.Is(err, rpc.ErrInvalidArgument) || errors.Is(err, rpc.ErrPermissionDenied) {
+				errorLabel.SetText("Enrollment failed, check the verification code shown on the device")
+			} else {
+				errorLabel.SetText("Enrollment failed: " + mainview.ErrorMessage(err))
+			}
+			errorLabel.Show()
+			return
 		}
 		edv.main.PopView()
 	})
 
+	reasonInput := widget.NewEntry()
+	blockInput := widget.NewCheck("Block this address", nil)
+	rejectButton := widget.NewButton("Reject", func() {
+		err := edv.cli.RejectEnrollment(edv.enrollment.PublicKey, reasonInput.Text, blockInput.Checked)
+		if err != nil {
+			edv.main.ShowError(err)
+			return
+		}
+		edv.main.PopView()
+	})
+
+	metadata := edv.enrollment.Metadata
+
 	return &enrollDeviceViewRenderer{
 		widget: edv,
 		container: container.NewVBox(
 			widget.NewLabel("Enroll Device"),
 			layout.NewSpacer(),
+			widget.NewForm(
+				widget.NewFormItem("Address", widget.NewLabel(edv.enrollment.Addr)),
+				widget.NewFormItem("Hostname", widget.NewLabel(metadata.Hostname)),
+				widget.NewFormItem("OS", widget.NewLabel(metadata.OS)),
+				widget.NewFormItem("Hardware IDs", widget.NewLabel(strings.Join(metadata.HardwareIDs, "\n"))),
+				widget.NewFormItem("MAC Addresses", widget.NewLabel(strings.Join(metadata.MACs, "\n"))),
+			),
 			widget.NewLabel("Device Name"),
 			nameInput,
+			widget.NewLabel("Verification Code"),
+			codeInput,
+			errorLabel,
 			enrollButton,
+			widget.NewSeparator(),
+			widget.NewLabel("Rejection Reason"),
+			reasonInput,
+			blockInput,
+			rejectButton,
 			layout.NewSpacer(),
 		),
 	}
@@ -2,6 +2,7 @@ package enrollment
 
 import (
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/rahn-it/svalin/rpc"
@@ -49,6 +50,22 @@ func (e *enrollmentList) CreateRenderer() fyne.WidgetRenderer {
 				label.SetText(en.Addr)
 			},
 		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Hostname")
+			},
+			func(en *rpc.Enrollment, label *widget.Label) {
+				label.SetText(en.Metadata.Hostname)
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("OS")
+			},
+			func(en *rpc.Enrollment, label *widget.Label) {
+				label.SetText(en.Metadata.OS)
+			},
+		),
 		components.Column(
 			func() *widget.Button {
 				return widget.NewButton("Select", func() {
@@ -65,28 +82,34 @@ func (e *enrollmentList) CreateRenderer() fyne.WidgetRenderer {
 		),
 	)
 
+	tokenButton := widget.NewButton("Create Token", func() {
+		e.main.PushView(NewCreateTokenView(e.main, e.cli))
+	})
+
 	return &enrollmentListRenderer{
-		table: table,
+		table:     table,
+		container: container.NewBorder(tokenButton, nil, nil, nil, table),
 	}
 }
 
 type enrollmentListRenderer struct {
-	table *components.Table[string, *rpc.Enrollment]
+	table     *components.Table[string, *rpc.Enrollment]
+	container *fyne.Container
 }
 
 func (e *enrollmentListRenderer) Layout(size fyne.Size) {
 
-	e.table.Resize(size)
+	e.container.Resize(size)
 }
 
 func (e *enrollmentListRenderer) MinSize() fyne.Size {
 
-	return e.table.MinSize()
+	return e.container.MinSize()
 }
 
 func (e *enrollmentListRenderer) Refresh() {
 
-	e.table.Refresh()
+	e.container.Refresh()
 }
 
 func (e *enrollmentListRenderer) Destroy() {
@@ -94,5 +117,5 @@ func (e *enrollmentListRenderer) Destroy() {
 }
 
 func (e *enrollmentListRenderer) Objects() []fyne.CanvasObject {
-	return []fyne.CanvasObject{e.table}
+	return []fyne.CanvasObject{e.container}
 }
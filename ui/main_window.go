@@ -2,10 +2,12 @@ package ui
 
 import (
 	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/audit"
 	managment "github.com/rahn-it/svalin/ui/device_managment"
 	"github.com/rahn-it/svalin/ui/enrollment"
 	"github.com/rahn-it/svalin/ui/mainview.go"
 	"github.com/rahn-it/svalin/ui/tunnels"
+	"github.com/rahn-it/svalin/ui/users"
 
 	"fyne.io/fyne/v2"
 )
@@ -19,9 +21,18 @@ func startMainMenu(window fyne.Window, client *client.Client) {
 
 	enrollView := enrollment.NewEnrollmentList(m, client)
 
+	userView := users.NewUserList(m, client)
+
+	auditView := audit.NewAuditLogView(client)
+
+	accountView := newAccountView(window, client)
+
 	m.Display(window, []mainview.MenuView{
 		manageView,
 		tunnelView,
 		enrollView,
+		userView,
+		auditView,
+		accountView,
 	})
 }
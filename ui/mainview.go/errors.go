@@ -0,0 +1,48 @@
+package mainview
+
+import (
+	"errors"
+	"log"
+
+	"fyne.io/fyne/v2/dialog"
+	"github.com/rahn-it/svalin/rpc"
+)
+
+// ErrorMessage describes a failed command for the user.
+func ErrorMessage(err error) string {
+	var msg string
+	switch {
+	case errors.Is(err, rpc.ErrPermissionDenied):
+		msg = "You are not allowed to do this"
+	case errors.Is(err, rpc.ErrNotFound):
+		msg = "Not found"
+	case errors.Is(err, rpc.ErrInvalidArgument):
+		msg = "Invalid input"
+	case errors.Is(err, rpc.ErrRateLimited):
+		msg = "Too many requests, try again later"
+	case errors.Is(err, rpc.ErrTimeout):
+		msg = "The request timed out"
+	case errors.Is(err, rpc.ErrUnavailable):
+		msg = "The server or device can't be reached"
+	default:
+		return "Something went wrong"
+	}
+
+	var sessionErr *rpc.SessionError
+	if errors.As(err, &sessionErr) && sessionErr.Message() != "" {
+		msg += ": " + sessionErr.Message()
+	}
+
+	return msg
+}
+
+// ShowError logs err and tells the user what went wrong.
+func (m *MainView) ShowError(err error) {
+	log.Printf("Error: %v", err)
+
+	if m.window == nil {
+		return
+	}
+
+	dialog.NewError(errors.New(ErrorMessage(err)), m.window).Show()
+}
@@ -14,6 +14,7 @@ type MainView struct {
 	mainContainer *fyne.Container
 	leftMenu      *fyne.Container
 	backButton    *widget.Button
+	window        fyne.Window
 }
 
 type MenuView interface {
@@ -91,6 +92,7 @@ func (m *MainView) refreshBackButton() {
 }
 
 func (m *MainView) Display(w fyne.Window, views []MenuView) {
+	m.window = w
 	m.leftMenu.RemoveAll()
 	for _, view := range views {
 		v := view
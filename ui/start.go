@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -212,12 +213,12 @@ func setupLoginForm(w fyne.Window, addr string, conn *rpc.RpcConnection) {
 
 	totpInput := widget.NewEntry()
 	totpInput.PlaceHolder = "00000000"
-	totpInput.Validator = validation.NewRegexp("^[0-9]{8}$", "invalid TOTP code")
+	totpInput.Validator = validation.NewRegexp("^([0-9]{8}|[A-Za-z2-7]{4}(-[A-Za-z2-7]{4}){3})$", "invalid TOTP or recovery code")
 
 	form := widget.NewForm(
 		widget.NewFormItem("User", userInput),
 		widget.NewFormItem("Password", passwordInput),
-		widget.NewFormItem("TOTP", totpInput),
+		widget.NewFormItem("TOTP or Recovery Code", totpInput),
 	)
 
 	form.OnSubmit = func() {
@@ -268,9 +269,105 @@ func setupLoginForm(w fyne.Window, addr string, conn *rpc.RpcConnection) {
 		}()
 	}
 
-	w.SetContent(
+	inviteButton := widget.NewButton("Register with Invite", func() {
+		setupInviteForm(w, addr, conn)
+	})
+
+	w.SetContent(container.NewVBox(
 		form,
+		inviteButton,
+	))
+}
+
+func setupInviteForm(w fyne.Window, addr string, conn *rpc.RpcConnection) {
+	inviteInput := widget.NewMultiLineEntry()
+	inviteInput.Wrapping = fyne.TextWrapBreak
+
+	passwordInput := widget.NewPasswordEntry()
+	passwordInput.Validator = func(s string) error {
+		if len(s) < 8 {
+			return fmt.Errorf("password must be at least 8 characters")
+		}
+
+		return nil
+	}
+
+	passwordRepeatInput := widget.NewPasswordEntry()
+	passwordRepeatInput.Validator = func(s string) error {
+		if passwordInput.Text != s {
+			return fmt.Errorf("passwords do not match")
+		}
+
+		return nil
+	}
+
+	infoLabel := widget.NewLabel("")
+
+	form := widget.NewForm(
+		widget.NewFormItem("Invite", inviteInput),
+		widget.NewFormItem("Password", passwordInput),
+		widget.NewFormItem("Repeat Password", passwordRepeatInput),
 	)
+
+	form.SubmitText = "Register"
+
+	form.OnSubmit = func() {
+		go func() {
+			invite, err := system.DecodeInvite(inviteInput.Text)
+			if err != nil {
+				log.Printf("error decoding invite: %v", err)
+				infoLabel.SetText("Invalid invite")
+				return
+			}
+
+			username, err := system.InviteUsername(invite)
+			if err != nil {
+				log.Printf("error reading invite: %v", err)
+				infoLabel.SetText("Invalid invite")
+				return
+			}
+
+			totpCode, totpSecret, err := askForNewTotp(username, w.Canvas())
+			if err != nil {
+				log.Printf("error generating totp: %v", err)
+				return
+			}
+
+			executor, err := system.NewInviteExecutor(invite, []byte(passwordInput.Text), totpSecret, totpCode)
+			if err != nil {
+				log.Printf("error reading invite: %v", err)
+				return
+			}
+
+			err = rpc.Login(conn, executor.Register)
+			if err != nil {
+				log.Printf("error registering: %v", err)
+				infoLabel.SetText("Registration failed")
+				return
+			}
+
+			log.Printf("registration of %s sent, waiting for approval", executor.Username())
+
+			setupRegistrationDone(w, executor.Username())
+		}()
+	}
+
+	w.SetContent(container.NewVBox(
+		form,
+		infoLabel,
+	))
+}
+
+func setupRegistrationDone(w fyne.Window, username string) {
+	infoLabel := widget.NewLabel(fmt.Sprintf("Registration of %s is waiting for approval by the admin who invited you. You can log in once it was approved.", username))
+	infoLabel.Wrapping = fyne.TextWrapWord
+
+	w.SetContent(container.NewVBox(
+		infoLabel,
+		widget.NewButton("Continue", func() {
+			setup(w)
+		}),
+	))
 }
 
 func setupServerForm(w fyne.Window, addr string, conn *rpc.RpcConnection) {
@@ -348,7 +445,7 @@ func setupServerForm(w fyne.Window, addr string, conn *rpc.RpcConnection) {
 				return
 			}
 
-			ep, err := rpc.ConnectToServer(context.Background(), addr, credentials, upstream, verifier)
+			ep, err := rpc.ConnectToServer(context.Background(), addr, credentials, upstream, verifier, nil)
 			if err != nil {
 				log.Printf("failed to connect to server: %v", err)
 				return
@@ -360,6 +457,8 @@ func setupServerForm(w fyne.Window, addr string, conn *rpc.RpcConnection) {
 				return
 			}
 
+			showRecoveryCodes(regCmd.RecoveryCodes, w.Canvas())
+
 			profilename := fmt.Sprintf("%s@%s", username, addr)
 
 			profile, err := config.OpenProfile(profilename, "client")
@@ -475,6 +574,40 @@ func askForNewTotp(accountName string, targetCanvas fyne.Canvas) (code string, s
 	return
 }
 
+// showRecoveryCodes blocks until the user confirmed having saved the recovery codes.
+func showRecoveryCodes(codes []string, targetCanvas fyne.Canvas) {
+	text := strings.Join(codes, "\n")
+
+	codesEntry := &widget.Entry{
+		Text:      text,
+		MultiLine: true,
+	}
+	codesEntry.OnChanged = func(s string) {
+		codesEntry.SetText(text)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	once := sync.Once{}
+
+	popup := widget.NewModalPopUp(
+		container.NewVBox(
+			widget.NewLabel("Save these recovery codes, each can be used once instead of a TOTP code."),
+			codesEntry,
+			widget.NewButton("I saved the codes", func() {
+				once.Do(wg.Done)
+			}),
+		),
+		targetCanvas,
+	)
+	popup.Resize(fyne.NewSize(500, 400))
+	popup.Show()
+
+	wg.Wait()
+
+	popup.Hide()
+}
+
 func openClient(profile *config.Profile, password []byte) (*client.Client, bool, error) {
 
 	client, err := client.OpenClient(profile, password)
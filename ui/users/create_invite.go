@@ -0,0 +1,105 @@
+package users
+
+import (
+	"log"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/system/client"
+)
+
+var inviteValidities = map[string]time.Duration{
+	"1 hour": time.Hour,
+	"1 day":  24 * time.Hour,
+	"7 days": 7 * 24 * time.Hour,
+}
+
+type createInviteView struct {
+	widget.BaseWidget
+	cli *client.Client
+}
+
+func newCreateInviteView(cli *client.Client) *createInviteView {
+	civ := &createInviteView{
+		cli: cli,
+	}
+
+	civ.ExtendBaseWidget(civ)
+
+	return civ
+}
+
+func (civ *createInviteView) CreateRenderer() fyne.WidgetRenderer {
+	nameInput := widget.NewEntry()
+
+	rolesInput := widget.NewCheckGroup(system.Roles, nil)
+	rolesInput.SetSelected([]string{system.RoleViewer})
+
+	validityInput := widget.NewSelect([]string{"1 hour", "1 day", "7 days"}, nil)
+	validityInput.SetSelected("1 day")
+
+	autoApproveInput := widget.NewCheck("Approve registration automatically", nil)
+
+	inviteOutput := widget.NewMultiLineEntry()
+	inviteOutput.Wrapping = fyne.TextWrapBreak
+
+	createButton := widget.NewButton("Create", func() {
+		invite, err := civ.cli.CreateInvite(
+			nameInput.Text,
+			rolesInput.Selected,
+			inviteValidities[validityInput.Selected],
+			autoApproveInput.Checked,
+		)
+		if err != nil {
+			log.Printf("Error creating invite: %v", err)
+			return
+		}
+
+		inviteOutput.SetText(invite)
+	})
+
+	return &createInviteViewRenderer{
+		container: container.NewVBox(
+			widget.NewLabel("Invite User"),
+			layout.NewSpacer(),
+			widget.NewLabel("Username"),
+			nameInput,
+			widget.NewLabel("Roles"),
+			rolesInput,
+			widget.NewLabel("Valid For"),
+			validityInput,
+			autoApproveInput,
+			createButton,
+			widget.NewLabel("Invite"),
+			inviteOutput,
+			layout.NewSpacer(),
+		),
+	}
+}
+
+type createInviteViewRenderer struct {
+	container *fyne.Container
+}
+
+func (r *createInviteViewRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *createInviteViewRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *createInviteViewRenderer) Destroy() {
+}
+
+func (r *createInviteViewRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *createInviteViewRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
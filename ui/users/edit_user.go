@@ -0,0 +1,122 @@
+package users
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/mainview.go"
+)
+
+type editUserView struct {
+	widget.BaseWidget
+	main      *mainview.MainView
+	cli       *client.Client
+	user      *system.UserInfo
+	onChanged func()
+}
+
+func newEditUserView(main *mainview.MainView, cli *client.Client, user *system.UserInfo, onChanged func()) *editUserView {
+	euv := &editUserView{
+		main:      main,
+		cli:       cli,
+		user:      user,
+		onChanged: onChanged,
+	}
+
+	euv.ExtendBaseWidget(euv)
+
+	return euv
+}
+
+func (euv *editUserView) CreateRenderer() fyne.WidgetRenderer {
+	key := euv.user.Certificate.PublicKey()
+
+	rolesInput := widget.NewCheckGroup(system.Roles, nil)
+	rolesInput.SetSelected(euv.user.Roles)
+
+	disabledInput := widget.NewCheck("Disabled", nil)
+	disabledInput.SetChecked(euv.user.Disabled)
+
+	saveButton := widget.NewButton("Save", func() {
+		err := euv.cli.UpdateUser(key, disabledInput.Checked, rolesInput.Selected)
+		if err != nil {
+			euv.main.ShowError(err)
+			return
+		}
+		euv.onChanged()
+		euv.main.PopView()
+	})
+
+	resetTotpButton := widget.NewButton("Reset TOTP", func() {
+		err := euv.cli.ResetTotp(key)
+		if err != nil {
+			euv.main.ShowError(err)
+			return
+		}
+		euv.main.PopView()
+	})
+
+	deleteButton := widget.NewButton("Delete", func() {
+		err := euv.cli.DeleteUser(key)
+		if err != nil {
+			euv.main.ShowError(err)
+			return
+		}
+		euv.onChanged()
+		euv.main.PopView()
+	})
+	deleteButton.Importance = widget.DangerImportance
+	deleteButton.Disable()
+
+	confirmInput := widget.NewCheck("Devices enrolled by this user will lose access", func(checked bool) {
+		if checked {
+			deleteButton.Enable()
+		} else {
+			deleteButton.Disable()
+		}
+	})
+
+	return &editUserViewRenderer{
+		container: container.NewVBox(
+			widget.NewLabel("Edit User "+euv.user.Certificate.GetName()),
+			layout.NewSpacer(),
+			widget.NewLabel("Roles"),
+			rolesInput,
+			disabledInput,
+			saveButton,
+			widget.NewSeparator(),
+			widget.NewLabel("Let the user set up a new authenticator"),
+			resetTotpButton,
+			widget.NewSeparator(),
+			confirmInput,
+			deleteButton,
+			layout.NewSpacer(),
+		),
+	}
+}
+
+type editUserViewRenderer struct {
+	container *fyne.Container
+}
+
+func (r *editUserViewRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *editUserViewRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *editUserViewRenderer) Destroy() {
+}
+
+func (r *editUserViewRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *editUserViewRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
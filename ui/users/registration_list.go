@@ -0,0 +1,106 @@
+package users
+
+import (
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/components"
+)
+
+type registrationList struct {
+	widget.BaseWidget
+	cli *client.Client
+}
+
+func newRegistrationList(cli *client.Client) *registrationList {
+	r := &registrationList{
+		cli: cli,
+	}
+
+	r.ExtendBaseWidget(r)
+
+	return r
+}
+
+func (r *registrationList) CreateRenderer() fyne.WidgetRenderer {
+	table := components.NewTable[string, *system.Registration](
+		r.cli.Registrations(),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Username")
+			},
+			func(reg *system.Registration, label *widget.Label) {
+				label.SetText(reg.Username)
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Invited By")
+			},
+			func(reg *system.Registration, label *widget.Label) {
+				label.SetText(reg.Issuer.GetName())
+			},
+		),
+		components.Column(
+			func() *widget.Button {
+				return widget.NewButton("Approve", func() {
+
+				})
+			},
+			func(reg *system.Registration, button *widget.Button) {
+				button.OnTapped = func() {
+					err := r.cli.ApproveRegistration(reg)
+					if err != nil {
+						log.Printf("Error approving registration: %v", err)
+					}
+				}
+			},
+		),
+		components.Column(
+			func() *widget.Button {
+				return widget.NewButton("Reject", func() {
+
+				})
+			},
+			func(reg *system.Registration, button *widget.Button) {
+				button.OnTapped = func() {
+					err := r.cli.RejectRegistration(reg)
+					if err != nil {
+						log.Printf("Error rejecting registration: %v", err)
+					}
+				}
+			},
+		),
+	)
+
+	return &registrationListRenderer{
+		container: container.NewBorder(widget.NewLabel("Pending Registrations"), nil, nil, nil, table),
+	}
+}
+
+type registrationListRenderer struct {
+	container *fyne.Container
+}
+
+func (r *registrationListRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *registrationListRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *registrationListRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *registrationListRenderer) Destroy() {
+}
+
+func (r *registrationListRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
@@ -0,0 +1,167 @@
+package users
+
+import (
+	"log"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rahn-it/svalin/system"
+	"github.com/rahn-it/svalin/system/client"
+	"github.com/rahn-it/svalin/ui/components"
+	"github.com/rahn-it/svalin/ui/mainview.go"
+	"github.com/rahn-it/svalin/util"
+)
+
+var _ mainview.MenuView = (*userList)(nil)
+
+type userList struct {
+	widget.BaseWidget
+	main  *mainview.MainView
+	cli   *client.Client
+	users util.UpdateableMap[string, *system.UserInfo]
+}
+
+func (u *userList) Icon() fyne.Resource {
+	return theme.AccountIcon()
+}
+
+func (u *userList) Name() string {
+	return "Users"
+}
+
+func NewUserList(main *mainview.MainView, cli *client.Client) *userList {
+	u := &userList{
+		main:  main,
+		cli:   cli,
+		users: util.NewObservableMap[string, *system.UserInfo](),
+	}
+
+	u.ExtendBaseWidget(u)
+
+	go u.load()
+
+	return u
+}
+
+// load replaces the displayed users with the current list from the server.
+func (u *userList) load() {
+	users, err := u.cli.ListUsers()
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		return
+	}
+
+	current := make(map[string]bool)
+	for _, user := range users {
+		key := user.Certificate.PublicKey().Base64Encode()
+		current[key] = true
+		u.users.Set(key, user)
+	}
+
+	stale := make([]string, 0)
+	u.users.ForEach(func(key string, _ *system.UserInfo) error {
+		if !current[key] {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+
+	for _, key := range stale {
+		u.users.Delete(key)
+	}
+}
+
+func (u *userList) CreateRenderer() fyne.WidgetRenderer {
+	table := components.NewTable[string, *system.UserInfo](
+		u.users,
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Name")
+			},
+			func(user *system.UserInfo, label *widget.Label) {
+				label.SetText(user.Certificate.GetName())
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Roles")
+			},
+			func(user *system.UserInfo, label *widget.Label) {
+				label.SetText(strings.Join(user.Roles, ", "))
+			},
+		),
+		components.Column(
+			func() *widget.Label {
+				return widget.NewLabel("Status")
+			},
+			func(user *system.UserInfo, label *widget.Label) {
+				if user.Disabled {
+					label.SetText("Disabled")
+				} else {
+					label.SetText("Active")
+				}
+			},
+		),
+		components.Column(
+			func() *widget.Button {
+				return widget.NewButton("Edit", func() {
+
+				})
+			},
+			func(user *system.UserInfo, button *widget.Button) {
+				button.OnTapped = func() {
+					view := newEditUserView(u.main, u.cli, user, func() {
+						go u.load()
+					})
+					u.main.PushView(view)
+				}
+			},
+		),
+	)
+
+	refreshButton := widget.NewButtonWithIcon("Refresh", theme.ViewRefreshIcon(), func() {
+		go u.load()
+	})
+
+	inviteButton := widget.NewButtonWithIcon("Invite", theme.ContentAddIcon(), func() {
+		u.main.PushView(newCreateInviteView(u.cli))
+	})
+
+	registrationsButton := widget.NewButton("Registrations", func() {
+		u.main.PushView(newRegistrationList(u.cli))
+	})
+
+	return &userListRenderer{
+		container: container.NewBorder(
+			container.NewHBox(refreshButton, inviteButton, registrationsButton),
+			nil, nil, nil,
+			table,
+		),
+	}
+}
+
+type userListRenderer struct {
+	container *fyne.Container
+}
+
+func (r *userListRenderer) Layout(size fyne.Size) {
+	r.container.Resize(size)
+}
+
+func (r *userListRenderer) MinSize() fyne.Size {
+	return r.container.MinSize()
+}
+
+func (r *userListRenderer) Refresh() {
+	r.container.Refresh()
+}
+
+func (r *userListRenderer) Destroy() {
+}
+
+func (r *userListRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.container}
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var ErrMacInvalid = fmt.Errorf("message authentication failed")
+
+// MessageAuthenticator authenticates the messages of one direction of a session.
+// Every tag covers a sequence number, so messages can't be replayed, dropped or reordered.
+type MessageAuthenticator struct {
+	key   []byte
+	seq   uint64
+	mutex sync.Mutex
+}
+
+// DeriveMessageAuthenticators derives the authenticators for both directions from a shared secret.
+// The initiator sends with the key the other side receives with.
+func DeriveMessageAuthenticators(secret []byte, salt []byte, initiator bool) (send *MessageAuthenticator, receive *MessageAuthenticator, err error) {
+	kdf := hkdf.New(sha256.New, secret, salt, []byte("svalin session mac"))
+
+	initiatorKey := make([]byte, sha256.Size)
+	_, err = io.ReadFull(kdf, initiatorKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving key: %w", err)
+	}
+
+	responderKey := make([]byte, sha256.Size)
+	_, err = io.ReadFull(kdf, responderKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving key: %w", err)
+	}
+
+	if initiator {
+		return &MessageAuthenticator{key: initiatorKey}, &MessageAuthenticator{key: responderKey}, nil
+	}
+
+	return &MessageAuthenticator{key: responderKey}, &MessageAuthenticator{key: initiatorKey}, nil
+}
+
+func (m *MessageAuthenticator) tag(seq uint64, data []byte) []byte {
+	var seqBytes [8]byte
+	binary.BigEndian.PutUint64(seqBytes[:], seq)
+
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write(seqBytes[:])
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Tag returns the tag for the next message.
+func (m *MessageAuthenticator) Tag(data []byte) []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tag := m.tag(m.seq, data)
+	m.seq++
+	return tag
+}
+
+// Verify checks the tag of the next message, the sequence only advances for valid messages.
+func (m *MessageAuthenticator) Verify(data []byte, tag []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !hmac.Equal(m.tag(m.seq, data), tag) {
+		return ErrMacInvalid
+	}
+
+	m.seq++
+	return nil
+}
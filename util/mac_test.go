@@ -0,0 +1,63 @@
+package util_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rahn-it/svalin/util"
+)
+
+func TestMessageAuthenticator(t *testing.T) {
+	secret := []byte("shared secret")
+	salt := []byte("salt")
+
+	clientSend, clientReceive, err := util.DeriveMessageAuthenticators(secret, salt, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverSend, serverReceive, err := util.DeriveMessageAuthenticators(secret, salt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := []byte("first")
+	second := []byte("second")
+
+	firstTag := clientSend.Tag(first)
+	secondTag := clientSend.Tag(second)
+
+	// the second message can't be accepted first
+	err = serverReceive.Verify(second, secondTag)
+	if !errors.Is(err, util.ErrMacInvalid) {
+		t.Errorf("reordered message was accepted: %v", err)
+	}
+
+	err = serverReceive.Verify(first, firstTag)
+	if err != nil {
+		t.Fatalf("valid message was rejected: %v", err)
+	}
+
+	// replaying the first message fails after it was received
+	err = serverReceive.Verify(first, firstTag)
+	if !errors.Is(err, util.ErrMacInvalid) {
+		t.Errorf("replayed message was accepted: %v", err)
+	}
+
+	err = serverReceive.Verify(second, secondTag)
+	if err != nil {
+		t.Fatalf("valid message was rejected: %v", err)
+	}
+
+	// the directions use different keys
+	reflected := serverSend.Tag(first)
+	err = serverReceive.Verify(first, reflected)
+	if !errors.Is(err, util.ErrMacInvalid) {
+		t.Errorf("reflected message was accepted: %v", err)
+	}
+
+	err = clientReceive.Verify(first, reflected)
+	if err != nil {
+		t.Errorf("valid message was rejected: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package util
 
 import (
 	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"sync"
 	"time"
@@ -22,40 +21,125 @@ func NewNonce() (Nonce, error) {
 	return Nonce(nonce), nil
 }
 
+// NonceStorage remembers nonces for a fixed retention, after that the message timestamp check rejects replays.
+// Nonces are kept in buckets by the time they were seen, whole buckets are dropped once they are older than the retention.
+// It is safe for concurrent use, so one storage can be shared by all connections.
 type NonceStorage struct {
-	nonceMap map[string]int64
-	mutex    sync.RWMutex
+	retention time.Duration
+	width     time.Duration
+	buckets   map[int64]map[string]struct{}
+	mutex     sync.Mutex
 }
 
-func NewNonceStorage() *NonceStorage {
+const nonceBuckets = 4
+
+// SeenNonce is a nonce with the time it was first seen, used to persist a storage.
+type SeenNonce struct {
+	Nonce Nonce
+	Seen  time.Time
+}
+
+func NewNonceStorage(retention time.Duration) *NonceStorage {
+	width := retention / nonceBuckets
+	if width <= 0 {
+		width = time.Second
+	}
+
 	return &NonceStorage{
-		nonceMap: make(map[string]int64),
-		mutex:    sync.RWMutex{},
+		retention: retention,
+		width:     width,
+		buckets:   make(map[int64]map[string]struct{}),
 	}
 }
 
-func (s *NonceStorage) CheckNonce(nonce Nonce) bool {
-	key := base64.StdEncoding.EncodeToString(nonce)
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	_, ok := s.nonceMap[key]
-	return !ok
+func (s *NonceStorage) bucket(t time.Time) int64 {
+	return t.UnixNano() / int64(s.width)
+}
+
+// evict drops all buckets that only contain nonces older than the retention.
+func (s *NonceStorage) evict(now time.Time) {
+	oldest := s.bucket(now.Add(-s.retention))
+	for index := range s.buckets {
+		if index < oldest {
+			delete(s.buckets, index)
+		}
+	}
+}
+
+func (s *NonceStorage) add(key string, seen time.Time) {
+	index := s.bucket(seen)
+	bucket, ok := s.buckets[index]
+	if !ok {
+		bucket = make(map[string]struct{})
+		s.buckets[index] = bucket
+	}
+	bucket[key] = struct{}{}
+}
+
+// Use remembers the nonce and returns false if it was seen before.
+func (s *NonceStorage) Use(nonce Nonce) bool {
+	key := string(nonce)
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.evict(now)
+
+	for _, bucket := range s.buckets {
+		if _, ok := bucket[key]; ok {
+			return false
+		}
+	}
+
+	s.add(key, now)
+	return true
 }
 
-func (s *NonceStorage) AddNonce(nonce Nonce) {
-	key := base64.StdEncoding.EncodeToString(nonce)
+// Len returns the number of remembered nonces.
+func (s *NonceStorage) Len() int {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.nonceMap[key] = time.Now().Unix()
+
+	s.evict(time.Now())
+
+	n := 0
+	for _, bucket := range s.buckets {
+		n += len(bucket)
+	}
+	return n
 }
 
-func (s *NonceStorage) Cleanup(expiration int64) {
+// Export returns the nonces that are still remembered.
+// The time they were seen is rounded to their bucket.
+func (s *NonceStorage) Export() []SeenNonce {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	for key, timestamp := range s.nonceMap {
-		if timestamp < time.Now().Unix()-expiration {
-			delete(s.nonceMap, key)
+	s.evict(time.Now())
+
+	nonces := make([]SeenNonce, 0)
+	for index, bucket := range s.buckets {
+		seen := time.Unix(0, index*int64(s.width))
+		for key := range bucket {
+			nonces = append(nonces, SeenNonce{
+				Nonce: Nonce(key),
+				Seen:  seen,
+			})
 		}
 	}
+
+	return nonces
+}
+
+// Import remembers nonces exported before, e.g. by the last run of the process.
+func (s *NonceStorage) Import(nonces []SeenNonce) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, nonce := range nonces {
+		s.add(string(nonce.Nonce), nonce.Seen)
+	}
+
+	s.evict(time.Now())
 }
@@ -0,0 +1,43 @@
+package util_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/util"
+)
+
+func TestNonceStorage(t *testing.T) {
+	retention := 100 * time.Millisecond
+	storage := util.NewNonceStorage(retention)
+
+	nonce, err := util.NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !storage.Use(nonce) {
+		t.Fatalf("new nonce was rejected")
+	}
+
+	if storage.Use(nonce) {
+		t.Errorf("nonce was accepted twice")
+	}
+
+	restored := util.NewNonceStorage(retention)
+	restored.Import(storage.Export())
+
+	if restored.Use(nonce) {
+		t.Errorf("imported nonce was accepted")
+	}
+
+	time.Sleep(2 * retention)
+
+	if storage.Len() != 0 {
+		t.Errorf("expired nonces were not evicted, %d left", storage.Len())
+	}
+
+	if !storage.Use(nonce) {
+		t.Errorf("expired nonce was rejected")
+	}
+}
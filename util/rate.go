@@ -0,0 +1,80 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket, tokens refill at rate per second up to burst.
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (r *RateLimiter) refill() {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+}
+
+// Allow takes a token if one is left.
+func (r *RateLimiter) Allow() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.refill()
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+	return true
+}
+
+// Wait takes n tokens, even more than burst, and waits until the bucket is out of debt again.
+func (r *RateLimiter) Wait(ctx context.Context, n int) error {
+	r.mutex.Lock()
+	r.refill()
+	r.tokens -= float64(n)
+	debt := -r.tokens
+	r.mutex.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(debt / r.rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Idle reports whether the bucket refilled completely, so it can be dropped.
+func (r *RateLimiter) Idle() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.refill()
+	return r.tokens >= r.burst
+}
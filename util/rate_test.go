@@ -0,0 +1,55 @@
+package util_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rahn-it/svalin/util"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := util.NewRateLimiter(10, 3)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow() {
+			t.Fatalf("token %d of the burst was refused", i)
+		}
+	}
+
+	if limiter.Allow() {
+		t.Errorf("token beyond the burst was allowed")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if !limiter.Allow() {
+		t.Errorf("token was not refilled")
+	}
+
+	if limiter.Idle() {
+		t.Errorf("limiter is idle although tokens were taken")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter := util.NewRateLimiter(1000, 100)
+
+	start := time.Now()
+	err := limiter.Wait(context.Background(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if waited := time.Since(start); waited < 80*time.Millisecond {
+		t.Errorf("waited only %s for a debt of 100 tokens", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = limiter.Wait(ctx, 1000)
+	if err != context.Canceled {
+		t.Errorf("expected the canceled context, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"crypto/subtle"
+	"log"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// ValidateTotpStep checks a TOTP code, allowing it to be off by up to skew periods.
+// It returns the time step the code belongs to, so a code can't be used twice.
+func ValidateTotpStep(url string, code string, skew uint) (uint64, bool) {
+	return validateTotpStepAt(url, code, skew, time.Now().UTC())
+}
+
+func validateTotpStepAt(url string, code string, skew uint, now time.Time) (uint64, bool) {
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		log.Printf("Error decoding TOTP: %s", err)
+		return 0, false
+	}
+
+	period := uint64(key.Period())
+	if period == 0 {
+		period = 30
+	}
+
+	opts := totp.ValidateOpts{
+		Period:    uint(period),
+		Digits:    key.Digits(),
+		Algorithm: key.Algorithm(),
+	}
+
+	current := uint64(now.Unix()) / period
+
+	for offset := -int64(skew); offset <= int64(skew); offset++ {
+		step := uint64(int64(current) + offset)
+
+		expected, err := totp.GenerateCodeCustom(key.Secret(), time.Unix(int64(step*period), 0).UTC(), opts)
+		if err != nil {
+			log.Printf("Error generating TOTP: %s", err)
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package util_test
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/rahn-it/svalin/util"
+)
+
+func TestValidateTotpStep(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      "test",
+		AccountName: "test",
+		Rand:        rand.Reader,
+		Period:      30,
+		Digits:      otp.DigitsEight,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := totp.ValidateOpts{
+		Period:    30,
+		Digits:    otp.DigitsEight,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	now := time.Now().UTC()
+	current := uint64(now.Unix()) / 30
+
+	code, err := totp.GenerateCodeCustom(key.Secret(), now, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := totp.GenerateCodeCustom(key.Secret(), now.Add(-30*time.Second), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := util.ValidateTotpStep(key.URL(), code, 1)
+	if !ok {
+		t.Fatalf("expected current code to be valid")
+	}
+
+	if uint64(time.Now().Unix())/30 != current {
+		t.Skip("time step changed during the test")
+	}
+
+	if step != current {
+		t.Errorf("expected step %d, got %d", current, step)
+	}
+
+	step, ok = util.ValidateTotpStep(key.URL(), previous, 1)
+	if !ok || step != current-1 {
+		t.Errorf("expected previous code to be valid with skew 1 in step %d, got %d", current-1, step)
+	}
+
+	if previous != code {
+		_, ok = util.ValidateTotpStep(key.URL(), previous, 0)
+		if ok {
+			t.Errorf("expected previous code to be rejected without skew")
+		}
+	}
+}